@@ -76,6 +76,7 @@ import (
 	Responses:
 		202: BulkRequestResponse
 		400: badRequestResponse
+		403: forbiddenResponse
 		500: errorResponse
 */
 
@@ -99,6 +100,7 @@ func bulkEOBRequest(w http.ResponseWriter, r *http.Request) {
 	Responses:
 		202: BulkRequestResponse
 		400: badRequestResponse
+		403: forbiddenResponse
 		500: errorResponse
 */
 func bulkPatientRequest(w http.ResponseWriter, r *http.Request) {
@@ -121,6 +123,7 @@ func bulkPatientRequest(w http.ResponseWriter, r *http.Request) {
 	Responses:
 		202: BulkRequestResponse
 		400: badRequestResponse
+		403: forbiddenResponse
 		500: errorResponse
 */
 func bulkCoverageRequest(w http.ResponseWriter, r *http.Request) {
@@ -236,6 +239,7 @@ func bulkRequest(t string, w http.ResponseWriter, r *http.Request) {
 		202: jobStatusResponse
 		200: completedJobResponse
 		400: badRequestResponse
+		403: forbiddenResponse
 		404: notFoundResponse
 		410: goneResponse
 		500: errorResponse
@@ -299,11 +303,12 @@ func jobStatus(w http.ResponseWriter, r *http.Request) {
 		var jobKeysObj []models.JobKey
 		db.Find(&jobKeysObj, "job_id = ?", job.ID)
 		for _, jobKey := range jobKeysObj {
-			keyMap[strings.TrimSpace(jobKey.FileName)] = hex.EncodeToString(jobKey.EncryptedKey)
+			encryptedKey := encodeEncryptedKey(jobKey)
+			keyMap[strings.TrimSpace(jobKey.FileName)] = encryptedKey
 			fi := fileItem{
 				Type:         resourceType,
 				URL:          fmt.Sprintf("%s://%s/data/%s/%s", scheme, r.Host, jobID, strings.TrimSpace(jobKey.FileName)),
-				EncryptedKey: hex.EncodeToString(jobKey.EncryptedKey),
+				EncryptedKey: encryptedKey,
 			}
 			files = append(files, fi)
 		}
@@ -369,6 +374,7 @@ func jobStatus(w http.ResponseWriter, r *http.Request) {
 	Responses:
 		200: ExplanationOfBenefitNDJSON
 		400: badRequestResponse
+		403: forbiddenResponse
         404: notFoundResponse
 		500: errorResponse
 */
@@ -501,7 +507,7 @@ type fileItem struct {
 	Type string `json:"type"`
 	// URL of the file
 	URL string `json:"url"`
-	// Encrypted Symmetric Key used to encrypt this file
+	// Encrypted Symmetric Key used to encrypt this file; hex encoded, or a compact JWE for ACOs using JWE output
 	EncryptedKey string `json:"encryptedKey"`
 }
 
@@ -530,6 +536,14 @@ type bulkResponseBody struct {
 	JobID  uint
 }
 
+// JWE keys are already a compact serialization; keys in the original BCDA format are raw bytes and are hex encoded
+func encodeEncryptedKey(jobKey models.JobKey) string {
+	if jobKey.EncryptionFormat == models.EncryptionFormatJWE {
+		return string(jobKey.EncryptedKey)
+	}
+	return hex.EncodeToString(jobKey.EncryptedKey)
+}
+
 func readAuthData(r *http.Request) (data auth.AuthData, err error) {
 	var ok bool
 	data, ok = r.Context().Value("ad").(auth.AuthData)
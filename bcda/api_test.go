@@ -465,6 +465,42 @@ func (s *APITestSuite) TestJobStatusCompleted() {
 	s.db.Delete(&j)
 }
 
+func (s *APITestSuite) TestJobStatusCompletedJWE() {
+	j := models.Job{
+		ACOID:      uuid.Parse("DBBD1CE1-AE24-435C-807D-ED45953077D3"),
+		UserID:     uuid.Parse("82503A18-BF3B-436D-BA7B-BAE09B7FFD2F"),
+		RequestURL: "/api/v1/ExplanationOfBenefit/$export",
+		Status:     "Completed",
+	}
+	s.db.Save(&j)
+	defer s.db.Delete(&j)
+
+	fileName := fmt.Sprintf("%s.ndjson", uuid.NewRandom().String())
+	compactJWE := "eyJhbGciOiJSU0EtT0FFUC0yNTYiLCJlbmMiOiJBMjU2R0NNIn0.a2V5.aXY.Y2lwaGVydGV4dA.dGFn"
+	jobKey := models.JobKey{JobID: j.ID, EncryptedKey: []byte(compactJWE), FileName: fileName, EncryptionFormat: models.EncryptionFormatJWE}
+	assert.Nil(s.T(), s.db.Save(&jobKey).Error)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/jobs/%d", j.ID), nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("jobID", fmt.Sprint(j.ID))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	ad := makeContextValues("DBBD1CE1-AE24-435C-807D-ED45953077D3", "82503A18-BF3B-436D-BA7B-BAE09B7FFD2F")
+	req = req.WithContext(context.WithValue(req.Context(), "ad", ad))
+
+	http.HandlerFunc(jobStatus).ServeHTTP(s.rr, req)
+	assert.Equal(s.T(), http.StatusOK, s.rr.Code)
+
+	var rb bulkResponseBody
+	err := json.Unmarshal(s.rr.Body.Bytes(), &rb)
+	if err != nil {
+		s.T().Error(err)
+	}
+
+	assert.Len(s.T(), rb.Files, 1)
+	assert.Equal(s.T(), compactJWE, rb.Files[0].EncryptedKey)
+	assert.Equal(s.T(), compactJWE, rb.KeyMap[fileName])
+}
+
 func (s *APITestSuite) TestJobStatusCompletedErrorFileExists() {
 	j := models.Job{
 		ACOID:      uuid.Parse("DBBD1CE1-AE24-435C-807D-ED45953077D3"),
@@ -669,7 +705,7 @@ func (s *APITestSuite) TestAuthTokenSuccess() {
 	// Create and verify new credentials
 	t := TokenResponse{}
 	outputPattern := regexp.MustCompile(`.+\n(.+)\n(.+)`)
-	tokenResp, _ := createAlphaToken(60, "Dev")
+	tokenResp, _ := createAlphaToken(60, "Dev", "")
 	assert.Regexp(s.T(), outputPattern, tokenResp)
 	matches := outputPattern.FindSubmatch([]byte(tokenResp))
 	clientID := string(matches[1])
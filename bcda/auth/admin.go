@@ -0,0 +1,556 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+)
+
+// The administrative API manages ACOs, their users, and their clients. It is used with admin tokens, which are
+// issued to admin clients from /auth/admin/token and are distinct from the tokens ACOs use: they name the admin
+// audience and carry the admin scope, and they name no ACO, so the API will not accept them. Every change made
+// through the API is recorded as an AdminAuditEvent.
+const (
+	AdminScope    = "bcda_admin"
+	adminAudience = "bcda-admin"
+	adminTokenTTL = 15 * time.Minute
+	// the actor recorded for changes made with the CLI
+	cliActor = "cli"
+)
+
+var cmsIDFormat = regexp.MustCompile(`^A\d{4}$`)
+
+// CreateAdminClient creates a credential for the administrative API
+func CreateAdminClient(name string) (Credentials, error) {
+	if name == "" {
+		return Credentials{}, errors.New("admin client name required")
+	}
+
+	secret, err := generateClientSecret()
+	if err != nil {
+		return Credentials{}, err
+	}
+	hash, err := NewHash(secret)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	ac := AdminClient{ClientID: uuid.NewRandom().String(), Name: name, SecretHash: hash.String(), Active: true}
+	err = db.Create(&ac).Error
+	audit(cliActor, "create-admin-client", ac.ClientID, "", err)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	return Credentials{ClientID: ac.ClientID, ClientSecret: secret, ClientName: name}, nil
+}
+
+// DeactivateAdminClient disables an admin client; its outstanding admin tokens are rejected
+func DeactivateAdminClient(clientID string) error {
+	if clientID == "" {
+		return errors.New("admin client ID required")
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	result := db.Model(&AdminClient{}).Where("client_id = ? and active = ?", clientID, true).Update("active", false)
+	err := result.Error
+	if err == nil && result.RowsAffected == 0 {
+		err = fmt.Errorf("no active admin client %s", clientID)
+	}
+	audit(cliActor, "deactivate-admin-client", clientID, "", err)
+	return err
+}
+
+// audit records a change made through the administrative API or CLI, or a request refused by an ACO's address
+// allowlist. err is the outcome of the change; nil if it succeeded. Failure to record the event is logged, as the
+// change has already been made.
+func audit(actor, action, target, remoteAddr string, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = err.Error()
+	}
+
+	entry := log.WithFields(log.Fields{"actor": actor, "action": action, "target": target, "outcome": outcome})
+	entry.Info("admin action")
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	event := AdminAuditEvent{Actor: actor, Action: action, Target: target, Outcome: outcome, RemoteAddr: remoteAddr}
+	if err := db.Create(&event).Error; err != nil {
+		entry.Errorf("unable to record admin audit event; %s", err)
+	}
+}
+
+/*
+	GetAdminToken verifies Basic authentication credentials of an admin client, and returns a short-lived JWT
+	bearer token for the administrative API.
+*/
+func GetAdminToken(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var ac AdminClient
+	if db.First(&ac, "client_id = ? and active = ?", clientID, true).RecordNotFound() || !Hash(ac.SecretHash).IsHashOf(secret) {
+		log.WithField("client_id", clientID).Error("invalid admin credentials")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	token, err := generateAdminTokenString(uuid.NewRandom().String(), ac.ClientID, now.Unix(), now.Add(adminTokenTTL).Unix())
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeToken(w, token, int64(adminTokenTTL.Seconds()), AdminScope)
+	log.WithField("client_id", clientID).Println("issued admin token")
+}
+
+// RequireAdmin lets through only requests bearing a valid admin token, putting the admin client's ID in the
+// request context
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminID, err := validateAdminToken(bearerToken(r))
+		if err != nil {
+			log.Errorf("invalid admin token; %s", err)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "admin", adminID)))
+	})
+}
+
+func bearerToken(r *http.Request) string {
+	m := regexp.MustCompile(`^Bearer (\S+)$`).FindStringSubmatch(r.Header.Get("Authorization"))
+	if len(m) < 2 {
+		return ""
+	}
+	return m[1]
+}
+
+// validateAdminToken returns the ID of the admin client an admin token was issued to
+func validateAdminToken(tokenString string) (string, error) {
+	if tokenString == "" {
+		return "", errors.New("no bearer token")
+	}
+
+	t, err := AlphaAuthPlugin{}.DecodeJWT(tokenString)
+	if err != nil {
+		return "", err
+	}
+
+	c := t.Claims.(*CommonClaims)
+	iss, err := alphaIssuer()
+	if err != nil {
+		return "", err
+	}
+	if c.Issuer != iss {
+		return "", fmt.Errorf("invalid iss claim; %s <> %s", c.Issuer, iss)
+	}
+	if c.Audience != adminAudience {
+		return "", fmt.Errorf("invalid aud claim; %s <> %s", c.Audience, adminAudience)
+	}
+	hasScope := false
+	for _, s := range c.Scopes {
+		hasScope = hasScope || s == AdminScope
+	}
+	if !hasScope {
+		return "", fmt.Errorf("token does not have the %s scope", AdminScope)
+	}
+	if c.ExpiresAt == 0 || c.Subject == "" {
+		return "", errors.New("missing one or more required claims")
+	}
+	if err = c.Valid(); err != nil {
+		return "", err
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var count int
+	if err = db.Model(&AdminClient{}).Where("client_id = ? and active = ?", c.Subject, true).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", fmt.Errorf("admin client %s is not active", c.Subject)
+	}
+	return c.Subject, nil
+}
+
+// adminACO is an ACO as the administrative API shows it, without its secret
+type adminACO struct {
+	UUID             string  `json:"uuid"`
+	CMSID            *string `json:"cms_id"`
+	Name             string  `json:"name"`
+	ClientID         string  `json:"client_id"`
+	AuthProvider     string  `json:"auth_provider"`
+	EncryptionFormat string  `json:"encryption_format"`
+}
+
+func toAdminACO(aco models.ACO) adminACO {
+	return adminACO{aco.UUID.String(), aco.CMSID, aco.Name, aco.ClientID, aco.AuthProvider, aco.EncryptionFormat}
+}
+
+type adminUser struct {
+	UUID  string `json:"uuid"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	ACOID string `json:"aco_id"`
+}
+
+func toAdminUser(user models.User) adminUser {
+	return adminUser{user.UUID.String(), user.Name, user.Email, user.ACOID.String()}
+}
+
+// AdminListACOs lists every ACO, with the client registered for it, if any
+func AdminListACOs(w http.ResponseWriter, r *http.Request) {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var acos []models.ACO
+	if err := db.Order("name").Find(&acos).Error; err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]adminACO, 0, len(acos))
+	for _, aco := range acos {
+		result = append(result, toAdminACO(aco))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// AdminCreateACO creates an ACO from a JSON object holding its name and, optionally, its CMS ID
+func AdminCreateACO(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name  string `json:"name"`
+		CMSID string `json:"cms_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body; %s", err), http.StatusBadRequest)
+		return
+	}
+	if body.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	var cmsID *string
+	if body.CMSID != "" {
+		if !cmsIDFormat.MatchString(body.CMSID) {
+			http.Error(w, "cms_id is invalid", http.StatusBadRequest)
+			return
+		}
+		cmsID = &body.CMSID
+	}
+
+	acoUUID, err := models.CreateACO(body.Name, cmsID)
+	audit(adminID(r), "create-aco", acoUUID.String(), r.RemoteAddr, err)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	aco, err := getACOFromDB(acoUUID.String())
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, toAdminACO(aco))
+}
+
+// AdminListUsers lists the users of an ACO
+func AdminListUsers(w http.ResponseWriter, r *http.Request) {
+	aco, ok := adminACOFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var users []models.User
+	if err := db.Order("name").Find(&users, "aco_id = ?", aco.UUID).Error; err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]adminUser, 0, len(users))
+	for _, user := range users {
+		result = append(result, toAdminUser(user))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// AdminCreateUser creates a user of an ACO from a JSON object holding the user's name and email address
+func AdminCreateUser(w http.ResponseWriter, r *http.Request) {
+	aco, ok := adminACOFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body; %s", err), http.StatusBadRequest)
+		return
+	}
+	if body.Name == "" || body.Email == "" {
+		http.Error(w, "name and email are required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := models.CreateUser(body.Name, body.Email, aco.UUID)
+	audit(adminID(r), "create-user", user.UUID.String(), r.RemoteAddr, err)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, toAdminUser(user))
+}
+
+// AdminRegisterClient registers a client for an ACO with the current auth provider, returning its credentials.
+// The request body may be a JSON object holding the scope the client's tokens are limited to.
+func AdminRegisterClient(w http.ResponseWriter, r *http.Request) {
+	aco, ok := adminACOFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Scope string `json:"scope"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body; %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if _, err := NormalizeScopes(body.Scope); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if aco.ClientID != "" {
+		http.Error(w, fmt.Sprintf("ACO %s already has client %s", aco.UUID, aco.ClientID), http.StatusConflict)
+		return
+	}
+
+	creds, err := registerClient(aco, body.Scope)
+	audit(adminID(r), "register-client", aco.UUID.String(), r.RemoteAddr, err)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"client_id":     creds.ClientID,
+		"client_secret": creds.ClientSecret,
+		"client_name":   creds.ClientName,
+	})
+}
+
+func registerClient(aco models.ACO, scope string) (Credentials, error) {
+	creds, err := GetProvider().RegisterClient(aco.UUID.String())
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	// RegisterClient may have changed the ACO, so only the columns set here are saved
+	err = db.Model(&aco).Updates(map[string]interface{}{"client_id": creds.ClientID, "auth_provider": GetProviderName()}).Error
+	if err != nil {
+		return Credentials{}, err
+	}
+	notifyACOChanged(db, aco.UUID.String())
+
+	if scope != "" {
+		if err = SetClientScope(creds.ClientID, scope); err != nil {
+			return Credentials{}, err
+		}
+	}
+	return creds, nil
+}
+
+// AdminDeactivateClient deletes an ACO's client from its auth provider and revokes the ACO's tokens
+func AdminDeactivateClient(w http.ResponseWriter, r *http.Request) {
+	aco, ok := adminACOFromURL(w, r)
+	if !ok {
+		return
+	}
+	if aco.ClientID == "" {
+		http.Error(w, fmt.Sprintf("ACO %s has no client", aco.UUID), http.StatusNotFound)
+		return
+	}
+
+	err := deactivateClient(aco)
+	audit(adminID(r), "deactivate-client", aco.UUID.String(), r.RemoteAddr, err)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deactivateClient(aco models.ACO) error {
+	name := aco.AuthProvider
+	if name == "" {
+		name = GetProviderName()
+	}
+	if err := providerNamed(name).DeleteClient(aco.ClientID); err != nil {
+		return err
+	}
+
+	_, err := RevokeACOTokens(aco.UUID.String(), "client deactivated")
+	return err
+}
+
+// AdminDeactivateACO deactivates an ACO and its users, deleting its client, if any, and revoking its tokens
+func AdminDeactivateACO(w http.ResponseWriter, r *http.Request) {
+	aco, ok := adminACOFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := deactivateACO(aco)
+	audit(adminID(r), "deactivate-aco", aco.UUID.String(), r.RemoteAddr, err)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deactivateACO(aco models.ACO) error {
+	if aco.ClientID != "" {
+		if err := deactivateClient(aco); err != nil {
+			return err
+		}
+	} else if _, err := RevokeACOTokens(aco.UUID.String(), "ACO deactivated"); err != nil {
+		return err
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	tx := db.Begin()
+	if err := tx.Where("aco_id = ?", aco.UUID).Delete(&models.User{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("uuid = ?", aco.UUID).Delete(&models.ACO{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	notifyACOChanged(db, aco.UUID.String())
+	return nil
+}
+
+// AdminDeactivateUser deactivates a user of an ACO
+func AdminDeactivateUser(w http.ResponseWriter, r *http.Request) {
+	aco, ok := adminACOFromURL(w, r)
+	if !ok {
+		return
+	}
+	userID := chi.URLParam(r, "userID")
+	if uuid.Parse(userID) == nil {
+		http.Error(w, "user ID must be a UUID", http.StatusBadRequest)
+		return
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var user models.User
+	if db.First(&user, "uuid = ? and aco_id = ?", userID, aco.UUID).RecordNotFound() {
+		http.Error(w, fmt.Sprintf("ACO %s has no user %s", aco.UUID, userID), http.StatusNotFound)
+		return
+	}
+
+	err := db.Delete(&user).Error
+	audit(adminID(r), "deactivate-user", user.UUID.String(), r.RemoteAddr, err)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	// requests made with the ACO's credentials may have been acting as the user
+	notifyACOChanged(db, aco.UUID.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func adminID(r *http.Request) string {
+	id, _ := r.Context().Value("admin").(string)
+	return id
+}
+
+// adminACOFromURL returns the ACO named by the acoID URL parameter, responding with an error if there is none
+func adminACOFromURL(w http.ResponseWriter, r *http.Request) (models.ACO, bool) {
+	acoID := chi.URLParam(r, "acoID")
+	if uuid.Parse(acoID) == nil {
+		http.Error(w, "ACO ID must be a UUID", http.StatusBadRequest)
+		return models.ACO{}, false
+	}
+
+	aco, err := getACOFromDB(acoID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return models.ACO{}, false
+	}
+	return aco, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err = w.Write(body); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,206 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/CMSgov/bcda-app/bcda/auth"
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+	"github.com/CMSgov/bcda-app/bcda/testUtils"
+)
+
+type AdminAPITestSuite struct {
+	testUtils.AuthTestSuite
+	db     *gorm.DB
+	router http.Handler
+	admin  auth.Credentials
+	token  string
+}
+
+func (s *AdminAPITestSuite) SetupSuite() {
+	models.InitializeGormModels()
+	auth.InitializeGormModels()
+	s.SetupAuthBackend()
+}
+
+func (s *AdminAPITestSuite) SetupTest() {
+	s.db = database.GetGORMDbConnection()
+	s.router = auth.NewAuthRouter()
+
+	var err error
+	s.admin, err = auth.CreateAdminClient("Admin API Test")
+	require.Nil(s.T(), err)
+
+	req := httptest.NewRequest("POST", "/auth/admin/token", nil)
+	req.SetBasicAuth(s.admin.ClientID, s.admin.ClientSecret)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	require.Equal(s.T(), http.StatusOK, rr.Code)
+	t := TokenResponse{}
+	require.NoError(s.T(), json.NewDecoder(rr.Body).Decode(&t))
+	assert.Equal(s.T(), auth.AdminScope, t.Scope)
+	s.token = t.AccessToken
+}
+
+func (s *AdminAPITestSuite) TearDownTest() {
+	s.db.Unscoped().Delete(&auth.AdminAuditEvent{}, "actor in (?)", []string{s.admin.ClientID, "cli"})
+	s.db.Unscoped().Delete(&auth.AdminClient{}, "client_id = ?", s.admin.ClientID)
+	database.Close(s.db)
+}
+
+func (s *AdminAPITestSuite) do(verb, path, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(verb, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func (s *AdminAPITestSuite) auditCount(action, target string) int {
+	var count int
+	s.db.Model(&auth.AdminAuditEvent{}).Where("actor = ? and action = ? and target = ? and outcome = ?", s.admin.ClientID, action, target, "success").Count(&count)
+	return count
+}
+
+func (s *AdminAPITestSuite) TestGetAdminToken() {
+	req := httptest.NewRequest("POST", "/auth/admin/token", nil)
+	req.SetBasicAuth(s.admin.ClientID, "not the secret")
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+
+	assert.Nil(s.T(), auth.DeactivateAdminClient(s.admin.ClientID))
+	req = httptest.NewRequest("POST", "/auth/admin/token", nil)
+	req.SetBasicAuth(s.admin.ClientID, s.admin.ClientSecret)
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+
+	// outstanding tokens of a deactivated client are rejected
+	rr = s.do("GET", "/auth/admin/acos", "", s.token)
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+
+	assert.EqualError(s.T(), auth.DeactivateAdminClient(s.admin.ClientID), fmt.Sprintf("no active admin client %s", s.admin.ClientID))
+}
+
+func (s *AdminAPITestSuite) TestACOTokensAreNotAdminTokens() {
+	acoToken, err := auth.TokenStringWithIDs(uuid.NewRandom().String(), "DBBD1CE1-AE24-435C-807D-ED45953077D3")
+	require.Nil(s.T(), err)
+	rr := s.do("GET", "/auth/admin/acos", "", acoToken)
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+}
+
+func (s *AdminAPITestSuite) TestManageACO() {
+	rr := s.do("POST", "/auth/admin/acos", `{"name": "Admin API ACO"}`, s.token)
+	require.Equal(s.T(), http.StatusCreated, rr.Code)
+	var aco struct {
+		UUID     string `json:"uuid"`
+		Name     string `json:"name"`
+		ClientID string `json:"client_id"`
+	}
+	require.NoError(s.T(), json.NewDecoder(rr.Body).Decode(&aco))
+	assert.Equal(s.T(), "Admin API ACO", aco.Name)
+	defer func() {
+		s.db.Unscoped().Delete(&auth.Token{}, "aco_id = ?", aco.UUID)
+		s.db.Unscoped().Delete(&models.User{}, "aco_id = ?", aco.UUID)
+		s.db.Unscoped().Delete(&models.ACO{}, "uuid = ?", aco.UUID)
+		s.db.Unscoped().Delete(&auth.AdminAuditEvent{}, "target = ?", aco.UUID)
+	}()
+	assert.Equal(s.T(), 1, s.auditCount("create-aco", aco.UUID))
+
+	rr = s.do("GET", "/auth/admin/acos", "", s.token)
+	assert.Equal(s.T(), http.StatusOK, rr.Code)
+	assert.Contains(s.T(), rr.Body.String(), aco.UUID)
+	assert.NotContains(s.T(), rr.Body.String(), "alpha_secret")
+
+	// users
+	email := fmt.Sprintf("%s@example.com", uuid.NewRandom().String())
+	rr = s.do("POST", "/auth/admin/acos/"+aco.UUID+"/users", fmt.Sprintf(`{"name": "Admin API User", "email": "%s"}`, email), s.token)
+	require.Equal(s.T(), http.StatusCreated, rr.Code)
+	var user struct {
+		UUID string `json:"uuid"`
+	}
+	require.NoError(s.T(), json.NewDecoder(rr.Body).Decode(&user))
+	defer s.db.Unscoped().Delete(&auth.AdminAuditEvent{}, "target = ?", user.UUID)
+	rr = s.do("GET", "/auth/admin/acos/"+aco.UUID+"/users", "", s.token)
+	assert.Equal(s.T(), http.StatusOK, rr.Code)
+	assert.Contains(s.T(), rr.Body.String(), email)
+
+	rr = s.do("DELETE", "/auth/admin/acos/"+aco.UUID+"/users/"+user.UUID, "", s.token)
+	assert.Equal(s.T(), http.StatusNoContent, rr.Code)
+	assert.Equal(s.T(), 1, s.auditCount("deactivate-user", user.UUID))
+	rr = s.do("GET", "/auth/admin/acos/"+aco.UUID+"/users", "", s.token)
+	assert.NotContains(s.T(), rr.Body.String(), email)
+	rr = s.do("DELETE", "/auth/admin/acos/"+aco.UUID+"/users/"+user.UUID, "", s.token)
+	assert.Equal(s.T(), http.StatusNotFound, rr.Code)
+
+	// clients
+	rr = s.do("POST", "/auth/admin/acos/"+aco.UUID+"/client", `{"scope": "system/Coverage.read"}`, s.token)
+	require.Equal(s.T(), http.StatusCreated, rr.Code)
+	var creds map[string]string
+	require.NoError(s.T(), json.NewDecoder(rr.Body).Decode(&creds))
+	assert.NotEmpty(s.T(), creds["client_secret"])
+	defer s.db.Unscoped().Delete(&auth.ClientScope{}, "client_id = ?", creds["client_id"])
+	assert.Equal(s.T(), 1, s.auditCount("register-client", aco.UUID))
+
+	rr = s.do("POST", "/auth/admin/acos/"+aco.UUID+"/client", "", s.token)
+	assert.Equal(s.T(), http.StatusConflict, rr.Code)
+
+	accessToken, err := auth.GetProvider().MakeAccessToken(auth.Credentials{ClientID: creds["client_id"], ClientSecret: creds["client_secret"]})
+	require.Nil(s.T(), err)
+	assert.Nil(s.T(), auth.GetProvider().ValidateJWT(accessToken))
+
+	rr = s.do("DELETE", "/auth/admin/acos/"+aco.UUID+"/client", "", s.token)
+	assert.Equal(s.T(), http.StatusNoContent, rr.Code)
+	assert.Equal(s.T(), 1, s.auditCount("deactivate-client", aco.UUID))
+	assert.NotNil(s.T(), auth.GetProvider().ValidateJWT(accessToken))
+
+	rr = s.do("DELETE", "/auth/admin/acos/"+aco.UUID+"/client", "", s.token)
+	assert.Equal(s.T(), http.StatusNotFound, rr.Code)
+
+	// the ACO itself
+	rr = s.do("DELETE", "/auth/admin/acos/"+aco.UUID, "", s.token)
+	assert.Equal(s.T(), http.StatusNoContent, rr.Code)
+	assert.Equal(s.T(), 1, s.auditCount("deactivate-aco", aco.UUID))
+	rr = s.do("GET", "/auth/admin/acos", "", s.token)
+	assert.NotContains(s.T(), rr.Body.String(), aco.UUID)
+	rr = s.do("DELETE", "/auth/admin/acos/"+aco.UUID, "", s.token)
+	assert.Equal(s.T(), http.StatusNotFound, rr.Code)
+}
+
+func (s *AdminAPITestSuite) TestInvalidRequests() {
+	rr := s.do("POST", "/auth/admin/acos", `{"cms_id": "A1234"}`, s.token)
+	assert.Equal(s.T(), http.StatusBadRequest, rr.Code)
+
+	rr = s.do("POST", "/auth/admin/acos", `{"name": "Bad CMS ID", "cms_id": "1234"}`, s.token)
+	assert.Equal(s.T(), http.StatusBadRequest, rr.Code)
+
+	rr = s.do("GET", "/auth/admin/acos/not-a-uuid/users", "", s.token)
+	assert.Equal(s.T(), http.StatusBadRequest, rr.Code)
+
+	rr = s.do("GET", "/auth/admin/acos/"+uuid.NewRandom().String()+"/users", "", s.token)
+	assert.Equal(s.T(), http.StatusNotFound, rr.Code)
+
+	rr = s.do("DELETE", "/auth/admin/acos/DBBD1CE1-AE24-435C-807D-ED45953077D3/users/not-a-uuid", "", s.token)
+	assert.Equal(s.T(), http.StatusBadRequest, rr.Code)
+
+	rr = s.do("POST", "/auth/admin/acos/DBBD1CE1-AE24-435C-807D-ED45953077D3/client", `{"scope": "system/Observation.read"}`, s.token)
+	assert.Equal(s.T(), http.StatusBadRequest, rr.Code)
+}
+
+func TestAdminAPITestSuite(t *testing.T) {
+	suite.Run(t, new(AdminAPITestSuite))
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/responseutils"
+	"github.com/CMSgov/bcda-app/bcda/utils"
+)
+
+// An ACO may limit the addresses its credentials work from to a list of CIDR ranges. Tokens are neither issued to
+// nor accepted from other addresses. ACOs with no ranges are not limited.
+
+// AddAllowedRange adds the CIDR range cidr to the addresses the credentials of the ACO with acoID work from
+func AddAllowedRange(acoID, cidr string) error {
+	aco, err := getACOFromDB(acoID)
+	if err != nil {
+		return err
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR range %s", cidr)
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	ar := AllowedRange{ACOID: aco.UUID, CIDR: ipNet.String()}
+	if !db.First(&AllowedRange{}, "aco_id = ? and cidr = ?", ar.ACOID, ar.CIDR).RecordNotFound() {
+		err = fmt.Errorf("%s is already allowed for ACO %s", ar.CIDR, acoID)
+	} else {
+		err = db.Create(&ar).Error
+	}
+	if err == nil {
+		notifyACOChanged(db, aco.UUID.String())
+	}
+	audit(cliActor, "allow-ip-range", acoID+" "+ar.CIDR, "", err)
+	return err
+}
+
+// RemoveAllowedRange removes the CIDR range cidr from the addresses the credentials of the ACO with acoID work
+// from. Removing its last range leaves the ACO unlimited.
+func RemoveAllowedRange(acoID, cidr string) error {
+	aco, err := getACOFromDB(acoID)
+	if err != nil {
+		return err
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR range %s", cidr)
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	result := db.Unscoped().Where("aco_id = ? and cidr = ?", aco.UUID, ipNet.String()).Delete(AllowedRange{})
+	err = result.Error
+	if err == nil && result.RowsAffected == 0 {
+		err = fmt.Errorf("%s is not allowed for ACO %s", ipNet.String(), acoID)
+	}
+	if err == nil {
+		notifyACOChanged(db, aco.UUID.String())
+	}
+	audit(cliActor, "remove-ip-range", acoID+" "+ipNet.String(), "", err)
+	return err
+}
+
+// AllowedRanges returns the CIDR ranges the credentials of the ACO with acoID work from; none if it is not limited
+func AllowedRanges(acoID string) ([]string, error) {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var ranges []string
+	err := db.Model(&AllowedRange{}).Where("aco_id = ?", acoID).Order("id").Pluck("cidr", &ranges).Error
+	return ranges, err
+}
+
+// checkAllowedAddress returns an error unless the ACO with acoID may use its credentials from address
+func checkAllowedAddress(acoID, address string) error {
+	ranges, err := lookupAllowedRanges(acoID)
+	if err != nil {
+		return err
+	}
+	if len(ranges) == 0 {
+		return nil
+	}
+	if inRanges(address, parseRanges(ranges)) {
+		return nil
+	}
+	return fmt.Errorf("address %s is not allowed for ACO %s", address, acoID)
+}
+
+// refuseAddress responds to a request refused by checkAllowedAddress, and records the refusal in the audit log.
+// actor identifies the credential that made the request.
+func refuseAddress(w http.ResponseWriter, r *http.Request, actor, acoID string, err error) {
+	recordRefusal(r, actor, acoID, err)
+	oo := responseutils.CreateOpOutcome(responseutils.Error, responseutils.Forbidden, "", responseutils.AddressErr)
+	responseutils.WriteError(oo, w, http.StatusForbidden)
+}
+
+// recordRefusal logs a request refused by checkAllowedAddress and records it in the audit log, without responding
+func recordRefusal(r *http.Request, actor, acoID string, err error) {
+	log.WithFields(log.Fields{"aco_id": acoID, "actor": actor, "remote_addr": r.RemoteAddr}).Error(err)
+	audit(actor, "refuse-address", acoID, clientIP(r), err)
+}
+
+// clientIP returns the address a request came from. When it came through proxies named, by CIDR range, in
+// BCDA_TRUSTED_PROXIES, that is the last address in X-Forwarded-For that is not a trusted proxy; otherwise it is the
+// address of the connection. Addresses that come before a proxy we don't trust are not believed, as the client may
+// have written them itself.
+func clientIP(r *http.Request) string {
+	address := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+
+	proxies := parseRanges(strings.Split(utils.FromEnv("BCDA_TRUSTED_PROXIES", ""), ","))
+	if !inRanges(address, proxies) {
+		return address
+	}
+
+	var forwarded []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		address = hop
+		if !inRanges(hop, proxies) {
+			break
+		}
+	}
+	return address
+}
+
+// parseRanges parses CIDR ranges, skipping any that are empty or invalid
+func parseRanges(cidrs []string) []*net.IPNet {
+	var ranges []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Warnf("ignoring invalid CIDR range %s", cidr)
+			continue
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges
+}
+
+func inRanges(address string, ranges []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range ranges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+	"github.com/CMSgov/bcda-app/bcda/responseutils"
+)
+
+type AllowlistTestSuite struct {
+	suite.Suite
+}
+
+func (s *AllowlistTestSuite) TearDownTest() {
+	os.Unsetenv("BCDA_TRUSTED_PROXIES")
+}
+
+func (s *AllowlistTestSuite) TestClientIP() {
+	req := func(remoteAddr string, forwarded ...string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		for _, f := range forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		return r
+	}
+
+	assert.Equal(s.T(), "203.0.113.7", clientIP(req("203.0.113.7:4321")))
+	assert.Equal(s.T(), "2001:db8::7", clientIP(req("[2001:db8::7]:4321")))
+	// X-Forwarded-For is ignored from untrusted addresses
+	assert.Equal(s.T(), "10.0.0.5", clientIP(req("10.0.0.5:4321", "203.0.113.7")))
+
+	os.Setenv("BCDA_TRUSTED_PROXIES", "10.0.0.0/24, not-a-range, 192.168.1.1/32")
+	assert.Equal(s.T(), "203.0.113.7", clientIP(req("10.0.0.5:4321", "203.0.113.7")))
+	// through a chain of trusted proxies
+	assert.Equal(s.T(), "203.0.113.7", clientIP(req("10.0.0.5:4321", "203.0.113.7, 192.168.1.1", "10.0.0.6")))
+	// what the client claims, before the first address we don't trust, is not believed
+	assert.Equal(s.T(), "198.51.100.9", clientIP(req("10.0.0.5:4321", "203.0.113.7, 198.51.100.9")))
+	assert.Equal(s.T(), "203.0.113.7", clientIP(req("10.0.0.5:4321", "garbage, 203.0.113.7")))
+	// a proxy that forwarded nothing
+	assert.Equal(s.T(), "10.0.0.5", clientIP(req("10.0.0.5:4321")))
+	assert.Equal(s.T(), "10.0.0.6", clientIP(req("10.0.0.5:4321", "10.0.0.6")))
+}
+
+func (s *AllowlistTestSuite) TestInRanges() {
+	ranges := parseRanges([]string{"203.0.113.0/24", "", "2001:db8::/32", "203.0.113.1"})
+	require.Len(s.T(), ranges, 2)
+	assert.True(s.T(), inRanges("203.0.113.200", ranges))
+	assert.True(s.T(), inRanges("2001:db8::7", ranges))
+	assert.False(s.T(), inRanges("203.0.114.1", ranges))
+	assert.False(s.T(), inRanges("not-an-address", ranges))
+	assert.False(s.T(), inRanges("203.0.113.200", nil))
+}
+
+func (s *AllowlistTestSuite) TestAllowedRanges() {
+	acoID := s.createACO()
+	defer s.cleanUp(acoID)
+
+	ranges, err := AllowedRanges(acoID)
+	assert.Nil(s.T(), err)
+	assert.Empty(s.T(), ranges)
+	assert.Nil(s.T(), checkAllowedAddress(acoID, "198.51.100.9"))
+
+	assert.Nil(s.T(), AddAllowedRange(acoID, "203.0.113.7/24"))
+	assert.Nil(s.T(), AddAllowedRange(acoID, "2001:db8::/32"))
+	assert.EqualError(s.T(), AddAllowedRange(acoID, "203.0.113.0/24"), fmt.Sprintf("203.0.113.0/24 is already allowed for ACO %s", acoID))
+	assert.EqualError(s.T(), AddAllowedRange(acoID, "203.0.113.7"), "invalid CIDR range 203.0.113.7")
+	assert.Contains(s.T(), AddAllowedRange(uuid.NewRandom().String(), "203.0.113.0/24").Error(), "no ACO record found")
+	ranges, err = AllowedRanges(acoID)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []string{"203.0.113.0/24", "2001:db8::/32"}, ranges)
+
+	assert.Nil(s.T(), checkAllowedAddress(acoID, "203.0.113.200"))
+	assert.Nil(s.T(), checkAllowedAddress(acoID, "2001:db8::7"))
+	assert.EqualError(s.T(), checkAllowedAddress(acoID, "198.51.100.9"), fmt.Sprintf("address 198.51.100.9 is not allowed for ACO %s", acoID))
+
+	assert.Nil(s.T(), RemoveAllowedRange(acoID, "203.0.113.0/24"))
+	assert.Nil(s.T(), RemoveAllowedRange(acoID, "2001:db8::/32"))
+	assert.EqualError(s.T(), RemoveAllowedRange(acoID, "2001:db8::/32"), fmt.Sprintf("2001:db8::/32 is not allowed for ACO %s", acoID))
+	assert.Nil(s.T(), checkAllowedAddress(acoID, "198.51.100.9"))
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	var count int
+	db.Model(&AdminAuditEvent{}).Where("target like ?", acoID+" %").Count(&count)
+	assert.Equal(s.T(), 6, count)
+}
+
+func (s *AllowlistTestSuite) TestRequireTokenAuth() {
+	acoID := s.createACO()
+	defer s.cleanUp(acoID)
+
+	tokenID := uuid.NewRandom().String()
+	ts, err := TokenStringWithIDs(tokenID, acoID)
+	require.Nil(s.T(), err)
+
+	router := chi.NewRouter()
+	router.Use(ParseToken)
+	router.With(RequireTokenAuth).Get("/", func(w http.ResponseWriter, r *http.Request) {})
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer "+ts)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(s.T(), http.StatusOK, get("198.51.100.9:4321").Code)
+
+	require.Nil(s.T(), AddAllowedRange(acoID, "203.0.113.0/24"))
+	assert.Equal(s.T(), http.StatusOK, get("203.0.113.7:4321").Code)
+	rr := get("198.51.100.9:4321")
+	assert.Equal(s.T(), http.StatusForbidden, rr.Code)
+	s.assertOperationOutcome(rr)
+	s.assertRefusal(tokenID, acoID, "198.51.100.9")
+}
+
+func (s *AllowlistTestSuite) TestGetAuthToken() {
+	acoID := s.createACO()
+	defer s.cleanUp(acoID)
+	creds, err := AlphaAuthPlugin{}.RegisterClient(acoID)
+	require.Nil(s.T(), err)
+	require.Nil(s.T(), AddAllowedRange(acoID, "203.0.113.0/24"))
+	os.Setenv("BCDA_TRUSTED_PROXIES", "10.0.0.0/8")
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	defer db.Unscoped().Delete(&Lockout{}, "principal in (?)", []string{lockoutClientPrefix + creds.ClientID,
+		lockoutClientPrefix + "unknown client", lockoutAddressPrefix + "203.0.113.7", lockoutAddressPrefix + "198.51.100.9"})
+
+	post := func(forwardedFor, clientID, secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/auth/token", nil)
+		req.RemoteAddr = "10.0.0.5:4321"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.SetBasicAuth(clientID, secret)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(GetAuthToken).ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(s.T(), http.StatusOK, post("203.0.113.7", creds.ClientID, creds.ClientSecret).Code)
+	assert.Equal(s.T(), http.StatusUnauthorized, post("203.0.113.7", creds.ClientID, "not the secret").Code)
+
+	// a refused address gets what a bad secret gets, so it can't tell a right secret from a wrong one, nor a client
+	// that exists from one that doesn't
+	rr := post("198.51.100.9", creds.ClientID, creds.ClientSecret)
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+	assert.NotContains(s.T(), rr.Body.String(), responseutils.AddressErr)
+	s.assertRefusal(creds.ClientID, acoID, "198.51.100.9")
+	assert.Equal(s.T(), http.StatusUnauthorized, post("198.51.100.9", creds.ClientID, "not the secret").Code)
+	assert.Equal(s.T(), http.StatusUnauthorized, post("198.51.100.9", "unknown client", "not the secret").Code)
+
+	// refusals count toward the lockout
+	var l Lockout
+	require.False(s.T(), db.First(&l, "principal = ?", lockoutClientPrefix+creds.ClientID).RecordNotFound())
+	assert.Equal(s.T(), 3, l.Failures)
+	require.False(s.T(), db.First(&l, "principal = ?", lockoutAddressPrefix+"198.51.100.9").RecordNotFound())
+	assert.Equal(s.T(), 3, l.Failures)
+
+	// and are issued no token
+	var count int
+	db.Model(&Token{}).Where("aco_id = ?", acoID).Count(&count)
+	assert.Equal(s.T(), 1, count)
+}
+
+func (s *AllowlistTestSuite) createACO() string {
+	models.InitializeGormModels()
+	InitializeGormModels()
+
+	cmsID := uuid.NewRandom().String()[0:4]
+	acoID, err := models.CreateACO("Allowlist Test ACO", &cmsID)
+	require.Nil(s.T(), err)
+	_, err = models.CreateUser("Allowlist Test User", fmt.Sprintf("%s@example.com", acoID), acoID)
+	require.Nil(s.T(), err)
+	return acoID.String()
+}
+
+func (s *AllowlistTestSuite) cleanUp(acoID string) {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	db.Unscoped().Delete(&AllowedRange{}, "aco_id = ?", acoID)
+	db.Unscoped().Delete(&AdminAuditEvent{}, "target = ? or target like ?", acoID, acoID+" %")
+	db.Unscoped().Delete(&Token{}, "aco_id = ?", acoID)
+	db.Unscoped().Delete(&models.User{}, "aco_id = ?", acoID)
+	db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoID)
+}
+
+func (s *AllowlistTestSuite) assertOperationOutcome(rr *httptest.ResponseRecorder) {
+	var oo map[string]interface{}
+	require.Nil(s.T(), json.Unmarshal(rr.Body.Bytes(), &oo))
+	assert.Equal(s.T(), "OperationOutcome", oo["resourceType"])
+	assert.Contains(s.T(), rr.Body.String(), responseutils.AddressErr)
+}
+
+func (s *AllowlistTestSuite) assertRefusal(actor, acoID, address string) {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	var event AdminAuditEvent
+	require.False(s.T(), db.Where("action = ? and target = ?", "refuse-address", acoID).Last(&event).RecordNotFound())
+	assert.Equal(s.T(), actor, event.Actor)
+	assert.Equal(s.T(), address, event.RemoteAddr)
+	assert.Equal(s.T(), fmt.Sprintf("address %s is not allowed for ACO %s", address, acoID), event.Outcome)
+}
+
+func TestAllowlistTestSuite(t *testing.T) {
+	suite.Run(t, new(AllowlistTestSuite))
+}
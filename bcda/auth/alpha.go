@@ -16,9 +16,18 @@ import (
 type AlphaAuthPlugin struct{}
 
 func (p AlphaAuthPlugin) RegisterClient(localID string) (Credentials, error) {
+	return p.RegisterClientWithTTL(localID, 0)
+}
+
+// RegisterClientWithTTL registers a client for the ACO identified by localID, as RegisterClient does, with a secret
+// that expires in ttl hours, or never if ttl is 0
+func (p AlphaAuthPlugin) RegisterClientWithTTL(localID string, ttl int) (Credentials, error) {
 	if localID == "" {
 		return Credentials{}, errors.New("provide a non-empty string")
 	}
+	if ttl < 0 {
+		return Credentials{}, fmt.Errorf("invalid TTL: %d", ttl)
+	}
 
 	aco, err := getACOFromDB(localID)
 	if err != nil {
@@ -34,18 +43,38 @@ func (p AlphaAuthPlugin) RegisterClient(localID string) (Credentials, error) {
 		return Credentials{}, err
 	}
 
-	hashedSecret := NewHash(s)
+	hashedSecret, err := NewHash(s)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	creds := Credentials{ClientName: aco.Name, ClientID: localID, ClientSecret: s}
+	expiresAt := secretExpiry(ttl)
+	if expiresAt != nil {
+		creds.ExpiresAt = *expiresAt
+	}
 
 	db := database.GetGORMDbConnection()
 	defer database.Close(db)
 	aco.ClientID = localID
 	aco.AlphaSecret = hashedSecret.String()
+	aco.AlphaSecretExpiresAt = expiresAt
 	err = db.Save(&aco).Error
 	if err != nil {
 		return Credentials{}, err
 	}
+	notifyACOChanged(db, aco.UUID.String())
+
+	return creds, nil
+}
 
-	return Credentials{ClientName: aco.Name, ClientID: localID, ClientSecret: s}, nil
+// secretExpiry returns when a secret made now that lasts ttl hours expires; nil, for never, if ttl is 0
+func secretExpiry(ttl int) *time.Time {
+	if ttl == 0 {
+		return nil
+	}
+	expiresAt := time.Now().Add(time.Hour * time.Duration(ttl))
+	return &expiresAt
 }
 
 func generateClientSecret() (string, error) {
@@ -77,16 +106,96 @@ func (p AlphaAuthPlugin) DeleteClient(clientID string) error {
 	if err != nil {
 		return err
 	}
+	notifyACOChanged(db, aco.UUID.String())
 
 	return nil
 }
 
+// GenerateClientCredentials replaces the client's secret with a new one that expires in ttl hours, or never if ttl
+// is 0. Tokens made with the old secret remain valid until they expire.
 func (p AlphaAuthPlugin) GenerateClientCredentials(clientID string, ttl int) (Credentials, error) {
-	return Credentials{}, fmt.Errorf("GenerateClientCredentials is not implemented for alpha auth")
+	if clientID == "" {
+		return Credentials{}, errors.New("client ID required")
+	}
+	if ttl < 0 {
+		return Credentials{}, fmt.Errorf("invalid TTL: %d", ttl)
+	}
+
+	aco, err := GetACOByClientID(clientID)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	s, err := generateClientSecret()
+	if err != nil {
+		return Credentials{}, err
+	}
+	hashedSecret, err := NewHash(s)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	creds := Credentials{ClientName: aco.Name, ClientID: clientID, ClientSecret: s}
+	expiresAt := secretExpiry(ttl)
+	if expiresAt != nil {
+		creds.ExpiresAt = *expiresAt
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	err = db.Model(&aco).Updates(map[string]interface{}{"alpha_secret": hashedSecret.String(), "alpha_secret_expires_at": expiresAt}).Error
+	if err != nil {
+		return Credentials{}, err
+	}
+	notifyACOChanged(db, aco.UUID.String())
+
+	log.WithField("client_id", clientID).WithField("expires_at", expiresAt).Info("client secret generated")
+	return creds, nil
 }
 
+// RevokeClientCredentials removes the client's secret and revokes the tokens made with it
 func (p AlphaAuthPlugin) RevokeClientCredentials(clientID string) error {
-	return fmt.Errorf("RevokeClientCredentials is not implemented for alpha auth")
+	aco, err := GetACOByClientID(clientID)
+	if err != nil {
+		return err
+	}
+	return revokeAlphaSecret(aco, "client credentials revoked")
+}
+
+func revokeAlphaSecret(aco models.ACO, reason string) error {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	err := db.Model(&aco).Updates(map[string]interface{}{"alpha_secret": "", "alpha_secret_expires_at": nil}).Error
+	if err != nil {
+		return err
+	}
+	notifyACOChanged(db, aco.UUID.String())
+
+	_, err = RevokeACOTokens(aco.UUID.String(), reason)
+	return err
+}
+
+// DeactivateExpiredAlphaCredentials removes the secrets that have expired, and revokes the tokens made with them.
+// It returns the number of clients deactivated.
+func DeactivateExpiredAlphaCredentials() (int, error) {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var acos []models.ACO
+	err := db.Where("alpha_secret <> '' and alpha_secret_expires_at <= ?", time.Now()).Find(&acos).Error
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, aco := range acos {
+		if err = revokeAlphaSecret(aco, "client credentials expired"); err != nil {
+			return count, fmt.Errorf("unable to deactivate client %s; %s", aco.ClientID, err)
+		}
+		log.WithField("client_id", aco.ClientID).WithField("expired_at", aco.AlphaSecretExpiresAt).Info("expired client credentials deactivated")
+		count++
+	}
+	return count, nil
 }
 
 // MakeAccessToken manufactures an access token for the given credentials
@@ -103,12 +212,65 @@ func (p AlphaAuthPlugin) MakeAccessToken(credentials Credentials) (string, error
 	if err != nil {
 		return "", fmt.Errorf("invalid credentials; %s", err)
 	}
-	if !Hash(aco.AlphaSecret).IsHashOf(credentials.ClientSecret) {
+	hash := Hash(aco.AlphaSecret)
+	if !hash.IsHashOf(credentials.ClientSecret) {
 		return "", fmt.Errorf("invalid credentials")
 	}
-	issuedAt := time.Now().Unix()
-	expiresAt := time.Now().Add(time.Hour * time.Duration(TokenTTL)).Unix()
-	return GenerateTokenString(uuid.NewRandom().String(), aco.UUID.String(), issuedAt, expiresAt)
+	if aco.AlphaSecretExpiresAt != nil && !time.Now().Before(*aco.AlphaSecretExpiresAt) {
+		log.WithField("client_id", aco.ClientID).WithField("expired_at", aco.AlphaSecretExpiresAt).Warn("token requested with expired client secret")
+		return "", fmt.Errorf("invalid credentials")
+	}
+	if hash.NeedsUpgrade() {
+		upgradeSecretHash(aco, credentials.ClientSecret)
+	}
+	scope, err := clientScope(aco.ClientID)
+	if err != nil {
+		return "", err
+	}
+	token := Token{
+		UUID:                  uuid.NewRandom(),
+		ACOID:                 aco.UUID,
+		IssuedAt:              time.Now().Unix(),
+		ExpiresOn:             time.Now().Add(TokenTTL).Unix(),
+		Active:                true,
+		Scope:                 scope,
+		CertificateThumbprint: credentials.CertificateThumbprint,
+	}
+	if err = saveToken(token); err != nil {
+		return "", err
+	}
+	return generateBoundTokenString(token.UUID.String(), token.ACOID.String(), token.Scope, token.CertificateThumbprint, token.IssuedAt, token.ExpiresOn)
+}
+
+// Persists an issued token, so that it can be revoked by its id
+func saveToken(token Token) error {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	if err := db.Create(&token).Error; err != nil {
+		return fmt.Errorf("unable to save token %s; %s", token.UUID, err)
+	}
+	return nil
+}
+
+// Replaces the stored hash of a secret that has just been verified with one in the current format. Failure is
+// logged, not returned; the existing hash is still good, and we will try again at the next successful login.
+func upgradeSecretHash(aco models.ACO, secret string) {
+	hash, err := NewHash(secret)
+	if err != nil {
+		log.Errorf("unable to rehash secret for client %s because %s", aco.ClientID, err)
+		return
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	// conditional on the old hash, in case the secret was replaced while we were verifying it
+	err = db.Model(&aco).Where("alpha_secret = ?", aco.AlphaSecret).Update("alpha_secret", hash.String()).Error
+	if err != nil {
+		log.Errorf("unable to save rehashed secret for client %s because %s", aco.ClientID, err)
+		return
+	}
+	log.WithField("client_id", aco.ClientID).Info("upgraded client secret hash")
 }
 
 // RequestAccessToken generates a token for the ACO
@@ -134,13 +296,22 @@ func (p AlphaAuthPlugin) RequestAccessToken(creds Credentials, ttl int) (Token,
 		return token, err
 	}
 
+	token.Scope, err = clientScope(aco.ClientID)
+	if err != nil {
+		return token, err
+	}
+
 	token.UUID = uuid.NewRandom()
 	token.ACOID = aco.UUID
 	token.IssuedAt = time.Now().Unix()
 	token.ExpiresOn = time.Now().Add(time.Hour * time.Duration(ttl)).Unix()
 	token.Active = true
 
-	token.TokenString, err = GenerateTokenString(token.UUID.String(), token.ACOID.String(), token.IssuedAt, token.ExpiresOn)
+	if err = saveToken(token); err != nil {
+		return Token{}, err
+	}
+
+	token.TokenString, err = generateScopedTokenString(token.UUID.String(), token.ACOID.String(), token.Scope, token.IssuedAt, token.ExpiresOn)
 	if err != nil {
 		return Token{}, err
 	}
@@ -149,7 +320,27 @@ func (p AlphaAuthPlugin) RequestAccessToken(creds Credentials, ttl int) (Token,
 }
 
 func (p AlphaAuthPlugin) RevokeAccessToken(tokenString string) error {
-	return fmt.Errorf("RevokeAccessToken is not implemented for alpha auth")
+	return p.RevokeAccessTokenWithReason(tokenString, RevocationReasonUnspecified)
+}
+
+// RevokeAccessTokenWithReason revokes the token in tokenString, recording why it was revoked
+func (p AlphaAuthPlugin) RevokeAccessTokenWithReason(tokenString, reason string) error {
+	t, err := p.DecodeJWT(tokenString)
+	if err != nil {
+		return err
+	}
+
+	c := t.Claims.(*CommonClaims)
+	if err = checkRequiredClaims(c); err != nil {
+		return err
+	}
+
+	tokenID, acoID := uuid.Parse(c.Id), uuid.Parse(c.ACOID)
+	if tokenID == nil || acoID == nil {
+		return fmt.Errorf("token id and ACO id must be UUIDs")
+	}
+
+	return revokeToken(Token{UUID: tokenID, ACOID: acoID, IssuedAt: c.IssuedAt, ExpiresOn: c.ExpiresAt}, reason)
 }
 
 func (p AlphaAuthPlugin) ValidateJWT(tokenString string) error {
@@ -166,24 +357,49 @@ func (p AlphaAuthPlugin) ValidateJWT(tokenString string) error {
 		return err
 	}
 
+	iss, err := alphaIssuer()
+	if err != nil {
+		return err
+	}
+	if c.Issuer != iss {
+		return fmt.Errorf("invalid iss claim; %s <> %s", c.Issuer, iss)
+	}
+	aud, err := alphaAudience()
+	if err != nil {
+		return err
+	}
+	if c.Audience != aud {
+		return fmt.Errorf("invalid aud claim; %s <> %s", c.Audience, aud)
+	}
+
 	err = c.Valid()
 	if err != nil {
 		return err
 	}
 
-	_, err = getACOFromDB(c.ACOID)
+	_, err = lookupACO(c.ACOID)
 	if err != nil {
 		return err
 	}
 
+	revoked, err := isRevoked(c.Id)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return fmt.Errorf("token %s has been revoked", c.Id)
+	}
+
 	return nil
 }
 
 func checkRequiredClaims(claims *CommonClaims) error {
 	if claims.ExpiresAt == 0 ||
 		claims.IssuedAt == 0 ||
+		claims.Issuer == "" ||
+		claims.Audience == "" ||
 		claims.ACOID == "" ||
-		claims.UUID == "" {
+		claims.Id == "" {
 		return fmt.Errorf("missing one or more required claims")
 	}
 	return nil
@@ -194,12 +410,31 @@ func (p AlphaAuthPlugin) DecodeJWT(tokenString string) (*jwt.Token, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return InitAlphaBackend().PublicKey, nil
+		// tokens minted before key ids were stamped have no kid
+		keyID, _ := token.Header["kid"].(string)
+		key, ok := InitAlphaBackend().PublicKeyFor(keyID)
+		if !ok {
+			return nil, fmt.Errorf("no key found with id %s", keyID)
+		}
+		return key, nil
 	}
 
 	return jwt.ParseWithClaims(tokenString, &CommonClaims{}, keyFunc)
 }
 
+// issued recognizes our tokens by their iss and kid. Tokens minted before issuers and key ids were stamped have
+// neither; they are recognized so that ValidateJWT can say why they are rejected.
+func (p AlphaAuthPlugin) issued(header map[string]interface{}, claims jwt.MapClaims) bool {
+	if iss, _ := claims["iss"].(string); iss != "" {
+		if ours, err := alphaIssuer(); err != nil || iss != ours {
+			return false
+		}
+	}
+	keyID, _ := header["kid"].(string)
+	_, ok := InitAlphaBackend().PublicKeyFor(keyID)
+	return ok
+}
+
 func getACOFromDB(acoUUID string) (models.ACO, error) {
 	var (
 		db  = database.GetGORMDbConnection()
@@ -1,7 +1,11 @@
 package auth_test
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"os"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,6 +17,7 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -101,8 +106,96 @@ func (s *AlphaAuthPluginTestSuite) TestDeleteClient() {
 func (s *AlphaAuthPluginTestSuite) TestGenerateClientCredentials() {
 	r, err := s.p.GenerateClientCredentials("", 0)
 	assert.Empty(s.T(), r)
+	assert.EqualError(s.T(), err, "client ID required")
+
+	cmsID := testUtils.RandomHexID()[0:4]
+	acoUUID, _ := models.CreateACO("TestGenerateClientCredentials", &cmsID)
+	defer connections["TestGenerateClientCredentials"].Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	defer connections["TestGenerateClientCredentials"].Unscoped().Delete(&auth.Token{}, "aco_id = ?", acoUUID)
+	original, err := s.p.RegisterClient(acoUUID.String())
+	assert.Nil(s.T(), err)
+	originalToken, err := s.p.MakeAccessToken(original)
+	assert.Nil(s.T(), err)
+
+	_, err = s.p.GenerateClientCredentials(original.ClientID, -1)
+	assert.EqualError(s.T(), err, "invalid TTL: -1")
+
+	// rotation replaces the secret, leaving tokens made with the old one valid
+	rotated, err := s.p.GenerateClientCredentials(original.ClientID, 2)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), original.ClientID, rotated.ClientID)
+	assert.NotEqual(s.T(), original.ClientSecret, rotated.ClientSecret)
+	assert.WithinDuration(s.T(), time.Now().Add(2*time.Hour), rotated.ExpiresAt, time.Minute)
+	_, err = s.p.MakeAccessToken(original)
 	assert.NotNil(s.T(), err)
-	assert.Contains(s.T(), err.Error(), "not implemented")
+	_, err = s.p.MakeAccessToken(rotated)
+	assert.Nil(s.T(), err)
+	assert.Nil(s.T(), s.p.ValidateJWT(originalToken))
+
+	aco, err := auth.GetACOByClientID(original.ClientID)
+	assert.Nil(s.T(), err)
+	assert.WithinDuration(s.T(), rotated.ExpiresAt, *aco.AlphaSecretExpiresAt, time.Second)
+
+	// a ttl of 0 never expires
+	forever, err := s.p.GenerateClientCredentials(original.ClientID, 0)
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), forever.ExpiresAt.IsZero())
+	aco, _ = auth.GetACOByClientID(original.ClientID)
+	assert.Nil(s.T(), aco.AlphaSecretExpiresAt)
+}
+
+func (s *AlphaAuthPluginTestSuite) TestExpiredCredentials() {
+	db := connections["TestExpiredCredentials"]
+	cmsID := testUtils.RandomHexID()[0:4]
+	acoUUID, _ := models.CreateACO("TestExpiredCredentials", &cmsID)
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	defer db.Unscoped().Delete(&auth.Token{}, "aco_id = ?", acoUUID)
+	_, err := s.p.RegisterClientWithTTL(acoUUID.String(), -1)
+	assert.EqualError(s.T(), err, "invalid TTL: -1")
+	creds, err := s.p.RegisterClientWithTTL(acoUUID.String(), 1)
+	assert.Nil(s.T(), err)
+	assert.WithinDuration(s.T(), time.Now().Add(time.Hour), creds.ExpiresAt, time.Minute)
+	token, err := s.p.MakeAccessToken(creds)
+	assert.Nil(s.T(), err)
+
+	// the response doesn't say that the secret was right
+	assert.Nil(s.T(), db.Model(&models.ACO{}).Where("uuid = ?", acoUUID).Update("alpha_secret_expires_at", time.Now().Add(-time.Minute)).Error)
+	_, err = s.p.MakeAccessToken(creds)
+	assert.EqualError(s.T(), err, "invalid credentials")
+
+	n, err := auth.DeactivateExpiredAlphaCredentials()
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), n >= 1)
+	aco, _ := auth.GetACOByClientID(creds.ClientID)
+	assert.Empty(s.T(), aco.AlphaSecret)
+	assert.Nil(s.T(), aco.AlphaSecretExpiresAt)
+	assert.NotNil(s.T(), s.p.ValidateJWT(token))
+
+	// nothing left to deactivate, and credentials that have not expired are left alone
+	other, err := s.p.GenerateClientCredentials(creds.ClientID, 1)
+	assert.Nil(s.T(), err)
+	_, err = auth.DeactivateExpiredAlphaCredentials()
+	assert.Nil(s.T(), err)
+	_, err = s.p.MakeAccessToken(other)
+	assert.Nil(s.T(), err)
+}
+
+func (s *AlphaAuthPluginTestSuite) TestRevokeClientCredentials() {
+	cmsID := testUtils.RandomHexID()[0:4]
+	acoUUID, _ := models.CreateACO("TestRevokeClientCredentials", &cmsID)
+	defer connections["TestRevokeClientCredentials"].Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	defer connections["TestRevokeClientCredentials"].Unscoped().Delete(&auth.Token{}, "aco_id = ?", acoUUID)
+	creds, err := s.p.RegisterClient(acoUUID.String())
+	assert.Nil(s.T(), err)
+	token, err := s.p.MakeAccessToken(creds)
+	assert.Nil(s.T(), err)
+
+	assert.Nil(s.T(), s.p.RevokeClientCredentials(creds.ClientID))
+	_, err = s.p.MakeAccessToken(creds)
+	assert.NotNil(s.T(), err)
+	assert.NotNil(s.T(), s.p.ValidateJWT(token))
+
+	assert.NotNil(s.T(), s.p.RevokeClientCredentials(uuid.NewRandom().String()))
 }
 
 func (s *AlphaAuthPluginTestSuite) TestAccessToken() {
@@ -144,6 +237,58 @@ func (s *AlphaAuthPluginTestSuite) TestAccessToken() {
 	assert.Contains(s.T(), err.Error(), "invalid credentials")
 }
 
+func (s *AlphaAuthPluginTestSuite) TestAccessTokenExpiresAfterTokenTTL() {
+	cmsID := testUtils.RandomHexID()[0:4]
+	acoUUID, _ := models.CreateACO("TestAccessTokenExpiresAfterTokenTTL", &cmsID)
+	db := connections["TestAccessTokenExpiresAfterTokenTTL"]
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	defer db.Unscoped().Delete(&auth.Token{}, "aco_id = ?", acoUUID)
+	cc, err := s.p.RegisterClient(acoUUID.String())
+	require.Nil(s.T(), err)
+
+	issued := time.Now()
+	ts, err := s.p.MakeAccessToken(auth.Credentials{ClientID: cc.ClientID, ClientSecret: cc.ClientSecret})
+	require.Nil(s.T(), err)
+	t, err := s.p.DecodeJWT(ts)
+	require.Nil(s.T(), err)
+	claims := t.Claims.(*auth.CommonClaims)
+	assert.InDelta(s.T(), issued.Add(auth.TokenTTL).Unix(), claims.ExpiresAt, 5)
+}
+
+func (s *AlphaAuthPluginTestSuite) TestAccessTokenUpgradesLegacyHash() {
+	db := connections["TestAccessTokenUpgradesLegacyHash"]
+	cmsID := testUtils.RandomHexID()[0:4]
+	acoUUID, _ := models.CreateACO("TestAccessTokenUpgradesLegacyHash", &cmsID)
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	defer db.Unscoped().Delete(&auth.Token{}, "aco_id = ?", acoUUID)
+
+	// a secret registered before bcrypt, stored as an unsalted SHA-256 hex digest
+	secret := "a legacy secret"
+	sum := sha256.Sum256([]byte(secret))
+	err := db.Model(&models.ACO{}).Where("uuid = ?", acoUUID).Updates(map[string]interface{}{"client_id": acoUUID.String(), "alpha_secret": fmt.Sprintf("%x", sum)}).Error
+	assert.Nil(s.T(), err)
+
+	ts, err := s.p.MakeAccessToken(auth.Credentials{ClientID: acoUUID.String(), ClientSecret: "not_the_right_secret"})
+	assert.NotNil(s.T(), err)
+	assert.Empty(s.T(), ts)
+	aco, _ := auth.GetACOByClientID(acoUUID.String())
+	assert.True(s.T(), auth.Hash(aco.AlphaSecret).NeedsUpgrade(), "failed logins must not rewrite the hash")
+
+	ts, err = s.p.MakeAccessToken(auth.Credentials{ClientID: acoUUID.String(), ClientSecret: secret})
+	assert.Nil(s.T(), err)
+	assert.NotEmpty(s.T(), ts)
+	aco, _ = auth.GetACOByClientID(acoUUID.String())
+	hash := auth.Hash(aco.AlphaSecret)
+	assert.False(s.T(), hash.NeedsUpgrade())
+	assert.True(s.T(), strings.HasPrefix(hash.String(), "$2a$"))
+	assert.True(s.T(), hash.IsHashOf(secret))
+
+	// the upgraded hash continues to work
+	ts, err = s.p.MakeAccessToken(auth.Credentials{ClientID: acoUUID.String(), ClientSecret: secret})
+	assert.Nil(s.T(), err)
+	assert.NotEmpty(s.T(), ts)
+}
+
 func (s *AlphaAuthPluginTestSuite) TestRequestAccessToken() {
 	const acoID = "DBBD1CE1-AE24-435C-807D-ED45953077D3"
 	t, err := s.p.RequestAccessToken(auth.Credentials{ClientID: acoID}, 720)
@@ -164,18 +309,84 @@ func (s *AlphaAuthPluginTestSuite) TestRequestAccessToken() {
 }
 
 func (s *AlphaAuthPluginTestSuite) TestRevokeAccessToken() {
+	db := connections["TestRevokeAccessToken"]
+	cmsID := testUtils.RandomHexID()[0:4]
+	acoUUID, _ := models.CreateACO("TestRevokeAccessToken", &cmsID)
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	defer db.Unscoped().Delete(&auth.Token{}, "aco_id = ?", acoUUID)
+
 	err := s.p.RevokeAccessToken("token-value-is-not-significant-here")
 	assert.NotNil(s.T(), err)
-	assert.Contains(s.T(), err.Error(), "not implemented")
+
+	cc, _ := s.p.RegisterClient(acoUUID.String())
+	ts, err := s.p.MakeAccessToken(auth.Credentials{ClientID: cc.ClientID, ClientSecret: cc.ClientSecret})
+	assert.Nil(s.T(), err)
+	assert.Nil(s.T(), s.p.ValidateJWT(ts))
+
+	assert.Nil(s.T(), s.p.RevokeAccessTokenWithReason(ts, "key compromised"))
+	err = s.p.ValidateJWT(ts)
+	assert.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "has been revoked")
+
+	t, _ := s.p.DecodeJWT(ts)
+	var token auth.Token
+	db.First(&token, "uuid = ?", t.Claims.(*auth.CommonClaims).UUID)
+	assert.False(s.T(), token.Active)
+	assert.NotNil(s.T(), token.RevokedAt)
+	assert.Equal(s.T(), "key compromised", token.RevocationReason)
+
+	err = s.p.RevokeAccessToken(ts)
+	assert.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "already been revoked")
+
+	// tokens issued before tokens were persisted can still be revoked
+	ts, _ = auth.TokenStringWithIDs(uuid.NewRandom().String(), acoUUID.String())
+	assert.Nil(s.T(), s.p.ValidateJWT(ts))
+	assert.Nil(s.T(), s.p.RevokeAccessToken(ts))
+	err = s.p.ValidateJWT(ts)
+	assert.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "has been revoked")
+}
+
+func (s *AlphaAuthPluginTestSuite) TestRevokeACOTokens() {
+	db := connections["TestRevokeACOTokens"]
+	cmsID := testUtils.RandomHexID()[0:4]
+	acoUUID, _ := models.CreateACO("TestRevokeACOTokens", &cmsID)
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	defer db.Unscoped().Delete(&auth.Token{}, "aco_id = ?", acoUUID)
+
+	first, err := s.p.RequestAccessToken(auth.Credentials{ClientID: acoUUID.String()}, 1)
+	assert.Nil(s.T(), err)
+	second, err := s.p.RequestAccessToken(auth.Credentials{ClientID: acoUUID.String()}, 1)
+	assert.Nil(s.T(), err)
+	assert.Nil(s.T(), s.p.ValidateJWT(first.TokenString))
+	assert.Nil(s.T(), s.p.ValidateJWT(second.TokenString))
+
+	n, err := auth.RevokeACOTokens(acoUUID.String(), "incident response")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), int64(2), n)
+	assert.NotNil(s.T(), s.p.ValidateJWT(first.TokenString))
+	assert.NotNil(s.T(), s.p.ValidateJWT(second.TokenString))
+
+	// nothing left to revoke
+	n, err = auth.RevokeACOTokens(acoUUID.String(), "")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), int64(0), n)
+
+	_, err = auth.RevokeACOTokens("not-a-uuid", "")
+	assert.NotNil(s.T(), err)
 }
 
 func (s *AlphaAuthPluginTestSuite) TestValidateAccessToken() {
 	userID := "82503A18-BF3B-436D-BA7B-BAE09B7FFD2F"
 	acoID := "DBBD1CE1-AE24-435C-807D-ED45953077D3"
 	validClaims := jwt.MapClaims{
+		"iss": "bcda",
+		"aud": "bcda-api",
 		"sub": userID,
 		"aco": acoID,
 		"id":  "d63205a8-d923-456b-a01b-0992fcb40968",
+		"jti": "d63205a8-d923-456b-a01b-0992fcb40968",
 		"iat": time.Now().Unix(),
 		"exp": time.Now().Add(time.Duration(999999999)).Unix(),
 	}
@@ -188,9 +399,12 @@ func (s *AlphaAuthPluginTestSuite) TestValidateAccessToken() {
 
 	unknownAco := *jwt.New(jwt.SigningMethodRS512)
 	unknownAco.Claims = jwt.MapClaims{
+		"iss": "bcda",
+		"aud": "bcda-api",
 		"sub": userID,
 		"aco": uuid.NewRandom().String(),
 		"id":  "d63205a8-d923-456b-a01b-0992fcb40968",
+		"jti": "d63205a8-d923-456b-a01b-0992fcb40968",
 		"iat": time.Now().Unix(),
 		"exp": time.Now().Add(time.Duration(999999999)).Unix(),
 	}
@@ -217,16 +431,50 @@ func (s *AlphaAuthPluginTestSuite) TestValidateAccessToken() {
 	assert.Contains(s.T(), err.Error(), "missing one or more required claims")
 
 	expiredToken := *jwt.New(jwt.SigningMethodRS512)
+	expiredID := uuid.NewRandom().String()
 	expiredToken.Claims = jwt.MapClaims{
+		"iss": "bcda",
+		"aud": "bcda-api",
 		"sub": userID,
 		"aco": acoID,
-		"id":  uuid.NewRandom().String(),
+		"id":  expiredID,
+		"jti": expiredID,
 		"iat": time.Now().Unix(),
 		"exp": time.Now().Add(time.Duration(-1) * time.Minute).Unix(),
 	}
 	expiredTokenString, _ := s.AuthBackend.SignJwtToken(expiredToken)
 	err = s.p.ValidateJWT(expiredTokenString)
 	assert.Contains(s.T(), err.Error(), "Token is expired")
+
+	// tokens minted by another deployment that shares the key
+	withClaim := func(name string, value interface{}) string {
+		claims := jwt.MapClaims{}
+		for k, v := range validClaims {
+			claims[k] = v
+		}
+		claims[name] = value
+		t := *jwt.New(jwt.SigningMethodRS512)
+		t.Claims = claims
+		ts, _ := s.AuthBackend.SignJwtToken(t)
+		return ts
+	}
+	err = s.p.ValidateJWT(withClaim("iss", "bcda-sandbox"))
+	assert.EqualError(s.T(), err, "invalid iss claim; bcda-sandbox <> bcda")
+	err = s.p.ValidateJWT(withClaim("aud", "bcda-sandbox-api"))
+	assert.EqualError(s.T(), err, "invalid aud claim; bcda-sandbox-api <> bcda-api")
+	err = s.p.ValidateJWT(withClaim("jti", nil))
+	assert.Contains(s.T(), err.Error(), "missing one or more required claims")
+
+	// the issuer and audience are set per deployment
+	defer os.Setenv("BCDA_TOKEN_ISSUER", os.Getenv("BCDA_TOKEN_ISSUER"))
+	defer os.Setenv("BCDA_TOKEN_AUDIENCE", os.Getenv("BCDA_TOKEN_AUDIENCE"))
+	os.Setenv("BCDA_TOKEN_ISSUER", "bcda-sandbox")
+	os.Setenv("BCDA_TOKEN_AUDIENCE", "bcda-sandbox-api")
+	err = s.p.ValidateJWT(validTokenString)
+	assert.EqualError(s.T(), err, "invalid iss claim; bcda <> bcda-sandbox")
+	sandboxTokenString, err := auth.TokenStringWithIDs(uuid.NewRandom().String(), acoID)
+	assert.Nil(s.T(), err)
+	assert.Nil(s.T(), s.p.ValidateJWT(sandboxTokenString))
 }
 
 func (s *AlphaAuthPluginTestSuite) TestDecodeJWT() {
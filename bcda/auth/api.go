@@ -1,9 +1,13 @@
 package auth
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -12,7 +16,10 @@ import (
 
 	Get access token
 
-	Verifies Basic authentication credentials, and returns a JWT bearer token that can be presented to the other API endpoints.
+	Verifies Basic authentication credentials, or a SMART Backend Services client assertion signed with a key registered for the client, and returns a JWT bearer token that can be presented to the other API endpoints.
+
+	Consumes:
+	- application/x-www-form-urlencoded
 
 	Produces:
 	- application/json
@@ -29,21 +36,143 @@ import (
 		500: serverError
 */
 func GetAuthToken(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("client_assertion") != "" || r.PostFormValue("client_assertion_type") != "" {
+		getAuthTokenForAssertion(w, r)
+		return
+	}
+
 	clientId, secret, ok := r.BasicAuth()
 	if !ok {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	token, err := GetProvider().MakeAccessToken(Credentials{ClientID: clientId, ClientSecret: secret})
+	principals := lockoutPrincipals(clientId, r)
+	if err := checkLockout(principals); err != nil {
+		log.WithField("client_id", clientId).WithField("remote_addr", r.RemoteAddr).Errorf("token request refused; %s", err)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	// the address is checked before the secret, so that a refused address can't learn whether a secret is right. It
+	// is refused as a bad secret is, so that the response does not tell whether the client exists.
+	if aco, err := lookupACOByClientID(clientId); err == nil {
+		if err = checkAllowedAddress(aco.UUID.String(), clientIP(r)); err != nil {
+			recordRefusal(r, clientId, aco.UUID.String(), err)
+			recordFailure(principals, r.RemoteAddr)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	creds := Credentials{ClientID: clientId, ClientSecret: secret, CertificateThumbprint: certificateThumbprint(r)}
+	token, err := GetProvider().MakeAccessToken(creds)
 	if err != nil {
+		recordFailure(principals, r.RemoteAddr)
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
+	clearFailures(clientId)
+
+	var expiresIn int64
+	scope := AllResourcesScope
+	claims := jwt.MapClaims{}
+	if _, _, err = new(jwt.Parser).ParseUnverified(token, claims); err == nil {
+		if exp, ok := claims["exp"].(float64); ok {
+			expiresIn = int64(exp) - time.Now().Unix()
+		}
+		if granted := grantScopes(tokenScopes(claims)); granted != "" {
+			scope = granted
+		}
+	}
+	writeToken(w, token, expiresIn, scope)
+	log.WithField("client_id", clientId).Println("issued access token")
+}
+
+// getAuthTokenForAssertion issues a token by the SMART Backend Services flow, in which the client authenticates
+// with a JWT assertion signed by its private key
+func getAuthTokenForAssertion(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("grant_type") != "client_credentials" {
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be client_credentials")
+		return
+	}
+	if r.PostFormValue("client_assertion_type") != ClientAssertionType {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "client_assertion_type must be "+ClientAssertionType)
+		return
+	}
+	if r.PostFormValue("client_assertion") == "" {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "client_assertion is required")
+		return
+	}
+
+	scope := grantScopes(r.PostFormValue("scope"))
+	if scope == "" {
+		writeTokenError(w, http.StatusBadRequest, "invalid_scope", "scope must include system/*.read or system/<resource type>.read")
+		return
+	}
+
+	aco, err := verifyClientAssertion(r.PostFormValue("client_assertion"), tokenURL(r))
+	if err != nil {
+		log.Errorf("invalid client assertion; %s", err)
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client", "invalid client assertion")
+		return
+	}
+
+	if err = checkAllowedAddress(aco.UUID.String(), clientIP(r)); err != nil {
+		recordRefusal(r, aco.ClientID, aco.UUID.String(), err)
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client", "invalid client assertion")
+		return
+	}
+
+	allowed, err := clientScope(aco.ClientID)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	scope = limitScopes(scope, allowed)
+	if scope == "" {
+		writeTokenError(w, http.StatusBadRequest, "invalid_scope", "the client may not be granted the scopes requested")
+		return
+	}
+
+	token, err := issueScopedToken(aco, scope, certificateThumbprint(r))
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeToken(w, token.TokenString, token.ExpiresOn-token.IssuedAt, scope)
+	log.WithField("client_id", aco.ClientID).WithField("scope", scope).Println("issued access token")
+}
+
+// tokenURL returns the URL of the token endpoint, which client assertions must name in their aud claim. It is
+// read from BCDA_TOKEN_URL when set, as it must be when we are behind a proxy.
+func tokenURL(r *http.Request) string {
+	if u := os.Getenv("BCDA_TOKEN_URL"); u != "" {
+		return u
+	}
+	scheme := "https"
+	if r.TLS == nil && os.Getenv("HTTP_ONLY") == "true" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/auth/token", scheme, r.Host)
+}
+
+// https://tools.ietf.org/html/rfc6749#section-5.1
+func writeToken(w http.ResponseWriter, token string, expiresIn int64, scope string) {
+	body, err := json.Marshal(struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in,omitempty"`
+		Scope       string `json:"scope,omitempty"`
+	}{token, "bearer", expiresIn, scope})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
 
-	// https://tools.ietf.org/html/rfc6749#section-5.1
-	// not included: recommended field expires_in
-	body := []byte(fmt.Sprintf(`{"access_token": "%s","token_type":"bearer"}`, token))
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "no-store")
 	w.Header().Set("Pragma", "no-cache")
@@ -51,21 +180,53 @@ func GetAuthToken(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
-	log.WithField("client_id", clientId).Println("issued access token")
 }
 
-func GetAuthGroups(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+// https://tools.ietf.org/html/rfc6749#section-5.2
+func writeTokenError(w http.ResponseWriter, status int, code, description string) {
+	body, _ := json.Marshal(map[string]string{"error": code, "error_description": description})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
 }
 
-func CreateAuthGroup(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
-}
+/*
+	swagger:route GET /auth/.well-known/jwks.json auth GetJWKS
 
-func EditAuthGroup(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
-}
+	Get token signing keys
 
-func DeactivateAuthGroup(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	Returns the public keys that verify access tokens issued by this server, as a JSON Web Key Set.
+
+	Produces:
+	- application/json
+
+	Schemes: https
+
+	Responses:
+		200: jwksResponse
+		404: notFoundResponse
+		500: serverError
+*/
+func GetJWKS(w http.ResponseWriter, r *http.Request) {
+	// only the alpha provider signs tokens itself; other providers publish their own keys
+	if GetProviderName() != Alpha {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	body, err := json.Marshal(InitAlphaBackend().JWKS())
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	_, err = w.Write(body)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
 }
@@ -1,24 +1,39 @@
 package auth_test
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/CMSgov/bcda-app/bcda/auth"
+	"github.com/CMSgov/bcda-app/bcda/auth/client"
 	"github.com/CMSgov/bcda-app/bcda/database"
 	"github.com/CMSgov/bcda-app/bcda/models"
 	"github.com/CMSgov/bcda-app/bcda/testUtils"
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
 	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
 }
 
 type AuthAPITestSuite struct {
@@ -35,6 +50,8 @@ func (s *AuthAPITestSuite) SetupTest() {
 }
 
 func (s *AuthAPITestSuite) TearDownTest() {
+	// httptest requests all come from the same address; don't let its failures pile up across runs
+	s.db.Unscoped().Where("principal = ?", "ip:192.0.2.1").Delete(auth.Lockout{})
 	database.Close(s.db)
 }
 
@@ -86,6 +103,283 @@ func (s *AuthAPITestSuite) TestAuthToken() {
 	assert.NoError(s.T(), json.NewDecoder(s.rr.Body).Decode(&t))
 	assert.NotEmpty(s.T(), t)
 	assert.NotEmpty(s.T(), t.AccessToken)
+	assert.True(s.T(), t.ExpiresIn > 0)
+	assert.Equal(s.T(), "system/*.read", t.Scope)
+}
+
+func (s *AuthAPITestSuite) TestAuthTokenLockout() {
+	s.SetupAuthBackend()
+	os.Setenv("BCDA_CLIENT_LOCKOUT_THRESHOLD", "3")
+	os.Setenv("BCDA_LOCKOUT_SECONDS", "60")
+	defer os.Unsetenv("BCDA_CLIENT_LOCKOUT_THRESHOLD")
+	defer os.Unsetenv("BCDA_LOCKOUT_SECONDS")
+
+	cmsID := testUtils.RandomHexID()[0:4]
+	acoID, err := models.CreateACO("Lockout Test ACO", &cmsID)
+	require.Nil(s.T(), err)
+	creds, err := auth.GetProvider().RegisterClient(acoID.String())
+	require.Nil(s.T(), err)
+	unknownID := uuid.NewRandom().String()
+	address := "203.0.113.7"
+	defer func() {
+		s.db.Unscoped().Where("principal in (?)", []string{"client:" + creds.ClientID, "client:" + unknownID,
+			"ip:" + address}).Delete(auth.Lockout{})
+		s.db.Unscoped().Where("principal in (?)", []string{"client:" + creds.ClientID, "client:" + unknownID,
+			"ip:" + address}).Delete(auth.LockoutEvent{})
+		s.db.Unscoped().Where("aco_id = ?", acoID).Delete(auth.Token{})
+		s.db.Unscoped().Delete(models.ACO{}, "uuid = ?", acoID)
+	}()
+
+	post := func(clientID, secret string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/auth/token", nil)
+		req.RemoteAddr = address + ":4321"
+		req.SetBasicAuth(clientID, secret)
+		req.Header.Add("Accept", "application/json")
+		http.HandlerFunc(auth.GetAuthToken).ServeHTTP(rr, req)
+		return rr
+	}
+
+	var badSecret *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		badSecret = post(creds.ClientID, "not_the_secret")
+		assert.Equal(s.T(), http.StatusUnauthorized, badSecret.Code)
+	}
+	var events []auth.LockoutEvent
+	s.db.Where("principal = ?", "client:"+creds.ClientID).Find(&events)
+	require.Len(s.T(), events, 1)
+	assert.Equal(s.T(), 3, events[0].Failures)
+	assert.Equal(s.T(), address+":4321", events[0].RemoteAddr)
+	assert.WithinDuration(s.T(), time.Now().Add(time.Minute), events[0].LockedUntil, 5*time.Second)
+
+	// the right secret doesn't help, and the response doesn't say why
+	locked := post(creds.ClientID, creds.ClientSecret)
+	assert.Equal(s.T(), http.StatusUnauthorized, locked.Code)
+	assert.Equal(s.T(), badSecret.Body.String(), locked.Body.String())
+
+	// a client that doesn't exist is locked out the same way
+	for i := 0; i < 4; i++ {
+		rr := post(unknownID, "not_the_secret")
+		assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+		assert.Equal(s.T(), badSecret.Body.String(), rr.Body.String())
+	}
+	s.db.Where("principal = ?", "client:"+unknownID).Find(&events)
+	assert.Len(s.T(), events, 1)
+
+	assert.Nil(s.T(), auth.Unlock(creds.ClientID, ""))
+	assert.Equal(s.T(), http.StatusOK, post(creds.ClientID, creds.ClientSecret).Code)
+	assert.EqualError(s.T(), auth.Unlock(creds.ClientID, ""), "no failed token requests recorded for client:"+creds.ClientID)
+	assert.EqualError(s.T(), auth.Unlock("", ""), "client ID or address required")
+
+	// each lockout lasts twice as long as the last
+	s.db.Model(auth.Lockout{}).Where("principal = ?", "client:"+unknownID).Update("locked_until", time.Now())
+	for i := 0; i < 3; i++ {
+		post(unknownID, "not_the_secret")
+	}
+	var l auth.Lockout
+	require.Nil(s.T(), s.db.Where("principal = ?", "client:"+unknownID).First(&l).Error)
+	assert.Equal(s.T(), 2, l.Lockouts)
+	assert.WithinDuration(s.T(), time.Now().Add(2*time.Minute), *l.LockedUntil, 5*time.Second)
+
+	// so are addresses that fail too often, whichever clients they try
+	os.Setenv("BCDA_ADDRESS_LOCKOUT_THRESHOLD", "2")
+	defer os.Unsetenv("BCDA_ADDRESS_LOCKOUT_THRESHOLD")
+	assert.Nil(s.T(), auth.Unlock("", address))
+	post(creds.ClientID, "not_the_secret")
+	post(creds.ClientID, "not_the_secret")
+	assert.Equal(s.T(), http.StatusUnauthorized, post(creds.ClientID, creds.ClientSecret).Code)
+	assert.Nil(s.T(), auth.Unlock(creds.ClientID, address))
+	assert.Equal(s.T(), http.StatusOK, post(creds.ClientID, creds.ClientSecret).Code)
+
+	// an address is unlocked even if the client named with it has no failures recorded
+	post(creds.ClientID, "not_the_secret")
+	post(creds.ClientID, "not_the_secret")
+	assert.Nil(s.T(), auth.Unlock(unknownID+"-never-failed", address))
+	assert.Equal(s.T(), http.StatusOK, post(creds.ClientID, creds.ClientSecret).Code)
+	assert.EqualError(s.T(), auth.Unlock(unknownID+"-never-failed", address),
+		"no failed token requests recorded for client:"+unknownID+"-never-failed or ip:"+address)
+}
+
+func (s *AuthAPITestSuite) TestAuthTokenLockoutConcurrent() {
+	s.SetupAuthBackend()
+	os.Setenv("BCDA_CLIENT_LOCKOUT_THRESHOLD", "10")
+	defer os.Unsetenv("BCDA_CLIENT_LOCKOUT_THRESHOLD")
+
+	cmsID := testUtils.RandomHexID()[0:4]
+	acoID, err := models.CreateACO("Concurrent Lockout Test ACO", &cmsID)
+	require.Nil(s.T(), err)
+	creds, err := auth.GetProvider().RegisterClient(acoID.String())
+	require.Nil(s.T(), err)
+	address := "198.51.100.7"
+	defer func() {
+		s.db.Unscoped().Where("principal in (?)", []string{"client:" + creds.ClientID, "ip:" + address}).Delete(auth.Lockout{})
+		s.db.Unscoped().Where("principal in (?)", []string{"client:" + creds.ClientID, "ip:" + address}).Delete(auth.LockoutEvent{})
+		s.db.Unscoped().Where("aco_id = ?", acoID).Delete(auth.Token{})
+		s.db.Unscoped().Delete(models.ACO{}, "uuid = ?", acoID)
+	}()
+
+	post := func(secret string) int {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/auth/token", nil)
+		req.RemoteAddr = address + ":4321"
+		req.SetBasicAuth(creds.ClientID, secret)
+		http.HandlerFunc(auth.GetAuthToken).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// failures made at once are each counted, so as many as the threshold lock the client out
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			post("not_the_secret")
+		}()
+	}
+	wg.Wait()
+
+	var events []auth.LockoutEvent
+	s.db.Where("principal = ?", "client:"+creds.ClientID).Find(&events)
+	require.Len(s.T(), events, 1)
+	assert.Equal(s.T(), 10, events[0].Failures)
+	assert.Equal(s.T(), http.StatusUnauthorized, post(creds.ClientSecret))
+}
+
+func (s *AuthAPITestSuite) TestAuthTokenWithClientAssertion() {
+	s.SetupAuthBackend()
+	tokenURL := "https://bcda.example.com/auth/token"
+	os.Setenv("BCDA_TOKEN_URL", tokenURL)
+	defer os.Unsetenv("BCDA_TOKEN_URL")
+
+	acoUUID, err := models.CreateACO("SMART Backend Services ACO", nil)
+	require.Nil(s.T(), err)
+	defer func() {
+		s.db.Unscoped().Delete(&auth.UsedAssertion{}, "client_id = ?", acoUUID.String())
+		s.db.Unscoped().Delete(&auth.ClientKeys{}, "client_id = ?", acoUUID.String())
+		s.db.Unscoped().Delete(&auth.Token{}, "aco_id = ?", acoUUID)
+		s.db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	}()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(s.T(), err)
+	jwks, err := json.Marshal(client.KeyList{Keys: []*client.RsaJWK{{
+		KeyType:   "RSA",
+		Algorithm: "RS384",
+		ID:        "client-key-1",
+		Use:       "sig",
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+		N:         base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()),
+	}}})
+	require.Nil(s.T(), err)
+	clientID, err := auth.RegisterClientKeys(acoUUID.String(), jwks)
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), acoUUID.String(), clientID)
+
+	assertion := func(claims jwt.MapClaims) string {
+		standard := jwt.MapClaims{
+			"iss": clientID,
+			"sub": clientID,
+			"aud": tokenURL,
+			"exp": time.Now().Add(4 * time.Minute).Unix(),
+			"jti": uuid.NewRandom().String(),
+		}
+		for k, v := range claims {
+			standard[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS384, standard)
+		token.Header["kid"] = "client-key-1"
+		signed, err := token.SignedString(privateKey)
+		require.Nil(s.T(), err)
+		return signed
+	}
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/auth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(auth.GetAuthToken).ServeHTTP(rr, req)
+		return rr
+	}
+	form := func(assertion, scope string) url.Values {
+		return url.Values{
+			"grant_type":            {"client_credentials"},
+			"scope":                 {scope},
+			"client_assertion_type": {auth.ClientAssertionType},
+			"client_assertion":      {assertion},
+		}
+	}
+
+	signed := assertion(nil)
+	rr := post(form(signed, "system/Patient.read system/Observation.read system/Coverage.*"))
+	assert.Equal(s.T(), http.StatusOK, rr.Code)
+	t := TokenResponse{}
+	assert.NoError(s.T(), json.NewDecoder(rr.Body).Decode(&t))
+	assert.Equal(s.T(), "bearer", t.TokenType)
+	assert.Equal(s.T(), int64(auth.TokenTTL.Seconds()), t.ExpiresIn)
+	assert.Equal(s.T(), "system/Patient.read system/Coverage.read", t.Scope)
+	assert.Nil(s.T(), auth.AlphaAuthPlugin{}.ValidateJWT(t.AccessToken))
+	decoded, err := auth.AlphaAuthPlugin{}.DecodeJWT(t.AccessToken)
+	require.Nil(s.T(), err)
+	claims := decoded.Claims.(*auth.CommonClaims)
+	assert.Equal(s.T(), acoUUID.String(), claims.ACOID)
+	assert.Equal(s.T(), []string{"system/Patient.read", "system/Coverage.read"}, claims.Scopes)
+
+	// an assertion can only be used once
+	rr = post(form(signed, "system/*.read"))
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+	assert.Contains(s.T(), rr.Body.String(), "invalid_client")
+
+	rr = post(form(assertion(jwt.MapClaims{"aud": "https://elsewhere.example.com/token"}), "system/*.read"))
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+
+	rr = post(form(assertion(jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()}), "system/*.read"))
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+
+	rr = post(form(assertion(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}), "system/*.read"))
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+
+	rr = post(form(assertion(jwt.MapClaims{"jti": nil}), "system/*.read"))
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+
+	rr = post(form(assertion(jwt.MapClaims{"sub": "someone else"}), "system/*.read"))
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+
+	rr = post(form(assertion(nil), "patient/*.read"))
+	assert.Equal(s.T(), http.StatusBadRequest, rr.Code)
+	assert.Contains(s.T(), rr.Body.String(), "invalid_scope")
+
+	f := form(assertion(nil), "system/*.read")
+	f.Set("grant_type", "password")
+	rr = post(f)
+	assert.Equal(s.T(), http.StatusBadRequest, rr.Code)
+	assert.Contains(s.T(), rr.Body.String(), "unsupported_grant_type")
+
+	f = form(assertion(nil), "system/*.read")
+	f.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:saml2-bearer")
+	rr = post(f)
+	assert.Equal(s.T(), http.StatusBadRequest, rr.Code)
+	assert.Contains(s.T(), rr.Body.String(), "invalid_request")
+}
+
+func (s *AuthAPITestSuite) TestGetJWKS() {
+	s.SetupAuthBackend()
+
+	req := httptest.NewRequest("GET", "/auth/.well-known/jwks.json", nil)
+	http.HandlerFunc(auth.GetJWKS).ServeHTTP(s.rr, req)
+	assert.Equal(s.T(), http.StatusOK, s.rr.Code)
+	assert.Equal(s.T(), "application/json", s.rr.Header().Get("Content-Type"))
+
+	var jwks client.KeyList
+	assert.NoError(s.T(), json.NewDecoder(s.rr.Body).Decode(&jwks))
+	assert.NotEmpty(s.T(), jwks.Keys)
+	assert.Equal(s.T(), auth.InitAlphaBackend().KeyID, jwks.Keys[0].ID)
+
+	// keys are only published when we are the token issuer
+	originalProvider := auth.GetProviderName()
+	defer auth.SetProvider(originalProvider)
+	auth.SetProvider(auth.Okta)
+	s.rr = httptest.NewRecorder()
+	http.HandlerFunc(auth.GetJWKS).ServeHTTP(s.rr, req)
+	assert.Equal(s.T(), http.StatusNotFound, s.rr.Code)
 }
 
 func TestAuthAuthAPITestSuite(t *testing.T) {
@@ -3,12 +3,19 @@ package auth
 import (
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"fmt"
+	"math/big"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/dgrijalva/jwt-go"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
 
+	"github.com/CMSgov/bcda-app/bcda/auth/client"
 	"github.com/CMSgov/bcda-app/bcda/utils"
 )
 
@@ -20,20 +27,57 @@ func init() {
 	log.SetFormatter(&log.JSONFormatter{})
 }
 
-// Hash is a cryptographically hashed string
+// Hash is a cryptographically hashed string. Hashes are stored in the bcrypt modular crypt format
+// ($2a$cost$salt+digest), which records the salt and cost used to create them. Hashes created before we adopted
+// bcrypt are unsalted SHA-256 hex digests; these still verify, but report NeedsUpgrade().
 type Hash string
 
-// NewHash creates a hashed string from a source string, return it as a new Hash value.  While this is not
-// intended for production password hashing, as a matter of principle we intend to add a salt and implement
-// bcrypt or another hash appropriate for storing secrets in ticket BCDA-1130.
-func NewHash(source string) Hash {
+// The source is reduced to a SHA-256 hex digest before it is given to bcrypt, which ignores input beyond 72 bytes.
+// This also means a legacy hash is exactly the bcrypt input for its source.
+func digest(source string) string {
 	sum := sha256.Sum256([]byte(source))
-	return Hash(fmt.Sprintf("%x", sum))
+	return fmt.Sprintf("%x", sum)
+}
+
+// NewHash creates a salted, hashed string from a source string, returning it as a new Hash value. The bcrypt cost
+// is read from CLIENT_SECRET_HASH_COST, so that it can be raised as hardware gets faster.
+func NewHash(source string) (Hash, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(digest(source)), hashCost())
+	if err != nil {
+		return "", err
+	}
+	return Hash(b), nil
+}
+
+func hashCost() int {
+	cost := utils.GetEnvInt("CLIENT_SECRET_HASH_COST", bcrypt.DefaultCost)
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		log.Warnf("CLIENT_SECRET_HASH_COST %d is outside of %d..%d; using %d", cost, bcrypt.MinCost, bcrypt.MaxCost, bcrypt.DefaultCost)
+		return bcrypt.DefaultCost
+	}
+	return cost
 }
 
 // IsHashOf accepts an unhashed string, which it first hashes and then compares to itself
 func (h Hash) IsHashOf(source string) bool {
-	return h == NewHash(source)
+	if h.isLegacy() {
+		return subtle.ConstantTimeCompare([]byte(h), []byte(digest(source))) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(h), []byte(digest(source))) == nil
+}
+
+// NeedsUpgrade reports whether this hash should be replaced, because it is in the legacy format or was created
+// with a cost other than the one currently configured.
+func (h Hash) NeedsUpgrade() bool {
+	if h.isLegacy() {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(h))
+	return err != nil || cost != hashCost()
+}
+
+func (h Hash) isLegacy() bool {
+	return !strings.HasPrefix(string(h), "$")
 }
 
 func (h Hash) String() string {
@@ -41,30 +85,39 @@ func (h Hash) String() string {
 }
 
 // AlphaBackend is the authorization backend for the alpha plugin. Its purpose is to hold and control use of the
-// server's public and private keys.
+// server's public and private keys. Tokens are signed with PrivateKey and stamped with KeyID; they are verified with
+// any key in VerificationKeys, which holds PublicKey along with any keys retired by a rotation whose tokens may
+// still be outstanding.
 type AlphaBackend struct {
-	PrivateKey *rsa.PrivateKey
-	PublicKey  *rsa.PublicKey
+	PrivateKey       *rsa.PrivateKey
+	PublicKey        *rsa.PublicKey
+	KeyID            string
+	VerificationKeys map[string]*rsa.PublicKey
 }
 
 // InitAlphaBackend does first time initialization of the alphaBackend instance with its private and public key pair.
 // If the instance is already initialized, it simply returns the existing value.
 func InitAlphaBackend() *AlphaBackend {
 	if alphaBackend == nil {
-		alphaBackend = &AlphaBackend{
-			PrivateKey: getPrivateKey(),
-			PublicKey:  getPublicKey(),
-		}
+		alphaBackend = newAlphaBackend()
 	}
 	return alphaBackend
 }
 
 // ResetAlphaBackend sets the servers keys whether they are set or not. Used for testing.
 func (backend *AlphaBackend) ResetAlphaBackend() {
-	alphaBackend = &AlphaBackend{
-		PrivateKey: getPrivateKey(),
-		PublicKey:  getPublicKey(),
+	alphaBackend = newAlphaBackend()
+}
+
+func newAlphaBackend() *AlphaBackend {
+	backend := &AlphaBackend{
+		PrivateKey:       getPrivateKey(),
+		PublicKey:        getPublicKey(),
+		VerificationKeys: getVerificationKeys(),
 	}
+	backend.KeyID = KeyID(backend.PublicKey)
+	backend.VerificationKeys[backend.KeyID] = backend.PublicKey
+	return backend
 }
 
 // This method and its sibling, getPublicKey(), get the private key from the file system and environment variables.
@@ -93,7 +146,83 @@ func getPublicKey() *rsa.PublicKey {
 	return utils.OpenPublicKeyFile(publicKeyFile)
 }
 
+// Public keys that are no longer used for signing, but which should still be accepted, are listed as a comma
+// separated set of files in JWT_VERIFICATION_KEY_FILES. When rotating keys, the outgoing public key belongs here
+// until the last token it signed has expired. Panics under the same conditions as getPublicKey().
+func getVerificationKeys() map[string]*rsa.PublicKey {
+	keys := make(map[string]*rsa.PublicKey)
+	for _, fileName := range strings.Split(os.Getenv("JWT_VERIFICATION_KEY_FILES"), ",") {
+		fileName = strings.TrimSpace(fileName)
+		if fileName == "" {
+			continue
+		}
+		/* #nosec -- Potential file inclusion via variable */
+		publicKeyFile, err := os.Open(fileName)
+		if err != nil {
+			log.Panicf("can't open verification key file %s because %v", fileName, err)
+		}
+		key := utils.OpenPublicKeyFile(publicKeyFile)
+		keys[KeyID(key)] = key
+	}
+	return keys
+}
+
+// KeyID derives a stable key identifier from a public key: the RFC 7638 JWK thumbprint of its JWK representation.
+func KeyID(key *rsa.PublicKey) string {
+	if key == nil {
+		return ""
+	}
+	// members in lexicographic order, with no whitespace, as RFC 7638 requires
+	thumbprintInput := fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, encodeExponent(key.E), base64.RawURLEncoding.EncodeToString(key.N.Bytes()))
+	sum := sha256.Sum256([]byte(thumbprintInput))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func encodeExponent(e int) string {
+	return base64.RawURLEncoding.EncodeToString(big.NewInt(int64(e)).Bytes())
+}
+
+// PublicKeyFor returns the verification key with the given key id. Tokens minted before we began stamping key ids
+// carry no kid, and can only have been signed by the current key.
+func (backend *AlphaBackend) PublicKeyFor(keyID string) (*rsa.PublicKey, bool) {
+	if keyID == "" {
+		return backend.PublicKey, backend.PublicKey != nil
+	}
+	key, ok := backend.VerificationKeys[keyID]
+	return key, ok
+}
+
+// JWKS returns the verification keys as a JSON Web Key Set, so that other services can verify our tokens.
+func (backend *AlphaBackend) JWKS() client.KeyList {
+	keyIDs := make([]string, 0, len(backend.VerificationKeys))
+	for id := range backend.VerificationKeys {
+		keyIDs = append(keyIDs, id)
+	}
+	// current key first, then a stable order for the rest
+	sort.Slice(keyIDs, func(i, j int) bool {
+		if keyIDs[i] == backend.KeyID || keyIDs[j] == backend.KeyID {
+			return keyIDs[i] == backend.KeyID
+		}
+		return keyIDs[i] < keyIDs[j]
+	})
+
+	jwks := client.KeyList{Keys: []*client.RsaJWK{}}
+	for _, id := range keyIDs {
+		key := backend.VerificationKeys[id]
+		jwks.Keys = append(jwks.Keys, &client.RsaJWK{
+			KeyType:   "RSA",
+			Algorithm: jwt.SigningMethodRS512.Alg(),
+			ID:        id,
+			Use:       "sig",
+			E:         encodeExponent(key.E),
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		})
+	}
+	return jwks
+}
+
 // SignJwtToken signs a prepared JWT token, returning it as a base-64 encoded string suitable for use as a Bearer token.
 func (backend *AlphaBackend) SignJwtToken(token jwt.Token) (string, error) {
+	token.Header["kid"] = backend.KeyID
 	return token.SignedString(backend.PrivateKey)
 }
@@ -1,10 +1,19 @@
 package auth_test
 
 import (
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
 	"os"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -42,9 +51,54 @@ func (s *BackendTestSuite) TestInitAuthBackend() {
 
 func (s *BackendTestSuite) TestHashCompare() {
 	uuidString := uuid.NewRandom().String()
-	hash := auth.NewHash(uuidString)
+	hash, err := auth.NewHash(uuidString)
+	assert.Nil(s.T(), err)
 	assert.True(s.T(), hash.IsHashOf(uuidString))
 	assert.False(s.T(), hash.IsHashOf(uuid.NewRandom().String()))
+	assert.False(s.T(), hash.NeedsUpgrade())
+
+	// salted, so the same source does not produce the same hash
+	other, err := auth.NewHash(uuidString)
+	assert.Nil(s.T(), err)
+	assert.NotEqual(s.T(), hash, other)
+	assert.True(s.T(), other.IsHashOf(uuidString))
+
+	// secrets longer than bcrypt's 72 byte limit are not truncated
+	long := strings.Repeat("a", 72)
+	hash, err = auth.NewHash(long + "b")
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), hash.IsHashOf(long+"c"))
+}
+
+func (s *BackendTestSuite) TestHashFormat() {
+	originalCost := os.Getenv("CLIENT_SECRET_HASH_COST")
+	defer os.Setenv("CLIENT_SECRET_HASH_COST", originalCost)
+
+	os.Setenv("CLIENT_SECRET_HASH_COST", "5")
+	hash, err := auth.NewHash("a secret")
+	assert.Nil(s.T(), err)
+	assert.Regexp(s.T(), regexp.MustCompile(`^\$2a\$05\$[./A-Za-z0-9]{53}$`), hash.String())
+	assert.False(s.T(), hash.NeedsUpgrade())
+
+	// raising the cost marks existing hashes for upgrade
+	os.Setenv("CLIENT_SECRET_HASH_COST", "6")
+	assert.True(s.T(), hash.NeedsUpgrade())
+	assert.True(s.T(), hash.IsHashOf("a secret"))
+
+	// an out of range cost falls back to the default
+	os.Setenv("CLIENT_SECRET_HASH_COST", "99")
+	hash, err = auth.NewHash("a secret")
+	assert.Nil(s.T(), err)
+	assert.Regexp(s.T(), regexp.MustCompile(`^\$2a\$10\$`), hash.String())
+}
+
+func (s *BackendTestSuite) TestLegacyHash() {
+	// unsalted SHA-256, as hashes were stored before bcrypt
+	sum := sha256.Sum256([]byte("a secret"))
+	legacy := auth.Hash(fmt.Sprintf("%x", sum))
+	assert.True(s.T(), legacy.IsHashOf("a secret"))
+	assert.False(s.T(), legacy.IsHashOf("another secret"))
+	assert.True(s.T(), legacy.NeedsUpgrade())
 }
 
 func (s *BackendTestSuite) TestPrivateKey() {
@@ -91,6 +145,100 @@ func (s *BackendTestSuite) TestPublicKey() {
 
 }
 
+func (s *BackendTestSuite) TestKeyID() {
+	assert.NotEmpty(s.T(), s.AuthBackend.KeyID)
+	assert.Equal(s.T(), auth.KeyID(s.AuthBackend.PublicKey), s.AuthBackend.KeyID)
+	assert.Equal(s.T(), "", auth.KeyID(nil))
+
+	// example from RFC 7638 section 3.1
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	assert.Nil(s.T(), err)
+	example := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+	assert.Equal(s.T(), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", auth.KeyID(example))
+}
+
+func (s *BackendTestSuite) TestKeyRotation() {
+	retiredKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(s.T(), err)
+	retiredKeyFile, err := s.CreateTempFile()
+	assert.Nil(s.T(), err)
+	s.SavePubKey(retiredKeyFile, retiredKey.PublicKey)
+	retiredKeyFile.Close()
+	defer os.Remove(retiredKeyFile.Name())
+
+	os.Setenv("JWT_VERIFICATION_KEY_FILES", retiredKeyFile.Name())
+	defer os.Unsetenv("JWT_VERIFICATION_KEY_FILES")
+	s.AuthBackend.ResetAlphaBackend()
+	defer s.AuthBackend.ResetAlphaBackend()
+	backend := auth.InitAlphaBackend()
+
+	// new tokens are signed with, and stamped with the id of, the current key
+	ts, err := auth.TokenStringWithIDs(uuid.NewRandom().String(), uuid.NewRandom().String())
+	assert.Nil(s.T(), err)
+	t, err := auth.AlphaAuthPlugin{}.DecodeJWT(ts)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), backend.KeyID, t.Header["kid"])
+
+	// tokens signed by the retired key still verify
+	retiredKeyID := auth.KeyID(&retiredKey.PublicKey)
+	old := jwt.New(jwt.SigningMethodRS512)
+	old.Header["kid"] = retiredKeyID
+	old.Claims = jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(), "aco": uuid.NewRandom().String(), "id": uuid.NewRandom().String()}
+	oldString, err := old.SignedString(retiredKey)
+	assert.Nil(s.T(), err)
+	t, err = auth.AlphaAuthPlugin{}.DecodeJWT(oldString)
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), t.Valid)
+
+	// but only under the retired key's id
+	old.Header["kid"] = "not-a-key-we-know"
+	oldString, _ = old.SignedString(retiredKey)
+	_, err = auth.AlphaAuthPlugin{}.DecodeJWT(oldString)
+	assert.Contains(s.T(), err.Error(), "no key found with id not-a-key-we-know")
+
+	// and once the retired key leaves the verification set, its tokens are rejected
+	os.Unsetenv("JWT_VERIFICATION_KEY_FILES")
+	s.AuthBackend.ResetAlphaBackend()
+	old.Header["kid"] = retiredKeyID
+	oldString, _ = old.SignedString(retiredKey)
+	_, err = auth.AlphaAuthPlugin{}.DecodeJWT(oldString)
+	assert.NotNil(s.T(), err)
+
+	os.Setenv("JWT_VERIFICATION_KEY_FILES", "/static/thisDoesNotExist.pem")
+	assert.Panics(s.T(), s.AuthBackend.ResetAlphaBackend)
+	os.Unsetenv("JWT_VERIFICATION_KEY_FILES")
+}
+
+func (s *BackendTestSuite) TestJWKS() {
+	retiredKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(s.T(), err)
+	retiredKeyFile, err := s.CreateTempFile()
+	assert.Nil(s.T(), err)
+	s.SavePubKey(retiredKeyFile, retiredKey.PublicKey)
+	retiredKeyFile.Close()
+	defer os.Remove(retiredKeyFile.Name())
+
+	os.Setenv("JWT_VERIFICATION_KEY_FILES", " "+retiredKeyFile.Name()+", ")
+	defer os.Unsetenv("JWT_VERIFICATION_KEY_FILES")
+	s.AuthBackend.ResetAlphaBackend()
+	defer s.AuthBackend.ResetAlphaBackend()
+	backend := auth.InitAlphaBackend()
+
+	jwks := backend.JWKS()
+	assert.Len(s.T(), jwks.Keys, 2)
+	assert.Equal(s.T(), backend.KeyID, jwks.Keys[0].ID)
+	assert.Equal(s.T(), auth.KeyID(&retiredKey.PublicKey), jwks.Keys[1].ID)
+	for _, k := range jwks.Keys {
+		assert.Equal(s.T(), "RSA", k.KeyType)
+		assert.Equal(s.T(), "RS512", k.Algorithm)
+		assert.Equal(s.T(), "sig", k.Use)
+		assert.Equal(s.T(), "AQAB", k.E)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), backend.PublicKey.N.Bytes(), n)
+}
+
 func TestBackendTestSuite(t *testing.T) {
 	suite.Run(t, new(BackendTestSuite))
 }
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/CMSgov/bcda-app/bcda/database"
+)
+
+// An answerCache holds recent answers read from the database, each under a key and a group of answers that are
+// forgotten together. Every instance forgets a group when told to by a NOTIFY; see listenForInvalidations. The cache
+// is bypassed whenever we are not listening, because we may have missed an invalidation. Only what was found is
+// cached.
+type answerCache struct {
+	sync.RWMutex
+	entries map[string]answerEntry
+	size    int
+	// ttl returns how long answers are kept; when nil, they are kept until invalidated
+	ttl       func() time.Duration
+	listening bool
+	// generation counts invalidations, so that an answer read from the database before one is not cached after it
+	generation uint64
+}
+
+type answerEntry struct {
+	value   interface{}
+	group   string
+	expires time.Time
+}
+
+// newAnswerCache returns a cache of at most size answers, each kept for ttl() if ttl is not nil
+func newAnswerCache(size int, ttl func() time.Duration) *answerCache {
+	return &answerCache{entries: make(map[string]answerEntry), size: size, ttl: ttl}
+}
+
+func (c *answerCache) get(key string) (interface{}, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	if !c.listening {
+		return nil, false
+	}
+	e, found := c.entries[key]
+	if !found || (c.ttl != nil && time.Now().After(e.expires)) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// currentGeneration returns the generation to pass to put for an answer about to be read from the database
+func (c *answerCache) currentGeneration() uint64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.generation
+}
+
+// put caches value under key in group, unless the cache has been invalidated since generation, when value may be
+// stale
+func (c *answerCache) put(key, group string, value interface{}, generation uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if !c.listening || c.generation != generation {
+		return
+	}
+	// this is a cache of recent answers, not a copy of the database; start over rather than grow without bound
+	if len(c.entries) >= c.size {
+		c.entries = make(map[string]answerEntry)
+	}
+	e := answerEntry{value: value, group: group}
+	if c.ttl != nil {
+		e.expires = time.Now().Add(c.ttl())
+	}
+	c.entries[key] = e
+}
+
+// invalidate forgets every answer in group, or all answers if group is empty
+func (c *answerCache) invalidate(group string) {
+	c.Lock()
+	defer c.Unlock()
+	c.generation++
+	if group == "" {
+		c.entries = make(map[string]answerEntry)
+		return
+	}
+	for key, e := range c.entries {
+		if e.group == group {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *answerCache) setListening(listening bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.listening = listening
+	c.generation++
+	c.entries = make(map[string]answerEntry)
+}
+
+// lookup returns the answer cached under key, asking read, which also names the answer's group, only if the cache
+// can't say
+func (c *answerCache) lookup(key string, read func(db *gorm.DB) (value interface{}, group string, err error)) (interface{}, error) {
+	listenOnce.Do(func() { go listenForInvalidations() })
+
+	if v, found := c.get(key); found {
+		return v, nil
+	}
+
+	generation := c.currentGeneration()
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	v, group, err := read(db)
+	if err != nil {
+		return nil, err
+	}
+
+	c.put(key, group, v, generation)
+	return v, nil
+}
+
+// listenOnce starts listenForInvalidations at the first lookup
+var listenOnce sync.Once
+
+// listenForInvalidations keeps the revocation and lookup caches coherent with changes made by any instance
+func listenForInvalidations() {
+	setListening := func(listening bool) {
+		revocations.setListening(listening)
+		lookups.setListening(listening)
+	}
+
+	onEvent := func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+			log.Warnf("not listening for token revocations or ACO changes; %v", err)
+			setListening(false)
+		}
+	}
+
+	listener := pq.NewListener(os.Getenv("DATABASE_URL"), 10*time.Second, time.Minute, onEvent)
+	for _, channel := range []string{revocationChannel, lookupChannel} {
+		if err := listener.Listen(channel); err != nil {
+			log.Errorf("unable to listen on %s; %s", channel, err)
+			return
+		}
+	}
+	setListening(true)
+
+	for {
+		select {
+		case n := <-listener.Notify:
+			// a nil notification follows a reconnect, after which anything could have been missed
+			if n == nil {
+				setListening(true)
+				continue
+			}
+			switch n.Channel {
+			case revocationChannel:
+				revocations.invalidate(n.Extra)
+			case lookupChannel:
+				lookups.invalidate(n.Extra)
+			}
+		case <-time.After(90 * time.Second):
+			go func() {
+				if err := listener.Ping(); err != nil {
+					log.Warnf("invalidation listener ping failed; %s", err)
+				}
+			}()
+		}
+	}
+}
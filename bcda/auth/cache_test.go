@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type AnswerCacheTestSuite struct {
+	suite.Suite
+}
+
+func (s *AnswerCacheTestSuite) TestCache() {
+	c := newAnswerCache(10, nil)
+
+	// bypassed when not listening
+	c.put("aco:1", "1", "first", c.currentGeneration())
+	_, found := c.get("aco:1")
+	assert.False(s.T(), found)
+
+	c.setListening(true)
+	c.put("aco:1", "1", "first", c.currentGeneration())
+	c.put("user:1", "1", "user", c.currentGeneration())
+	c.put("aco:2", "2", "second", c.currentGeneration())
+	v, found := c.get("aco:1")
+	assert.True(s.T(), found)
+	assert.Equal(s.T(), "first", v)
+
+	// forgetting a group forgets every answer in it
+	c.invalidate("1")
+	_, found = c.get("aco:1")
+	assert.False(s.T(), found)
+	_, found = c.get("user:1")
+	assert.False(s.T(), found)
+	_, found = c.get("aco:2")
+	assert.True(s.T(), found)
+
+	c.invalidate("")
+	_, found = c.get("aco:2")
+	assert.False(s.T(), found)
+
+	// a missed invalidation could leave anything stale
+	c.put("aco:2", "2", "second", c.currentGeneration())
+	c.setListening(false)
+	c.setListening(true)
+	_, found = c.get("aco:2")
+	assert.False(s.T(), found)
+
+	// an answer read before an invalidation is not cached after it
+	generation := c.currentGeneration()
+	c.invalidate("2")
+	c.put("aco:2", "2", "stale", generation)
+	_, found = c.get("aco:2")
+	assert.False(s.T(), found)
+}
+
+func (s *AnswerCacheTestSuite) TestSize() {
+	c := newAnswerCache(2, nil)
+	c.setListening(true)
+	c.put("token:1", "token:1", int64(0), c.currentGeneration())
+	c.put("token:2", "token:2", int64(1234), c.currentGeneration())
+	c.put("token:3", "token:3", int64(0), c.currentGeneration())
+	_, found := c.get("token:1")
+	assert.False(s.T(), found)
+	v, found := c.get("token:3")
+	assert.True(s.T(), found)
+	assert.Equal(s.T(), int64(0), v)
+}
+
+func (s *AnswerCacheTestSuite) TestExpiry() {
+	ttl := time.Duration(0)
+	c := newAnswerCache(10, func() time.Duration { return ttl })
+	c.setListening(true)
+
+	c.put("aco:1", "1", "first", c.currentGeneration())
+	_, found := c.get("aco:1")
+	assert.False(s.T(), found)
+
+	ttl = time.Minute
+	c.put("aco:1", "1", "first", c.currentGeneration())
+	_, found = c.get("aco:1")
+	assert.True(s.T(), found)
+
+	// without a ttl, answers are kept until invalidated
+	c = newAnswerCache(10, nil)
+	c.setListening(true)
+	c.put("token", "token", int64(1234), c.currentGeneration())
+	_, found = c.get("token")
+	assert.True(s.T(), found)
+}
+
+func TestAnswerCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(AnswerCacheTestSuite))
+}
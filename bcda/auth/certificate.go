@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+	"github.com/CMSgov/bcda-app/bcda/servicemux"
+)
+
+// When the API is served with BCDA_TLS_CLIENT_CA, a client may authenticate with a TLS client certificate instead
+// of a bearer token. A certificate authenticates the ACO it is registered to. Tokens issued to a client that
+// presented a certificate are bound to it by their cnf claim (RFC 8705), and are accepted only with it.
+
+// Confirmation is the cnf claim of a token bound to a client certificate
+type Confirmation struct {
+	X5TS256 string `json:"x5t#S256,omitempty"`
+}
+
+// CertificateThumbprint returns the x5t#S256 thumbprint of cert: the base64url SHA-256 digest of its DER encoding
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// certificateThumbprint returns the thumbprint of the client certificate r was made with; empty if there is none
+func certificateThumbprint(r *http.Request) string {
+	if cert := servicemux.ClientCertificate(r); cert != nil {
+		return CertificateThumbprint(cert)
+	}
+	return ""
+}
+
+// RegisterClientCertificate registers the PEM encoded certificate in certPEM to authenticate the ACO with acoID,
+// and returns the certificate's thumbprint
+func RegisterClientCertificate(acoID string, certPEM []byte) (string, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", errors.New("no PEM encoded certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("invalid certificate; %s", err)
+	}
+	if !time.Now().Before(cert.NotAfter) {
+		return "", fmt.Errorf("certificate expired at %s", cert.NotAfter.Format(time.RFC3339))
+	}
+
+	aco, err := getACOFromDB(acoID)
+	if err != nil {
+		return "", err
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	cc := ClientCertificate{
+		Thumbprint: CertificateThumbprint(cert),
+		ACOID:      aco.UUID,
+		Subject:    cert.Subject.String(),
+		NotAfter:   cert.NotAfter,
+		Active:     true,
+	}
+	err = db.Create(&cc).Error
+	audit(cliActor, "register-client-certificate", cc.Thumbprint, "", err)
+	if err != nil {
+		return "", err
+	}
+	return cc.Thumbprint, nil
+}
+
+// RevokeClientCertificate stops the certificate with thumbprint from authenticating its ACO. Tokens bound to it
+// expire unused, as the certificate can no longer be presented with them.
+func RevokeClientCertificate(thumbprint string) error {
+	if thumbprint == "" {
+		return errors.New("certificate thumbprint required")
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	result := db.Model(&ClientCertificate{}).Where("thumbprint = ? and active = ?", thumbprint, true).Update("active", false)
+	err := result.Error
+	if err == nil && result.RowsAffected == 0 {
+		err = fmt.Errorf("no active client certificate %s", thumbprint)
+	}
+	audit(cliActor, "revoke-client-certificate", thumbprint, "", err)
+	return err
+}
+
+// acoForCertificate returns the ACO cert is registered to
+func acoForCertificate(cert *x509.Certificate) (models.ACO, error) {
+	thumbprint := CertificateThumbprint(cert)
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var cc ClientCertificate
+	if db.First(&cc, "thumbprint = ? and active = ?", thumbprint, true).RecordNotFound() {
+		return models.ACO{}, fmt.Errorf("no active registration for client certificate %s", thumbprint)
+	}
+	if !time.Now().Before(cc.NotAfter) {
+		return models.ACO{}, fmt.Errorf("client certificate %s expired at %s", thumbprint, cc.NotAfter.Format(time.RFC3339))
+	}
+
+	aco, err := lookupACO(cc.ACOID.String())
+	if err != nil {
+		return models.ACO{}, fmt.Errorf("no ACO record found for client certificate %s", thumbprint)
+	}
+	return aco, nil
+}
+
+// certificateAuthData returns the identity of a request authenticated by cert. The request may do whatever a
+// token issued to the ACO's client may do.
+func certificateAuthData(cert *x509.Certificate) (AuthData, error) {
+	aco, err := acoForCertificate(cert)
+	if err != nil {
+		return AuthData{}, err
+	}
+
+	user, err := lookupUser(aco.UUID.String())
+	if err != nil {
+		return AuthData{}, err
+	}
+
+	scope, err := clientScope(aco.ClientID)
+	if err != nil {
+		return AuthData{}, err
+	}
+
+	return AuthData{ACOID: aco.UUID.String(), UserID: user.UUID.String(), Scopes: strings.Fields(scope)}, nil
+}
+
+// checkCertificateBinding returns an error if the token with claims is bound to a certificate other than cert
+func checkCertificateBinding(claims *CommonClaims, cert *x509.Certificate) error {
+	if claims.Confirmation == nil || claims.Confirmation.X5TS256 == "" {
+		return nil
+	}
+	if cert == nil {
+		return fmt.Errorf("token %s is bound to a client certificate, but none was presented", claims.Id)
+	}
+	if thumbprint := CertificateThumbprint(cert); thumbprint != claims.Confirmation.X5TS256 {
+		return fmt.Errorf("token %s is bound to client certificate %s, not %s", claims.Id, claims.Confirmation.X5TS256, thumbprint)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package auth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/CMSgov/bcda-app/bcda/auth"
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+	"github.com/CMSgov/bcda-app/bcda/testUtils"
+)
+
+type ClientCertificateTestSuite struct {
+	testUtils.AuthTestSuite
+	db         *gorm.DB
+	router     http.Handler
+	acoID      uuid.UUID
+	userID     uuid.UUID
+	cert       *x509.Certificate
+	thumbprint string
+}
+
+func (s *ClientCertificateTestSuite) SetupSuite() {
+	models.InitializeGormModels()
+	auth.InitializeGormModels()
+	s.SetupAuthBackend()
+
+	router := chi.NewRouter()
+	router.Use(auth.ParseToken)
+	router.With(auth.RequireTokenAuth).Get("/", func(w http.ResponseWriter, r *http.Request) {
+		ad, _ := r.Context().Value("ad").(auth.AuthData)
+		_, _ = w.Write([]byte(ad.ACOID))
+	})
+	s.router = router
+}
+
+func (s *ClientCertificateTestSuite) SetupTest() {
+	s.db = database.GetGORMDbConnection()
+
+	cmsID := testUtils.RandomHexID()[0:4]
+	var err error
+	s.acoID, err = models.CreateACO("Client Certificate Test ACO", &cmsID)
+	require.Nil(s.T(), err)
+	user, err := models.CreateUser("Client Certificate Test User", fmt.Sprintf("%s@example.com", s.acoID), s.acoID)
+	require.Nil(s.T(), err)
+	s.userID = user.UUID
+
+	var certPEM []byte
+	s.cert, certPEM = testUtils.ClientCertificate("A9999 client", time.Now().Add(time.Hour))
+	s.thumbprint, err = auth.RegisterClientCertificate(s.acoID.String(), certPEM)
+	require.Nil(s.T(), err)
+}
+
+func (s *ClientCertificateTestSuite) TearDownTest() {
+	s.db.Unscoped().Delete(&auth.AdminAuditEvent{}, "target = ?", s.thumbprint)
+	s.db.Unscoped().Delete(&auth.ClientCertificate{}, "aco_id = ?", s.acoID)
+	s.db.Unscoped().Delete(&auth.Token{}, "aco_id = ?", s.acoID)
+	s.db.Unscoped().Delete(&models.User{}, "uuid = ?", s.userID)
+	s.db.Unscoped().Delete(&models.ACO{}, "uuid = ?", s.acoID)
+	database.Close(s.db)
+}
+
+// get requests the test route, presenting a verified client certificate if cert is not nil
+func (s *ClientCertificateTestSuite) get(cert *x509.Certificate, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	if cert != nil {
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func (s *ClientCertificateTestSuite) TestCertificateAuthentication() {
+	assert.Equal(s.T(), auth.CertificateThumbprint(s.cert), s.thumbprint)
+
+	rr := s.get(s.cert, "")
+	assert.Equal(s.T(), http.StatusOK, rr.Code)
+	assert.Equal(s.T(), s.acoID.String(), rr.Body.String())
+
+	// no certificate, or one that isn't registered
+	assert.Equal(s.T(), http.StatusUnauthorized, s.get(nil, "").Code)
+	other, _ := testUtils.ClientCertificate("someone else", time.Now().Add(time.Hour))
+	assert.Equal(s.T(), http.StatusUnauthorized, s.get(other, "").Code)
+
+	assert.Nil(s.T(), auth.RevokeClientCertificate(s.thumbprint))
+	assert.Equal(s.T(), http.StatusUnauthorized, s.get(s.cert, "").Code)
+	assert.EqualError(s.T(), auth.RevokeClientCertificate(s.thumbprint), "no active client certificate "+s.thumbprint)
+	assert.EqualError(s.T(), auth.RevokeClientCertificate(""), "certificate thumbprint required")
+
+	var events []auth.AdminAuditEvent
+	s.db.Where("target = ?", s.thumbprint).Order("id").Find(&events)
+	require.Len(s.T(), events, 3)
+	assert.Equal(s.T(), "register-client-certificate", events[0].Action)
+	assert.Equal(s.T(), "revoke-client-certificate", events[1].Action)
+	assert.Equal(s.T(), "success", events[1].Outcome)
+	assert.Equal(s.T(), "no active client certificate "+s.thumbprint, events[2].Outcome)
+}
+
+func (s *ClientCertificateTestSuite) TestCertificateEnrollment() {
+	// an ACO enrolled in another provider can't use a certificate instead
+	require.Nil(s.T(), s.db.Model(&models.ACO{}).Where("uuid = ?", s.acoID).Update("auth_provider", auth.Okta).Error)
+	auth.NotifyACOChanged(s.acoID.String())
+	assert.Equal(s.T(), http.StatusUnauthorized, s.get(s.cert, "").Code)
+
+	require.Nil(s.T(), s.db.Model(&models.ACO{}).Where("uuid = ?", s.acoID).Update("auth_provider", auth.Alpha).Error)
+	auth.NotifyACOChanged(s.acoID.String())
+	assert.Equal(s.T(), http.StatusOK, s.get(s.cert, "").Code)
+}
+
+func (s *ClientCertificateTestSuite) TestRegisterClientCertificateErrors() {
+	_, err := auth.RegisterClientCertificate(s.acoID.String(), []byte("not a certificate"))
+	assert.EqualError(s.T(), err, "no PEM encoded certificate found")
+
+	_, certPEM := testUtils.ClientCertificate("expired", time.Now().Add(-time.Minute))
+	_, err = auth.RegisterClientCertificate(s.acoID.String(), certPEM)
+	assert.Contains(s.T(), err.Error(), "certificate expired at")
+
+	_, certPEM = testUtils.ClientCertificate("no ACO", time.Now().Add(time.Hour))
+	_, err = auth.RegisterClientCertificate(uuid.NewRandom().String(), certPEM)
+	assert.Contains(s.T(), err.Error(), "no ACO record found")
+
+	// a certificate authenticates one ACO
+	_, certPEM = testUtils.ClientCertificate("A9999 client", time.Now().Add(time.Hour))
+	thumbprint, err := auth.RegisterClientCertificate(s.acoID.String(), certPEM)
+	require.Nil(s.T(), err)
+	defer s.db.Unscoped().Delete(&auth.AdminAuditEvent{}, "target = ?", thumbprint)
+	_, err = auth.RegisterClientCertificate(s.acoID.String(), certPEM)
+	assert.NotNil(s.T(), err)
+}
+
+func (s *ClientCertificateTestSuite) TestBoundToken() {
+	creds, err := auth.GetProvider().RegisterClient(s.acoID.String())
+	require.Nil(s.T(), err)
+
+	// a token requested with a client certificate is bound to it
+	req := httptest.NewRequest("POST", "/auth/token", nil)
+	req.SetBasicAuth(creds.ClientID, creds.ClientSecret)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{s.cert}, VerifiedChains: [][]*x509.Certificate{{s.cert}}}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(auth.GetAuthToken).ServeHTTP(rr, req)
+	require.Equal(s.T(), http.StatusOK, rr.Code)
+	var tr TokenResponse
+	require.Nil(s.T(), json.NewDecoder(rr.Body).Decode(&tr))
+
+	t, err := auth.GetProvider().DecodeJWT(tr.AccessToken)
+	require.Nil(s.T(), err)
+	claims := t.Claims.(*auth.CommonClaims)
+	require.NotNil(s.T(), claims.Confirmation)
+	assert.Equal(s.T(), s.thumbprint, claims.Confirmation.X5TS256)
+
+	// and is accepted only with it
+	assert.Equal(s.T(), http.StatusOK, s.get(s.cert, tr.AccessToken).Code)
+	assert.Equal(s.T(), http.StatusUnauthorized, s.get(nil, tr.AccessToken).Code)
+	other, _ := testUtils.ClientCertificate("someone else", time.Now().Add(time.Hour))
+	assert.Equal(s.T(), http.StatusUnauthorized, s.get(other, tr.AccessToken).Code)
+
+	// a token requested without one is not
+	unbound, err := auth.GetProvider().MakeAccessToken(auth.Credentials{ClientID: creds.ClientID, ClientSecret: creds.ClientSecret})
+	require.Nil(s.T(), err)
+	t, err = auth.GetProvider().DecodeJWT(unbound)
+	require.Nil(s.T(), err)
+	assert.Nil(s.T(), t.Claims.(*auth.CommonClaims).Confirmation)
+	assert.Equal(s.T(), http.StatusOK, s.get(nil, unbound).Code)
+	assert.Equal(s.T(), http.StatusOK, s.get(other, unbound).Code)
+}
+
+func TestClientCertificateTestSuite(t *testing.T) {
+	suite.Run(t, new(ClientCertificateTestSuite))
+}
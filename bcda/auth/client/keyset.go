@@ -0,0 +1,122 @@
+package client
+
+import (
+	"crypto/rsa"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// an unknown key id causes a refresh at most this often, so a stream of bad tokens can't flood Okta
+	minRefreshInterval = 30 * time.Second
+	// a key we have not been able to confirm for this long is no longer trusted
+	keyRetention = 24 * time.Hour
+)
+
+// keySet caches the auth server's public signing keys. Okta rotates its keys, so when a token presents an
+// unknown key id the set is refreshed on demand; refreshes are rate limited, and concurrent callers share one.
+type keySet struct {
+	sync.RWMutex
+	keys        map[string]rsa.PublicKey
+	lastSeen    map[string]time.Time
+	lastRefresh time.Time
+	refreshing  chan struct{}
+	// fetch returns the current keys from the server, or nil if they can't be had
+	fetch func() map[string]rsa.PublicKey
+	now   func() time.Time
+}
+
+func newKeySet(fetch func() map[string]rsa.PublicKey) *keySet {
+	return &keySet{
+		keys:     make(map[string]rsa.PublicKey),
+		lastSeen: make(map[string]time.Time),
+		fetch:    fetch,
+		now:      time.Now,
+	}
+}
+
+func (ks *keySet) get(id string) (rsa.PublicKey, bool) {
+	ks.RLock()
+	defer ks.RUnlock()
+	key, ok := ks.keys[id]
+	return key, ok
+}
+
+// getOrRefresh returns the key with the given id, refreshing the set first if the id is unknown
+func (ks *keySet) getOrRefresh(id string) (rsa.PublicKey, bool) {
+	if key, ok := ks.get(id); ok {
+		return key, ok
+	}
+	ks.refresh(false)
+	return ks.get(id)
+}
+
+// refresh gets the current keys from the server, unless one was got too recently and force is false. Callers
+// arriving during a refresh wait for it instead of starting their own.
+func (ks *keySet) refresh(force bool) {
+	ks.Lock()
+	if ks.refreshing != nil {
+		done := ks.refreshing
+		ks.Unlock()
+		<-done
+		return
+	}
+	if !force && ks.now().Sub(ks.lastRefresh) < minRefreshInterval {
+		ks.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	ks.refreshing = done
+	ks.lastRefresh = ks.now()
+	ks.Unlock()
+
+	keys := ks.fetch()
+
+	ks.Lock()
+	ks.update(keys)
+	ks.refreshing = nil
+	ks.Unlock()
+	close(done)
+}
+
+// update replaces the cached keys with keys, logging any difference. When keys could not be fetched, the cached
+// keys are kept until they have gone unconfirmed for keyRetention.
+func (ks *keySet) update(keys map[string]rsa.PublicKey) {
+	now := ks.now()
+	var added, removed []string
+
+	if keys == nil {
+		for id, seen := range ks.lastSeen {
+			if now.Sub(seen) > keyRetention {
+				removed = append(removed, id)
+			}
+		}
+	} else {
+		for id := range keys {
+			if _, ok := ks.keys[id]; !ok {
+				added = append(added, id)
+			}
+			ks.keys[id] = keys[id]
+			ks.lastSeen[id] = now
+		}
+		for id := range ks.keys {
+			if _, ok := keys[id]; !ok {
+				removed = append(removed, id)
+			}
+		}
+	}
+
+	for _, id := range removed {
+		delete(ks.keys, id)
+		delete(ks.lastSeen, id)
+	}
+
+	if len(added) > 0 || len(removed) > 0 {
+		sort.Strings(added)
+		sort.Strings(removed)
+		logger.WithFields(logrus.Fields{"added_key_ids": added, "removed_key_ids": removed, "key_count": len(ks.keys)}).Info("okta signing keys changed")
+	}
+}
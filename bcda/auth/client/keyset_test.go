@@ -0,0 +1,133 @@
+package client
+
+import (
+	"crypto/rsa"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type KeySetTestSuite struct {
+	suite.Suite
+	ks      *keySet
+	now     time.Time
+	fetched int
+	served  map[string]rsa.PublicKey
+	release chan struct{}
+	mu      sync.Mutex
+}
+
+func (s *KeySetTestSuite) SetupTest() {
+	s.now = time.Now()
+	s.fetched = 0
+	s.served = map[string]rsa.PublicKey{"first": testKey(1)}
+	s.release = nil
+	s.ks = newKeySet(func() map[string]rsa.PublicKey {
+		s.mu.Lock()
+		s.fetched++
+		release := s.release
+		s.mu.Unlock()
+		if release != nil {
+			<-release
+		}
+		return s.served
+	})
+	s.ks.now = func() time.Time { return s.now }
+}
+
+func testKey(n int64) rsa.PublicKey {
+	return rsa.PublicKey{N: big.NewInt(n), E: 65537}
+}
+
+func (s *KeySetTestSuite) TestRefreshOnUnknownKeyID() {
+	s.ks.refresh(true)
+	assert.Equal(s.T(), 1, s.fetched)
+
+	_, ok := s.ks.getOrRefresh("first")
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), 1, s.fetched, "known keys don't cause a refresh")
+
+	// Okta rotates its key
+	s.served = map[string]rsa.PublicKey{"first": testKey(1), "second": testKey(2)}
+	s.now = s.now.Add(minRefreshInterval)
+	key, ok := s.ks.getOrRefresh("second")
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), testKey(2), key)
+	assert.Equal(s.T(), 2, s.fetched)
+}
+
+func (s *KeySetTestSuite) TestRefreshIsRateLimited() {
+	s.ks.refresh(true)
+
+	for i := 0; i < 10; i++ {
+		_, ok := s.ks.getOrRefresh("not a real key")
+		assert.False(s.T(), ok)
+	}
+	assert.Equal(s.T(), 1, s.fetched)
+
+	s.now = s.now.Add(minRefreshInterval)
+	_, _ = s.ks.getOrRefresh("not a real key")
+	assert.Equal(s.T(), 2, s.fetched)
+
+	// scheduled refreshes are not limited
+	s.ks.refresh(true)
+	assert.Equal(s.T(), 3, s.fetched)
+}
+
+func (s *KeySetTestSuite) TestRefreshIsSingleFlight() {
+	s.release = make(chan struct{})
+	s.served = map[string]rsa.PublicKey{"second": testKey(2)}
+
+	var wg sync.WaitGroup
+	found := make(chan bool, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok := s.ks.getOrRefresh("second")
+			found <- ok
+		}()
+	}
+
+	// let the callers pile up behind the first refresh before it completes
+	time.Sleep(100 * time.Millisecond)
+	close(s.release)
+	wg.Wait()
+	close(found)
+
+	assert.Equal(s.T(), 1, s.fetched)
+	for ok := range found {
+		assert.True(s.T(), ok)
+	}
+}
+
+func (s *KeySetTestSuite) TestEviction() {
+	s.served = map[string]rsa.PublicKey{"first": testKey(1), "second": testKey(2)}
+	s.ks.refresh(true)
+
+	// keys dropped by the server are dropped from the set
+	s.served = map[string]rsa.PublicKey{"second": testKey(2)}
+	s.ks.refresh(true)
+	_, ok := s.ks.get("first")
+	assert.False(s.T(), ok)
+
+	// keys are kept through failures to reach the server, for a while
+	s.served = nil
+	s.now = s.now.Add(keyRetention)
+	s.ks.refresh(true)
+	_, ok = s.ks.get("second")
+	assert.True(s.T(), ok)
+
+	s.now = s.now.Add(time.Minute)
+	s.ks.refresh(true)
+	_, ok = s.ks.get("second")
+	assert.False(s.T(), ok)
+}
+
+func TestKeySetTestSuite(t *testing.T) {
+	suite.Run(t, new(KeySetTestSuite))
+}
@@ -0,0 +1,416 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi"
+	"github.com/pborman/uuid"
+)
+
+// MoktaServer is a fake Okta organization with one authorization server. It serves the Okta endpoints that
+// OktaClient uses, so the Okta auth provider can be run and tested without a connection to Okta. Its clients
+// and signing key are kept in memory, and are lost when it stops.
+type MoktaServer struct {
+	sync.Mutex
+	serverID   string
+	apiToken   string
+	keyID      string
+	privateKey *rsa.PrivateKey
+	// client applications, by client id
+	clients map[string]*moktaApp
+	policy  Policy
+}
+
+type moktaApp struct {
+	metadata map[string]interface{}
+	secret   string
+	active   bool
+}
+
+// moktaTokenTTL is how long the access tokens issued by MoktaServer last, as Okta's do by default
+const moktaTokenTTL = time.Hour
+
+// NewMoktaServer returns a MoktaServer for the authorization server serverID, whose admin APIs accept apiToken
+// as their SSWS token
+func NewMoktaServer(serverID, apiToken string) (*MoktaServer, error) {
+	if serverID == "" || apiToken == "" {
+		return nil, fmt.Errorf("mokta server id and api token are required")
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MoktaServer{
+		serverID:   serverID,
+		apiToken:   apiToken,
+		keyID:      uuid.NewRandom().String(),
+		privateKey: privateKey,
+		clients:    make(map[string]*moktaApp),
+		policy: Policy{
+			ID:          uuid.NewRandom().String(),
+			Type:        "OAUTH_AUTHORIZATION_POLICY",
+			Status:      "ACTIVE",
+			Name:        "BCDA",
+			Description: "BCDA client applications",
+			Priority:    1,
+			Conditions:  Cond{Clients: Cli{Include: []string{}}},
+		},
+	}, nil
+}
+
+// Handler returns the routes of the fake organization
+func (m *MoktaServer) Handler() http.Handler {
+	r := chi.NewRouter()
+	r.Route(fmt.Sprintf("/oauth2/%s", m.serverID), func(r chi.Router) {
+		r.Get("/.well-known/oauth-authorization-server", m.metadata)
+		r.Get("/v1/keys", m.keys)
+		r.Post("/v1/token", m.token)
+		r.Post("/v1/introspect", m.introspect)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(m.requireAPIToken)
+		r.Post("/oauth2/v1/clients", m.createClient)
+		r.Get("/oauth2/v1/clients/{clientID}", m.getClient)
+		r.Put("/oauth2/v1/clients/{clientID}", m.updateClient)
+		r.Delete("/oauth2/v1/clients/{clientID}", m.deleteClient)
+		r.Post("/oauth2/v1/clients/{clientID}/lifecycle/newSecret", m.newSecret)
+		r.Post("/api/v1/apps/{clientID}/lifecycle/deactivate", m.deactivate)
+		r.Get(fmt.Sprintf("/api/v1/authorizationServers/%s/policies", m.serverID), m.getPolicies)
+		r.Put(fmt.Sprintf("/api/v1/authorizationServers/%s/policies/{policyID}", m.serverID), m.updatePolicy)
+	})
+	return r
+}
+
+func (m *MoktaServer) baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/oauth2/%s", scheme, r.Host, m.serverID)
+}
+
+func (m *MoktaServer) metadata(w http.ResponseWriter, r *http.Request) {
+	base := m.baseURL(r)
+	writeMoktaJSON(w, http.StatusOK, map[string]interface{}{
+		// BCDA expects the iss claim of Okta tokens to be the authorization server's id
+		"issuer":                 m.serverID,
+		"jwks_uri":               base + "/v1/keys",
+		"token_endpoint":         base + "/v1/token",
+		"introspection_endpoint": base + "/v1/introspect",
+		"grant_types_supported":  []string{"client_credentials"},
+		"scopes_supported":       []string{"bcda_api"},
+	})
+}
+
+func (m *MoktaServer) keys(w http.ResponseWriter, r *http.Request) {
+	pk := m.privateKey.PublicKey
+	writeMoktaJSON(w, http.StatusOK, KeyList{Keys: []*RsaJWK{{
+		KeyType:   "RSA",
+		Algorithm: "RS256",
+		ID:        m.keyID,
+		Use:       "sig",
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pk.E)).Bytes()),
+		N:         base64.RawURLEncoding.EncodeToString(pk.N.Bytes()),
+	}}})
+}
+
+// clientCredentials returns the credentials of a request to the authorization server, which may be sent by Basic
+// authentication, in the form, or, as OktaClient sends them, in the query string
+func clientCredentials(r *http.Request) (string, string) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		return id, secret
+	}
+	return r.FormValue("client_id"), r.FormValue("client_secret")
+}
+
+// authenticate reports whether the credentials are those of an active client the policy lets use the server
+func (m *MoktaServer) authenticate(clientID, secret string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	app, ok := m.clients[clientID]
+	if !ok || !app.active || app.secret != secret {
+		return false
+	}
+	for _, id := range m.policy.Conditions.Clients.Include {
+		if id == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MoktaServer) token(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("grant_type") != "client_credentials" {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be client_credentials")
+		return
+	}
+	scope := r.FormValue("scope")
+	if scope == "" {
+		scope = "bcda_api"
+	}
+	if scope != "bcda_api" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "only the bcda_api scope is supported")
+		return
+	}
+
+	clientID, secret := clientCredentials(r)
+	if !m.authenticate(clientID, secret) {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"ver": 1,
+		"jti": uuid.NewRandom().String(),
+		"iss": m.serverID,
+		"aud": m.baseURL(r),
+		"iat": now.Unix(),
+		"exp": now.Add(moktaTokenTTL).Unix(),
+		"cid": clientID,
+		"scp": []string{scope},
+		"sub": clientID,
+	})
+	token.Header["kid"] = m.keyID
+	tokenString, err := token.SignedString(m.privateKey)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	writeMoktaJSON(w, http.StatusOK, OktaToken{
+		AccessToken: tokenString,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(moktaTokenTTL.Seconds()),
+		Scope:       scope,
+	})
+}
+
+func (m *MoktaServer) introspect(w http.ResponseWriter, r *http.Request) {
+	if !m.authenticate(clientCredentials(r)) {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	claims := jwt.MapClaims{}
+	t, err := jwt.ParseWithClaims(r.FormValue("token"), claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return &m.privateKey.PublicKey, nil
+	})
+	if err != nil || !t.Valid {
+		writeMoktaJSON(w, http.StatusOK, map[string]interface{}{"active": false})
+		return
+	}
+
+	cid, _ := claims["cid"].(string)
+	m.Lock()
+	app, ok := m.clients[cid]
+	active := ok && app.active
+	m.Unlock()
+	if !active {
+		writeMoktaJSON(w, http.StatusOK, map[string]interface{}{"active": false})
+		return
+	}
+
+	writeMoktaJSON(w, http.StatusOK, map[string]interface{}{
+		"active":     true,
+		"client_id":  cid,
+		"scope":      "bcda_api",
+		"token_type": "Bearer",
+		"exp":        claims["exp"],
+		"iat":        claims["iat"],
+		"iss":        claims["iss"],
+		"jti":        claims["jti"],
+	})
+}
+
+func (m *MoktaServer) requireAPIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "SSWS "+m.apiToken {
+			writeOktaError(w, http.StatusUnauthorized, "E0000011", "Invalid token provided")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *MoktaServer) createClient(w http.ResponseWriter, r *http.Request) {
+	var metadata map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		writeOktaError(w, http.StatusBadRequest, "E0000003", "The request body was not well-formed.")
+		return
+	}
+	if name, _ := metadata["client_name"].(string); name == "" {
+		writeOktaError(w, http.StatusBadRequest, "E0000001", "Api validation failed: client_name")
+		return
+	}
+
+	secret, err := moktaSecret()
+	if err != nil {
+		writeOktaError(w, http.StatusInternalServerError, "E0000009", err.Error())
+		return
+	}
+	// Okta's client ids are 20 characters long
+	clientID := "0oa" + strings.Replace(uuid.NewRandom().String(), "-", "", -1)[0:17]
+	metadata["client_id"] = clientID
+	metadata["client_id_issued_at"] = time.Now().Unix()
+	metadata["client_secret_expires_at"] = 0
+
+	m.Lock()
+	m.clients[clientID] = &moktaApp{metadata: metadata, secret: secret, active: true}
+	body := m.clients[clientID].describe()
+	m.Unlock()
+
+	writeMoktaJSON(w, http.StatusCreated, body)
+}
+
+// describe returns the client's metadata, with its secret, as Okta does. The caller holds the server's lock.
+func (app *moktaApp) describe() map[string]interface{} {
+	result := map[string]interface{}{"client_secret": app.secret}
+	for k, v := range app.metadata {
+		result[k] = v
+	}
+	return result
+}
+
+// withApp calls f with the client named in the URL, holding the server's lock, or responds 404 if there is none
+func (m *MoktaServer) withApp(w http.ResponseWriter, r *http.Request, f func(clientID string, app *moktaApp)) {
+	clientID := chi.URLParam(r, "clientID")
+
+	m.Lock()
+	defer m.Unlock()
+
+	app, ok := m.clients[clientID]
+	if !ok {
+		writeOktaError(w, http.StatusNotFound, "E0000007", fmt.Sprintf("Not found: Resource not found: %s (AppInstance)", clientID))
+		return
+	}
+	f(clientID, app)
+}
+
+func (m *MoktaServer) getClient(w http.ResponseWriter, r *http.Request) {
+	m.withApp(w, r, func(clientID string, app *moktaApp) {
+		writeMoktaJSON(w, http.StatusOK, app.describe())
+	})
+}
+
+func (m *MoktaServer) updateClient(w http.ResponseWriter, r *http.Request) {
+	var metadata map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		writeOktaError(w, http.StatusBadRequest, "E0000003", "The request body was not well-formed.")
+		return
+	}
+
+	m.withApp(w, r, func(clientID string, app *moktaApp) {
+		// like Okta, an update replaces all of the client's metadata
+		for _, k := range []string{"client_id", "client_id_issued_at", "client_secret_expires_at"} {
+			metadata[k] = app.metadata[k]
+		}
+		app.metadata = metadata
+		writeMoktaJSON(w, http.StatusOK, app.describe())
+	})
+}
+
+func (m *MoktaServer) deleteClient(w http.ResponseWriter, r *http.Request) {
+	m.withApp(w, r, func(clientID string, app *moktaApp) {
+		delete(m.clients, clientID)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (m *MoktaServer) newSecret(w http.ResponseWriter, r *http.Request) {
+	secret, err := moktaSecret()
+	if err != nil {
+		writeOktaError(w, http.StatusInternalServerError, "E0000009", err.Error())
+		return
+	}
+
+	m.withApp(w, r, func(clientID string, app *moktaApp) {
+		app.secret = secret
+		writeMoktaJSON(w, http.StatusOK, app.describe())
+	})
+}
+
+func (m *MoktaServer) deactivate(w http.ResponseWriter, r *http.Request) {
+	m.withApp(w, r, func(clientID string, app *moktaApp) {
+		app.active = false
+		writeMoktaJSON(w, http.StatusOK, map[string]interface{}{})
+	})
+}
+
+func (m *MoktaServer) getPolicies(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+	writeMoktaJSON(w, http.StatusOK, []Policy{m.policy})
+}
+
+func (m *MoktaServer) updatePolicy(w http.ResponseWriter, r *http.Request) {
+	var policy Policy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		writeOktaError(w, http.StatusBadRequest, "E0000003", "The request body was not well-formed.")
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if chi.URLParam(r, "policyID") != m.policy.ID {
+		writeOktaError(w, http.StatusNotFound, "E0000007", "Not found: Resource not found: policy")
+		return
+	}
+	policy.ID = m.policy.ID
+	if policy.Conditions.Clients.Include == nil {
+		policy.Conditions.Clients.Include = []string{}
+	}
+	m.policy = policy
+	writeMoktaJSON(w, http.StatusOK, m.policy)
+}
+
+func moktaSecret() (string, error) {
+	b := make([]byte, 30)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func writeMoktaJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// errors from the authorization server, https://tools.ietf.org/html/rfc6749#section-5.2
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	writeMoktaJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// errors from Okta's admin APIs
+func writeOktaError(w http.ResponseWriter, status int, code, summary string) {
+	writeMoktaJSON(w, status, map[string]interface{}{
+		"errorCode":    code,
+		"errorSummary": summary,
+		"errorId":      uuid.NewRandom().String(),
+		"errorCauses":  []string{},
+	})
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// MoktaServerTestSuite exercises OktaClient against a MoktaServer
+type MoktaServerTestSuite struct {
+	suite.Suite
+	server     *httptest.Server
+	oc         *OktaClient
+	env        map[string]string
+	publicKeys *keySet
+}
+
+func (s *MoktaServerTestSuite) SetupTest() {
+	mokta, err := NewMoktaServer("mokta", "mokta-api-token")
+	require.Nil(s.T(), err)
+	s.server = httptest.NewServer(mokta.Handler())
+
+	s.env = make(map[string]string)
+	for k, v := range map[string]string{
+		"OKTA_CLIENT_ORGURL":               s.server.URL,
+		"OKTA_OAUTH_SERVER_ID":             "mokta",
+		"OKTA_CLIENT_TOKEN":                "mokta-api-token",
+		"OKTA_INTROSPECTION_CLIENT_ID":     "",
+		"OKTA_INTROSPECTION_CLIENT_SECRET": "",
+	} {
+		s.env[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+	require.Nil(s.T(), config())
+
+	s.publicKeys = publicKeys
+	publicKeys = newKeySet(getPublicKeys)
+	s.oc = &OktaClient{}
+}
+
+func (s *MoktaServerTestSuite) TearDownTest() {
+	s.server.Close()
+	for k, v := range s.env {
+		os.Setenv(k, v)
+	}
+	publicKeys = s.publicKeys
+	_ = config()
+}
+
+func (s *MoktaServerTestSuite) requestToken(clientID, secret string) (*jwt.Token, error) {
+	ot, err := s.oc.RequestAccessToken(Credentials{ClientID: clientID, ClientSecret: secret})
+	if err != nil {
+		return nil, err
+	}
+	return jwt.Parse(ot.AccessToken, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := s.oc.PublicKeyFor(kid)
+		require.True(s.T(), ok, "no key %s", kid)
+		return &key, nil
+	})
+}
+
+func (s *MoktaServerTestSuite) TestClientLifecycle() {
+	clientID, secret, name, err := s.oc.AddClientApplication("A9999")
+	require.Nil(s.T(), err)
+	assert.Len(s.T(), clientID, 20)
+	assert.NotEmpty(s.T(), secret)
+	assert.Equal(s.T(), "BCDA A9999", name)
+
+	t, err := s.requestToken(clientID, secret)
+	require.Nil(s.T(), err)
+	claims := t.Claims.(jwt.MapClaims)
+	assert.Equal(s.T(), s.oc.ServerID(), claims["iss"])
+	assert.Equal(s.T(), clientID, claims["cid"])
+
+	_, err = s.requestToken(clientID, "not the secret")
+	assert.EqualError(s.T(), err, "401 Unauthorized")
+
+	updated, err := s.oc.UpdateClientApplication(clientID, ClientUpdate{ClientName: "BCDA A9999 renamed"})
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "BCDA A9999 renamed", updated["client_name"])
+	assert.Equal(s.T(), "service", updated["application_type"])
+
+	newSecret, err := s.oc.GenerateNewClientSecret(clientID)
+	require.Nil(s.T(), err)
+	_, err = s.requestToken(clientID, secret)
+	assert.NotNil(s.T(), err)
+	_, err = s.requestToken(clientID, newSecret)
+	assert.Nil(s.T(), err)
+
+	require.Nil(s.T(), s.oc.DeactivateApplication(clientID))
+	_, err = s.requestToken(clientID, newSecret)
+	assert.NotNil(s.T(), err)
+
+	require.Nil(s.T(), s.oc.RemoveClientApplication(clientID))
+	_, err = s.oc.UpdateClientApplication(clientID, ClientUpdate{ClientName: "gone"})
+	assert.EqualError(s.T(), err, "404 Not Found")
+	assert.EqualError(s.T(), s.oc.RemoveClientApplication(clientID), "404 Not Found")
+}
+
+func (s *MoktaServerTestSuite) TestIntrospectToken() {
+	_, err := s.oc.IntrospectToken("token")
+	assert.Equal(s.T(), ErrIntrospectionDisabled, err)
+
+	introspectorID, introspectorSecret, _, err := s.oc.AddClientApplication("introspector")
+	require.Nil(s.T(), err)
+	os.Setenv("OKTA_INTROSPECTION_CLIENT_ID", introspectorID)
+	os.Setenv("OKTA_INTROSPECTION_CLIENT_SECRET", introspectorSecret)
+
+	clientID, secret, _, err := s.oc.AddClientApplication("A9998")
+	require.Nil(s.T(), err)
+	ot, err := s.oc.RequestAccessToken(Credentials{ClientID: clientID, ClientSecret: secret})
+	require.Nil(s.T(), err)
+
+	active, err := s.oc.IntrospectToken(ot.AccessToken)
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), active)
+
+	active, err = s.oc.IntrospectToken("not.a.token")
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), active)
+
+	require.Nil(s.T(), s.oc.DeactivateApplication(clientID))
+	active, err = s.oc.IntrospectToken(ot.AccessToken)
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), active)
+}
+
+func (s *MoktaServerTestSuite) TestAdminAPIsRequireAPIToken() {
+	oktaAuthString = "SSWS not-the-api-token"
+	_, _, _, err := s.oc.AddClientApplication("A9997")
+	assert.NotNil(s.T(), err)
+	assert.NotNil(s.T(), s.oc.DeactivateApplication("0oa00000000000000000"))
+}
+
+func TestMoktaServerTestSuite(t *testing.T) {
+	suite.Run(t, new(MoktaServerTestSuite))
+}
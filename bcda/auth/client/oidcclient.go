@@ -0,0 +1,276 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrRegistrationUnsupported is returned by RegisterClient when the issuer does not offer dynamic client
+// registration
+var ErrRegistrationUnsupported = errors.New("issuer does not support dynamic client registration")
+
+var (
+	oidcClients   = make(map[string]*OIDCClient)
+	oidcClientsMu sync.Mutex
+)
+
+// providerMetadata holds the parts of an OpenID Provider's discovery document that we use
+type providerMetadata struct {
+	Issuer               string `json:"issuer"`
+	JWKSURI              string `json:"jwks_uri"`
+	TokenEndpoint        string `json:"token_endpoint"`
+	RegistrationEndpoint string `json:"registration_endpoint"`
+}
+
+// OIDCClient talks to any OpenID Connect or OAuth 2.0 authorization server that publishes a discovery document,
+// using only standard endpoints. Metadata is discovered on first use and retried on failure, so, like the
+// OktaClient, an OIDCClient can be created whether or not its issuer is currently reachable.
+type OIDCClient struct {
+	issuer string
+	// an initial access token, required by some issuers for dynamic client registration
+	registrationToken string
+
+	mu            sync.Mutex
+	metadata      *providerMetadata
+	lastDiscovery time.Time
+
+	keys *keySet
+}
+
+// NewOIDCClient returns the OIDCClient for issuer, creating it on first request. registrationToken may be empty.
+func NewOIDCClient(issuer, registrationToken string) *OIDCClient {
+	oidcClientsMu.Lock()
+	defer oidcClientsMu.Unlock()
+
+	issuer = strings.TrimSuffix(issuer, "/")
+	if c, ok := oidcClients[issuer]; ok && c.registrationToken == registrationToken {
+		return c
+	}
+
+	c := &OIDCClient{issuer: issuer, registrationToken: registrationToken}
+	c.keys = newKeySet(c.getPublicKeys)
+	oidcClients[issuer] = c
+	return c
+}
+
+func (c *OIDCClient) Issuer() string {
+	return c.issuer
+}
+
+// discover returns the issuer's metadata, getting it if we don't have it and haven't just failed to
+func (c *OIDCClient) discover() (*providerMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.metadata != nil {
+		return c.metadata, nil
+	}
+	if time.Since(c.lastDiscovery) < minRefreshInterval {
+		return nil, fmt.Errorf("metadata for issuer %s is not available", c.issuer)
+	}
+	c.lastDiscovery = time.Now()
+
+	body, err := get(c.issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		logEmergency(err).WithField("issuer", c.issuer).Print("oidc provider metadata not available")
+		return nil, err
+	}
+
+	var md providerMetadata
+	if err = json.Unmarshal(body, &md); err != nil {
+		logEmergency(err).WithField("body", string(body)).Print("can't unmarshal oidc provider metadata")
+		return nil, err
+	}
+
+	// OpenID Connect Discovery 1.0 section 4.3
+	if md.Issuer != c.issuer {
+		err = fmt.Errorf("issuer in metadata %s does not match %s", md.Issuer, c.issuer)
+		logEmergency(err).Print("invalid oidc provider metadata")
+		return nil, err
+	}
+	if md.JWKSURI == "" || md.TokenEndpoint == "" {
+		err = errors.New("jwks_uri and token_endpoint are required")
+		logEmergency(err).WithField("issuer", c.issuer).Print("invalid oidc provider metadata")
+		return nil, err
+	}
+
+	c.metadata = &md
+	return c.metadata, nil
+}
+
+func (c *OIDCClient) getPublicKeys() map[string]rsa.PublicKey {
+	md, err := c.discover()
+	if err != nil {
+		return nil
+	}
+
+	body, err := get(md.JWKSURI)
+	if err != nil {
+		logEmergency(err).WithField("jwkurl", md.JWKSURI).Print("oidc signing keys not available")
+		return nil
+	}
+
+	keys, err := ParseSigningKeys(body)
+	if err != nil || len(keys) == 0 {
+		logEmergency(err).WithField("jwkurl", md.JWKSURI).Print("no usable oidc signing keys")
+		return nil
+	}
+
+	return keys
+}
+
+// ParseSigningKeys returns the RSA signature keys in a JWK Set. Unlike Okta's, a general issuer's key set may hold
+// keys for other purposes, such as encryption; these are skipped.
+func ParseSigningKeys(body []byte) (map[string]rsa.PublicKey, error) {
+	data := KeyList{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	pks := make(map[string]rsa.PublicKey)
+	for _, v := range data.Keys {
+		if v.KeyType != "RSA" || (v.Use != "" && v.Use != "sig") || (v.Algorithm != "" && !strings.HasPrefix(v.Algorithm, "RS")) {
+			continue
+		}
+
+		key, err := toPublicKey(v.N, v.E)
+		if err != nil {
+			return nil, err
+		}
+		pks[v.ID] = key
+	}
+
+	return pks, nil
+}
+
+// Returns the issuer's public signing key with the given key id, refreshing the issuer's keys if it is unknown
+func (c *OIDCClient) PublicKeyFor(id string) (rsa.PublicKey, bool) {
+	key, ok := c.keys.getOrRefresh(id)
+	if !ok {
+		logger.WithFields(logrus.Fields{"signing_key_id": id, "issuer": c.issuer}).Warn("invalid signing key id presented")
+	}
+	return key, ok
+}
+
+// Gets an access token from the issuer with the client credentials grant
+func (c *OIDCClient) RequestAccessToken(creds Credentials) (OktaToken, error) {
+	md, err := c.discover()
+	if err != nil {
+		return OktaToken{}, err
+	}
+
+	requestID := uuid.NewRandom()
+
+	params := url.Values{}
+	params.Set("grant_type", "client_credentials")
+
+	req, err := http.NewRequest("POST", md.TokenEndpoint, bytes.NewBufferString(params.Encode()))
+	if err != nil {
+		return OktaToken{}, err
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(creds.ClientID), url.QueryEscape(creds.ClientSecret))
+
+	logRequest(requestID).WithField("issuer", c.issuer).Print("requesting access token")
+	resp, err := client().Do(req)
+	if err != nil {
+		return OktaToken{}, err
+	}
+
+	defer resp.Body.Close()
+	logResponse(resp.StatusCode, requestID).Print()
+
+	if resp.StatusCode >= 400 {
+		err = errors.New(resp.Status)
+		logError(err, requestID).WithField("client_id", creds.ClientID).Info("unable to get access token")
+		return OktaToken{}, err
+	}
+
+	var ot OktaToken
+	if err = json.NewDecoder(resp.Body).Decode(&ot); err != nil {
+		message := "unexpected token response format from issuer"
+		logError(err, requestID).WithField("client_id", creds.ClientID).Info(message)
+		return OktaToken{}, errors.New(message)
+	}
+
+	return ot, nil
+}
+
+// Registers a client that uses the client credentials grant, by OAuth 2.0 Dynamic Client Registration (RFC 7591)
+func (c *OIDCClient) RegisterClient(clientName string) (clientID string, clientSecret string, err error) {
+	md, err := c.discover()
+	if err != nil {
+		return "", "", err
+	}
+	if md.RegistrationEndpoint == "" {
+		return "", "", ErrRegistrationUnsupported
+	}
+
+	requestID := uuid.NewRandom()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"client_name":                clientName,
+		"grant_types":                []string{"client_credentials"},
+		"token_endpoint_auth_method": "client_secret_basic",
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequest("POST", md.RegistrationEndpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return "", "", err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	if c.registrationToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.registrationToken)
+	}
+
+	logRequest(requestID).WithFields(logrus.Fields{"issuer": c.issuer, "client_name": clientName}).Print("registering client")
+	resp, err := client().Do(req)
+	if err != nil {
+		return "", "", err
+	}
+
+	defer resp.Body.Close()
+	logResponse(resp.StatusCode, requestID).Print()
+
+	if resp.StatusCode != http.StatusCreated {
+		b, _ := ioutil.ReadAll(resp.Body)
+		err = fmt.Errorf("unexpected result: %s %s", resp.Status, b)
+		logError(err, requestID).Print()
+		return "", "", err
+	}
+
+	var result struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		logError(err, requestID).Print()
+		return "", "", err
+	}
+	if result.ClientID == "" || result.ClientSecret == "" {
+		err = errors.New("registration response is missing client_id or client_secret")
+		logError(err, requestID).Print()
+		return "", "", err
+	}
+
+	return result.ClientID, result.ClientSecret, nil
+}
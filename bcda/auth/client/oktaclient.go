@@ -23,7 +23,7 @@ var oktaBaseUrl string
 var oktaAuthString string
 var oktaServerID string
 
-var publicKeys map[string]rsa.PublicKey
+var publicKeys = newKeySet(getPublicKeys)
 var once sync.Once
 
 type OktaToken struct {
@@ -88,7 +88,7 @@ func NewOktaClient() *OktaClient {
 	once.Do(func() {
 		err = config()
 		if err == nil {
-			publicKeys = getPublicKeys()
+			publicKeys.refresh(true)
 		}
 		// called even if there's been an error so we might recover
 		go refreshKeys()
@@ -96,7 +96,7 @@ func NewOktaClient() *OktaClient {
 	if err != nil {
 		logEmergency(err).Print("No public keys available for server")
 		// our practice is to not stop the app, even when it's in a state where it can do nothing but emit errors
-		// methods called on this ob value will result in errors until the publicKeys map is successfully updated
+		// methods called on this ob value will result in errors until the publicKeys set is successfully updated
 	}
 	return &OktaClient{}
 }
@@ -106,7 +106,7 @@ func (oc *OktaClient) ServerID() string {
 }
 
 func (oc *OktaClient) PublicKeyFor(id string) (rsa.PublicKey, bool) {
-	key, ok := publicKeys[id]
+	key, ok := publicKeys.getOrRefresh(id)
 	if !ok {
 		logger.WithFields(logrus.Fields{"signing_key_id": id}).Warn("invalid signing key id presented")
 	}
@@ -235,6 +235,27 @@ type Cli struct {
 // will not be able to use the server. To do this, we first get the current list of clients, add the new
 // server to the inclusion list, and put it back to the server
 func addClientToPolicy(clientID string, requestID uuid.UUID) error {
+	return updatePolicyClients(clientID, requestID, func(incl []string) []string {
+		return append(incl, clientID)
+	})
+}
+
+// Update the Auth Server's access policy to no longer include a client application we are removing
+func removeClientFromPolicy(clientID string, requestID uuid.UUID) error {
+	return updatePolicyClients(clientID, requestID, func(incl []string) []string {
+		// Okta wants an empty list, not null, when the last client is removed
+		remaining := []string{}
+		for _, id := range incl {
+			if id != clientID {
+				remaining = append(remaining, id)
+			}
+		}
+		return remaining
+	})
+}
+
+// Gets the Auth Server's access policy, changes its list of included clients with update, and puts it back
+func updatePolicyClients(clientID string, requestID uuid.UUID, update func([]string) []string) error {
 	policyUrl := fmt.Sprintf("%s/api/v1/authorizationServers/%s/policies", oktaBaseUrl, oktaServerID)
 
 	req, err := http.NewRequest("GET", policyUrl, nil)
@@ -245,7 +266,7 @@ func addClientToPolicy(clientID string, requestID uuid.UUID) error {
 
 	addRequestHeaders(req)
 
-	// not calling logRequest() because this is a step of AddClientApplication
+	// not calling logRequest() because this is a step of adding or removing a client application
 
 	resp, err := client().Do(req)
 
@@ -261,14 +282,13 @@ func addClientToPolicy(clientID string, requestID uuid.UUID) error {
 		return err
 	}
 
-	if len(result) > 1 {
-		logError(err, requestID).Print("more than one policy entry for server; can't continue safely")
+	if len(result) != 1 {
+		err = fmt.Errorf("expected one policy entry for server; found %d", len(result))
+		logError(err, requestID).Print("can't continue safely")
 		return err
 	}
 
-	incl := result[0].Conditions.Clients.Include
-	incl = append(incl, clientID)
-	result[0].Conditions.Clients.Include = incl
+	result[0].Conditions.Clients.Include = update(result[0].Conditions.Clients.Include)
 
 	body, err := json.Marshal(result[0])
 	if err != nil {
@@ -284,8 +304,6 @@ func addClientToPolicy(clientID string, requestID uuid.UUID) error {
 
 	addRequestHeaders(req)
 
-	// not calling logRequest() because this is a step of AddClientApplication
-
 	resp, err = client().Do(req)
 
 	if err != nil {
@@ -310,7 +328,7 @@ func addClientToPolicy(clientID string, requestID uuid.UUID) error {
 func refreshKeys() {
 	for range time.Tick(time.Hour * 1) {
 		logger.Info("Refreshing okta public keys")
-		publicKeys = getPublicKeys()
+		publicKeys.refresh(true)
 	}
 }
 
@@ -375,11 +393,18 @@ func (oc *OktaClient) DeactivateApplication(clientID string) error {
 	return nil
 }
 
+// Removes a client application from the Auth Server's access policy, then deletes it from our Okta organization
 func (oc *OktaClient) RemoveClientApplication(clientID string) error {
 	url := oktaBaseUrl + "/oauth2/v1/clients/" + clientID
 
 	reqID := uuid.NewRandom()
 	logRequest(reqID).WithFields(logrus.Fields{"url": url, "clientID": clientID}).Print()
+
+	err := removeClientFromPolicy(clientID, reqID)
+	if err != nil {
+		return err
+	}
+
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		logError(err, reqID)
@@ -402,6 +427,151 @@ func (oc *OktaClient) RemoveClientApplication(clientID string) error {
 	return nil
 }
 
+// ClientUpdate holds the client metadata that may be changed after registration. Empty fields are left unchanged.
+type ClientUpdate struct {
+	ClientName string `json:"client_name,omitempty"`
+	ClientURI  string `json:"client_uri,omitempty"`
+	LogoURI    string `json:"logo_uri,omitempty"`
+	PolicyURI  string `json:"policy_uri,omitempty"`
+	TosURI     string `json:"tos_uri,omitempty"`
+}
+
+// Changes the metadata of a client application, returning the client as Okta now describes it. Okta replaces
+// the whole of a client's metadata on update, so we get the current metadata and change only what was asked.
+func (oc *OktaClient) UpdateClientApplication(clientID string, update ClientUpdate) (map[string]interface{}, error) {
+	url := oktaBaseUrl + "/oauth2/v1/clients/" + clientID
+
+	reqID := uuid.NewRandom()
+	logRequest(reqID).WithFields(logrus.Fields{"url": url, "clientID": clientID}).Print("updating client in okta")
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		logError(err, reqID).Print()
+		return nil, err
+	}
+
+	addRequestHeaders(req)
+
+	resp, err := client().Do(req)
+	if err != nil {
+		logError(err, reqID).Print()
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		logError(errors.New(resp.Status), reqID).Print()
+		return nil, errors.New(resp.Status)
+	}
+
+	var metadata map[string]interface{}
+	if err = json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		logError(err, reqID).Print()
+		return nil, err
+	}
+
+	changes, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	// overlay the changes; omitempty leaves out the fields that are not changing
+	if err = json.Unmarshal(changes, &metadata); err != nil {
+		return nil, err
+	}
+	// Okta does not accept the client's secret, or the response-only fields, on update
+	for _, k := range []string{"client_secret", "client_id_issued_at", "client_secret_expires_at", "_links"} {
+		delete(metadata, k)
+	}
+
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err = http.NewRequest("PUT", url, bytes.NewBuffer(body))
+	if err != nil {
+		logError(err, reqID).Print()
+		return nil, err
+	}
+
+	addRequestHeaders(req)
+
+	resp, err = client().Do(req)
+	if err != nil {
+		logError(err, reqID).Print()
+		return nil, err
+	}
+	defer resp.Body.Close()
+	logResponse(resp.StatusCode, reqID).Print()
+	if resp.StatusCode >= 400 {
+		logError(errors.New(resp.Status), reqID).Print()
+		return nil, errors.New(resp.Status)
+	}
+
+	var result map[string]interface{}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		logError(err, reqID).Print()
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ErrIntrospectionDisabled is returned by IntrospectToken when no client is configured to make introspection requests
+var ErrIntrospectionDisabled = errors.New("token introspection is not configured")
+
+// IntrospectToken asks the authorization server whether tokenString is active (RFC 7662). Okta requires
+// introspection requests to be made by a client of the server, identified by OKTA_INTROSPECTION_CLIENT_ID and
+// OKTA_INTROSPECTION_CLIENT_SECRET; if these are not set, ErrIntrospectionDisabled is returned.
+func (oc *OktaClient) IntrospectToken(tokenString string) (bool, error) {
+	clientID := os.Getenv("OKTA_INTROSPECTION_CLIENT_ID")
+	clientSecret := os.Getenv("OKTA_INTROSPECTION_CLIENT_SECRET")
+	if clientID == "" || clientSecret == "" {
+		return false, ErrIntrospectionDisabled
+	}
+
+	requestID := uuid.NewRandom()
+
+	params := url.Values{}
+	params.Set("token", tokenString)
+	params.Set("token_type_hint", "access_token")
+
+	introspectURL := fmt.Sprintf("%s/oauth2/%s/v1/introspect", oktaBaseUrl, oktaServerID)
+	req, err := http.NewRequest("POST", introspectURL, bytes.NewBufferString(params.Encode()))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, clientSecret)
+
+	logRequest(requestID).Print("introspecting Okta access token")
+	resp, err := client().Do(req)
+	if err != nil {
+		logError(err, requestID).Print()
+		return false, err
+	}
+
+	defer resp.Body.Close()
+	logResponse(resp.StatusCode, requestID).Print()
+
+	if resp.StatusCode >= 400 {
+		err = errors.New(resp.Status)
+		logError(err, requestID).Info("unable to introspect access token")
+		return false, err
+	}
+
+	var result struct {
+		Active bool `json:"active"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		message := "unexpected introspection response format from Okta"
+		logError(err, requestID).Info(message)
+		return false, errors.New(message)
+	}
+
+	return result.Active, nil
+}
+
 func client() *http.Client {
 	return &http.Client{Timeout: time.Second * 10}
 }
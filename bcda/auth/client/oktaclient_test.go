@@ -116,6 +116,15 @@ func (s *OTestSuite) TestDeactivateApplication() {
 	assert.Equal(s.T(), "401 Unauthorized", err.Error())
 }
 
+func (s *OTestSuite) TestIntrospectToken() {
+	active, err := s.oc.IntrospectToken("not.a.token")
+	if err == ErrIntrospectionDisabled {
+		s.T().Skip("Test requires OKTA_INTROSPECTION_CLIENT_ID and OKTA_INTROSPECTION_CLIENT_SECRET")
+	}
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), active)
+}
+
 func (s *OTestSuite) TearDownTest() {
 }
 
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/CMSgov/bcda-app/bcda/models"
+)
+
+// TokenInspection describes a token for support engineers, and says why it is or is not accepted. Its claims are
+// reported as they are written in the token, whether or not the token is valid.
+type TokenInspection struct {
+	// Provider names the auth provider that verifies the token
+	Provider string
+	Header   map[string]interface{}
+	Claims   jwt.MapClaims
+	// ACO is the ACO the token is issued to, if it can be found
+	ACO *models.ACO
+	// Checks are the results of the checks made in verifying the token, in the order they are made
+	Checks []TokenCheck
+	// Err is why the token is rejected, or nil if it is accepted. It is the answer RequireTokenAuth would give.
+	Err error
+}
+
+// TokenCheck is the result of one check made in verifying a token. Err is nil if the token passed.
+type TokenCheck struct {
+	Name string
+	Err  error
+}
+
+// InspectToken decodes tokenString with the provider that issued it, or the active provider if that can't be
+// told, and checks it as RequireTokenAuth would, recording the result of each check. Checks that depend on one that
+// failed are still made where they can be, so that every problem with the token is reported. An error is returned
+// only if tokenString is not a JWT.
+func InspectToken(tokenString string) (TokenInspection, error) {
+	var ti TokenInspection
+
+	raw := jwt.MapClaims{}
+	t, _, err := new(jwt.Parser).ParseUnverified(tokenString, raw)
+	if err != nil {
+		return ti, fmt.Errorf("not a JWT; %s", err)
+	}
+	ti.Header, ti.Claims = t.Header, raw
+
+	name, provider, err := ProviderForToken(tokenString)
+	if err != nil {
+		name, provider = GetProviderName(), GetProvider()
+	}
+	ti.Provider = name
+	ti.check("provider", err)
+
+	// the claims as the provider reads them, if the signature is good; otherwise as they are written
+	c, err := decodeForInspection(provider, tokenString)
+	ti.check("signature", err)
+	if c == nil {
+		c = unverifiedClaims(raw)
+	}
+
+	ti.check("expiry", checkTimes(c))
+	ti.check("required claims", requiredClaims(name, c))
+	ti.check("revocation", revocationOf(name, c))
+
+	aco, err := inspectedACO(c)
+	if err == nil {
+		ti.ACO = &aco
+		ti.check("aco", nil)
+		ti.check("enrollment", checkEnrollment(aco.UUID.String(), name))
+	} else {
+		ti.check("aco", err)
+	}
+
+	// the verdict is the provider's, whatever the checks above found
+	ti.Err = ti.Checks[0].Err
+	if ti.Err == nil {
+		ti.Err = provider.ValidateJWT(tokenString)
+	}
+	if ti.Err == nil && ti.ACO != nil {
+		ti.Err = checkEnrollment(ti.ACO.UUID.String(), name)
+	}
+	return ti, nil
+}
+
+func (ti *TokenInspection) check(name string, err error) {
+	ti.Checks = append(ti.Checks, TokenCheck{Name: name, Err: err})
+}
+
+// timeErrors are the validation errors that say nothing about the signature
+const timeErrors = jwt.ValidationErrorExpired | jwt.ValidationErrorNotValidYet | jwt.ValidationErrorIssuedAt
+
+// decodeForInspection decodes tokenString with provider, returning the error, if any, with its signature. The
+// claims are returned if the signature is good and the provider could read them.
+func decodeForInspection(provider Provider, tokenString string) (*CommonClaims, error) {
+	t, err := provider.DecodeJWT(tokenString)
+	if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&^timeErrors == 0 {
+		// checkTimes reports these
+		err = nil
+	}
+	if err != nil || t == nil {
+		return nil, err
+	}
+	c, _ := t.Claims.(*CommonClaims)
+	return c, nil
+}
+
+// unverifiedClaims reads raw as our own tokens' claims are read
+func unverifiedClaims(raw jwt.MapClaims) *CommonClaims {
+	c := &CommonClaims{}
+	standard := jwt.MapClaims{}
+	for k, v := range raw {
+		standard[k] = v
+	}
+	// other providers may name more than one audience
+	if _, ok := standard["aud"].(string); !ok {
+		delete(standard, "aud")
+	}
+	if b, err := json.Marshal(standard); err == nil {
+		_ = json.Unmarshal(b, c)
+	}
+	return c
+}
+
+func checkTimes(c *CommonClaims) error {
+	if c.ExpiresAt == 0 {
+		return errors.New("no exp claim")
+	}
+	return c.Valid()
+}
+
+func requiredClaims(name string, c *CommonClaims) error {
+	if name == Alpha {
+		return checkRequiredClaims(c)
+	}
+	if c.ExpiresAt == 0 || c.Id == "" {
+		return fmt.Errorf("missing one or more required claims")
+	}
+	return nil
+}
+
+// revocationOf returns an error if the token with claims c, or the credentials it was issued to, have been revoked
+func revocationOf(name string, c *CommonClaims) error {
+	if name == Alpha {
+		revoked, err := isRevoked(c.Id)
+		if err == nil && revoked {
+			err = fmt.Errorf("token %s has been revoked", c.Id)
+		}
+		return err
+	}
+
+	revoked, err := isTokenIDRevoked(c.Id)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return fmt.Errorf("token %s has been revoked", c.Id)
+	}
+	if c.ClientID == "" {
+		return nil
+	}
+	revokedAt, err := clientRevokedAt(c.ClientID)
+	if err != nil {
+		return err
+	}
+	if revokedAt != 0 && c.IssuedAt <= revokedAt {
+		return fmt.Errorf("credentials for client %s have been revoked", c.ClientID)
+	}
+	return nil
+}
+
+// inspectedACO returns the ACO named by the token's aco claim, or failing that, the ACO of its client
+func inspectedACO(c *CommonClaims) (models.ACO, error) {
+	if c.ACOID != "" {
+		return getACOFromDB(c.ACOID)
+	}
+	if c.ClientID != "" {
+		return GetACOByClientID(c.ClientID)
+	}
+	return models.ACO{}, errors.New("token names neither an ACO nor a client")
+}
@@ -0,0 +1,127 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/CMSgov/bcda-app/bcda/auth"
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+	"github.com/CMSgov/bcda-app/bcda/testUtils"
+)
+
+type InspectTokenTestSuite struct {
+	testUtils.AuthTestSuite
+	db    *gorm.DB
+	acoID uuid.UUID
+	cmsID string
+}
+
+func (s *InspectTokenTestSuite) SetupSuite() {
+	models.InitializeGormModels()
+	auth.InitializeGormModels()
+	s.SetupAuthBackend()
+}
+
+func (s *InspectTokenTestSuite) SetupTest() {
+	s.db = database.GetGORMDbConnection()
+	s.cmsID = testUtils.RandomHexID()[0:4]
+	var err error
+	s.acoID, err = models.CreateACO("Inspect Token Test ACO", &s.cmsID)
+	require.Nil(s.T(), err)
+}
+
+func (s *InspectTokenTestSuite) TearDownTest() {
+	s.db.Unscoped().Delete(&auth.Token{}, "aco_id = ?", s.acoID)
+	s.db.Unscoped().Delete(&models.ACO{}, "uuid = ?", s.acoID)
+	database.Close(s.db)
+}
+
+// failed returns the names of the checks that failed
+func failed(ti auth.TokenInspection) []string {
+	var names []string
+	for _, c := range ti.Checks {
+		if c.Err != nil {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+func (s *InspectTokenTestSuite) TestValidToken() {
+	tokenID := uuid.NewRandom().String()
+	ts, err := auth.TokenStringWithIDs(tokenID, s.acoID.String())
+	require.Nil(s.T(), err)
+
+	ti, err := auth.InspectToken(ts)
+	require.Nil(s.T(), err)
+	assert.Nil(s.T(), ti.Err)
+	assert.Empty(s.T(), failed(ti))
+	assert.Equal(s.T(), auth.Alpha, ti.Provider)
+	assert.Equal(s.T(), "RS512", ti.Header["alg"])
+	assert.Equal(s.T(), tokenID, ti.Claims["id"])
+	require.NotNil(s.T(), ti.ACO)
+	assert.Equal(s.T(), "Inspect Token Test ACO", ti.ACO.Name)
+	assert.Equal(s.T(), s.cmsID, *ti.ACO.CMSID)
+
+	names := make([]string, len(ti.Checks))
+	for i, c := range ti.Checks {
+		names[i] = c.Name
+	}
+	assert.Equal(s.T(), []string{"provider", "signature", "expiry", "required claims", "revocation", "aco", "enrollment"}, names)
+}
+
+func (s *InspectTokenTestSuite) TestExpiredToken() {
+	ts, err := auth.TokenStringExpiration(uuid.NewRandom().String(), s.acoID.String(), -time.Hour)
+	require.Nil(s.T(), err)
+
+	ti, err := auth.InspectToken(ts)
+	require.Nil(s.T(), err)
+	assert.Contains(s.T(), ti.Err.Error(), "expired")
+	// the signature is good; only the times are not
+	assert.Equal(s.T(), []string{"expiry"}, failed(ti))
+	assert.NotNil(s.T(), ti.ACO)
+}
+
+func (s *InspectTokenTestSuite) TestTamperedToken() {
+	ts, err := auth.TokenStringWithIDs(uuid.NewRandom().String(), s.acoID.String())
+	require.Nil(s.T(), err)
+	parts := strings.Split(ts, ".")
+	other, err := auth.TokenStringWithIDs(uuid.NewRandom().String(), uuid.NewRandom().String())
+	require.Nil(s.T(), err)
+	parts[1] = strings.Split(other, ".")[1]
+
+	ti, err := auth.InspectToken(strings.Join(parts, "."))
+	require.Nil(s.T(), err)
+	assert.NotNil(s.T(), ti.Err)
+	// the claims are reported as written, though they can't be believed
+	assert.Equal(s.T(), []string{"signature", "aco"}, failed(ti))
+	assert.Nil(s.T(), ti.ACO)
+}
+
+func (s *InspectTokenTestSuite) TestRevokedToken() {
+	ts, err := auth.TokenStringWithIDs(uuid.NewRandom().String(), s.acoID.String())
+	require.Nil(s.T(), err)
+	require.Nil(s.T(), auth.GetProvider().RevokeAccessToken(ts))
+
+	ti, err := auth.InspectToken(ts)
+	require.Nil(s.T(), err)
+	assert.Contains(s.T(), ti.Err.Error(), "has been revoked")
+	assert.Equal(s.T(), []string{"revocation"}, failed(ti))
+}
+
+func (s *InspectTokenTestSuite) TestNotAJWT() {
+	_, err := auth.InspectToken("not-a-token")
+	assert.Contains(s.T(), err.Error(), "not a JWT")
+}
+
+func TestInspectTokenTestSuite(t *testing.T) {
+	suite.Run(t, new(InspectTokenTestSuite))
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/CMSgov/bcda-app/bcda/database"
+)
+
+// Resource servers, such as services that ACOs present their BCDA tokens to, check those tokens at /auth/introspect
+// (https://tools.ietf.org/html/rfc7662). They authenticate with a credential of their own, which cannot be used to
+// get tokens.
+
+// CreateResourceServer creates a credential for a service that introspects tokens
+func CreateResourceServer(name string) (Credentials, error) {
+	if name == "" {
+		return Credentials{}, errors.New("resource server name required")
+	}
+
+	secret, err := generateClientSecret()
+	if err != nil {
+		return Credentials{}, err
+	}
+	hash, err := NewHash(secret)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	rs := ResourceServer{ClientID: uuid.NewRandom().String(), Name: name, SecretHash: hash.String(), Active: true}
+	err = db.Create(&rs).Error
+	audit(cliActor, "create-resource-server", rs.ClientID, "", err)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	return Credentials{ClientID: rs.ClientID, ClientSecret: secret, ClientName: name}, nil
+}
+
+// DeactivateResourceServer disables a resource server's credential
+func DeactivateResourceServer(clientID string) error {
+	if clientID == "" {
+		return errors.New("resource server client ID required")
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	result := db.Model(&ResourceServer{}).Where("client_id = ? and active = ?", clientID, true).Update("active", false)
+	err := result.Error
+	if err == nil && result.RowsAffected == 0 {
+		err = fmt.Errorf("no active resource server %s", clientID)
+	}
+	audit(cliActor, "deactivate-resource-server", clientID, "", err)
+	return err
+}
+
+// https://tools.ietf.org/html/rfc7662#section-2.2
+type introspection struct {
+	Active   bool   `json:"active"`
+	ClientID string `json:"client_id,omitempty"`
+	ACOID    string `json:"aco,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	Exp      int64  `json:"exp,omitempty"`
+	// the client certificate the token is bound to, if any
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+}
+
+/*
+	swagger:route POST /auth/introspect auth IntrospectToken
+
+	Introspect a token
+
+	Verifies Basic authentication credentials of a resource server, and reports whether the token it was presented
+	is active and, if so, the client and ACO it was issued to, its scope, and when it expires.
+
+	Consumes:
+	- application/x-www-form-urlencoded
+
+	Produces:
+	- application/json
+
+	Schemes: https
+
+	Security:
+		basic_auth:
+
+	Responses:
+		200: introspectionResponse
+		400: invalidRequest
+		401: invalidCredentials
+		500: serverError
+*/
+func IntrospectToken(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || !isResourceServer(clientID, secret) {
+		log.WithField("client_id", clientID).Error("invalid resource server credentials")
+		w.Header().Set("WWW-Authenticate", `Basic realm="bcda"`)
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client", "resource server authentication failed")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	tokenString := r.PostForm.Get("token")
+	if tokenString == "" {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	result, err := introspect(tokenString)
+	if err != nil {
+		// the reason a token is inactive is not disclosed to the resource server
+		log.WithField("resource_server", clientID).Infof("introspected inactive token; %s", err)
+		result = introspection{Active: false}
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func isResourceServer(clientID, secret string) bool {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var rs ResourceServer
+	if db.First(&rs, "client_id = ? and active = ?", clientID, true).RecordNotFound() {
+		return false
+	}
+	return Hash(rs.SecretHash).IsHashOf(secret)
+}
+
+// introspect validates a token as RequireTokenAuth would, and describes it
+func introspect(tokenString string) (introspection, error) {
+	name, provider, err := ProviderForToken(tokenString)
+	if err != nil {
+		return introspection{}, err
+	}
+	if err = provider.ValidateJWT(tokenString); err != nil {
+		return introspection{}, err
+	}
+	t, err := provider.DecodeJWT(tokenString)
+	if err != nil {
+		return introspection{}, err
+	}
+	c, ok := t.Claims.(*CommonClaims)
+	if !ok {
+		return introspection{}, errors.New("unexpected claims type")
+	}
+	if err = checkEnrollment(c.ACOID, name); err != nil {
+		return introspection{}, err
+	}
+
+	clientID := c.ClientID
+	if clientID == "" && c.ACOID != "" {
+		// our own tokens do not name the client; it is the ACO's
+		aco, err := getACOFromDB(c.ACOID)
+		if err != nil {
+			return introspection{}, err
+		}
+		clientID = aco.ClientID
+	}
+
+	return introspection{
+		Active:   true,
+		ClientID: clientID,
+		ACOID:    c.ACOID,
+		Scope:    strings.Join(c.Scopes, " "),
+		Exp:      c.ExpiresAt,
+
+		Confirmation: c.Confirmation,
+	}, nil
+}
@@ -0,0 +1,127 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/CMSgov/bcda-app/bcda/auth"
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+	"github.com/CMSgov/bcda-app/bcda/testUtils"
+)
+
+type IntrospectTestSuite struct {
+	testUtils.AuthTestSuite
+	db     *gorm.DB
+	router http.Handler
+	rs     auth.Credentials
+}
+
+func (s *IntrospectTestSuite) SetupSuite() {
+	models.InitializeGormModels()
+	auth.InitializeGormModels()
+	s.SetupAuthBackend()
+}
+
+func (s *IntrospectTestSuite) SetupTest() {
+	s.db = database.GetGORMDbConnection()
+	s.router = auth.NewAuthRouter()
+
+	var err error
+	s.rs, err = auth.CreateResourceServer("Introspect Test")
+	require.Nil(s.T(), err)
+}
+
+func (s *IntrospectTestSuite) TearDownTest() {
+	s.db.Unscoped().Delete(&auth.AdminAuditEvent{}, "target = ?", s.rs.ClientID)
+	s.db.Unscoped().Delete(&auth.ResourceServer{}, "client_id = ?", s.rs.ClientID)
+	database.Close(s.db)
+}
+
+func (s *IntrospectTestSuite) introspect(clientID, secret, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	form := url.Values{}
+	if token != "" {
+		form.Set("token", token)
+	}
+	req := httptest.NewRequest("POST", "/auth/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, secret)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+
+	var body map[string]interface{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &body)
+	return rr, body
+}
+
+func (s *IntrospectTestSuite) TestIntrospectToken() {
+	devACOID := "0c527d2e-2e8a-4808-b11d-0fa06baf8254"
+	creds, err := auth.GetProvider().RegisterClient(devACOID)
+	require.Nil(s.T(), err)
+	token, err := auth.GetProvider().MakeAccessToken(creds)
+	require.Nil(s.T(), err)
+
+	rr, body := s.introspect(s.rs.ClientID, s.rs.ClientSecret, token)
+	assert.Equal(s.T(), http.StatusOK, rr.Code)
+	assert.Equal(s.T(), "no-store", rr.Header().Get("Cache-Control"))
+	assert.Equal(s.T(), true, body["active"])
+	assert.Equal(s.T(), creds.ClientID, body["client_id"])
+	assert.Equal(s.T(), devACOID, body["aco"])
+	assert.NotZero(s.T(), body["exp"])
+
+	require.Nil(s.T(), auth.GetProvider().RevokeAccessToken(token))
+	rr, body = s.introspect(s.rs.ClientID, s.rs.ClientSecret, token)
+	assert.Equal(s.T(), http.StatusOK, rr.Code)
+	assert.Equal(s.T(), map[string]interface{}{"active": false}, body)
+
+	rr, body = s.introspect(s.rs.ClientID, s.rs.ClientSecret, "not.a.token")
+	assert.Equal(s.T(), http.StatusOK, rr.Code)
+	assert.Equal(s.T(), map[string]interface{}{"active": false}, body)
+}
+
+func (s *IntrospectTestSuite) TestIntrospectTokenScope() {
+	devACOID := "0c527d2e-2e8a-4808-b11d-0fa06baf8254"
+	creds, err := auth.GetProvider().RegisterClient(devACOID)
+	require.Nil(s.T(), err)
+	require.Nil(s.T(), auth.SetClientScope(creds.ClientID, "system/Patient.read"))
+	defer s.db.Unscoped().Delete(&auth.ClientScope{}, "client_id = ?", creds.ClientID)
+	token, err := auth.GetProvider().MakeAccessToken(creds)
+	require.Nil(s.T(), err)
+
+	_, body := s.introspect(s.rs.ClientID, s.rs.ClientSecret, token)
+	assert.Equal(s.T(), true, body["active"])
+	assert.Equal(s.T(), "system/Patient.read", body["scope"])
+}
+
+func (s *IntrospectTestSuite) TestIntrospectTokenInvalidRequests() {
+	rr, body := s.introspect(s.rs.ClientID, "not the secret", "token")
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+	assert.Equal(s.T(), "invalid_client", body["error"])
+
+	rr, body = s.introspect(s.rs.ClientID, s.rs.ClientSecret, "")
+	assert.Equal(s.T(), http.StatusBadRequest, rr.Code)
+	assert.Equal(s.T(), "invalid_request", body["error"])
+
+	// ACO credentials are not resource server credentials
+	creds, err := auth.GetProvider().RegisterClient("0c527d2e-2e8a-4808-b11d-0fa06baf8254")
+	require.Nil(s.T(), err)
+	rr, _ = s.introspect(creds.ClientID, creds.ClientSecret, "token")
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+
+	require.Nil(s.T(), auth.DeactivateResourceServer(s.rs.ClientID))
+	rr, _ = s.introspect(s.rs.ClientID, s.rs.ClientSecret, "token")
+	assert.Equal(s.T(), http.StatusUnauthorized, rr.Code)
+}
+
+func TestIntrospectTestSuite(t *testing.T) {
+	suite.Run(t, new(IntrospectTestSuite))
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/utils"
+)
+
+// Failed token requests are counted against the client ID and the source address that made them. When either has
+// failed too often it is locked out: its requests fail, whatever the secret, for a window that doubles with each
+// lockout. A locked out request gets the same response as a bad secret, so the response does not tell whether the
+// client exists.
+const (
+	lockoutClientPrefix  = "client:"
+	lockoutAddressPrefix = "ip:"
+	maxLockoutWindow     = 24 * time.Hour
+	// failures older than this are forgotten
+	failureMemory = time.Hour
+)
+
+// lockoutThreshold is the number of failures after which principal is locked out. Many clients may share an
+// address, so addresses are allowed more.
+func lockoutThreshold(principal string) int {
+	if strings.HasPrefix(principal, lockoutAddressPrefix) {
+		return utils.GetEnvInt("BCDA_ADDRESS_LOCKOUT_THRESHOLD", 20)
+	}
+	return utils.GetEnvInt("BCDA_CLIENT_LOCKOUT_THRESHOLD", 5)
+}
+
+// lockoutWindow is how long a principal that has been locked out lockouts times before is locked out
+func lockoutWindow(lockouts int) time.Duration {
+	window := time.Duration(utils.GetEnvInt("BCDA_LOCKOUT_SECONDS", 60)) * time.Second
+	for i := 0; i < lockouts && window < maxLockoutWindow; i++ {
+		window *= 2
+	}
+	if window > maxLockoutWindow {
+		window = maxLockoutWindow
+	}
+	return window
+}
+
+// lockoutPrincipals returns the principals a token request is counted against
+func lockoutPrincipals(clientID string, r *http.Request) []string {
+	return []string{lockoutClientPrefix + clientID, lockoutAddressPrefix + clientIP(r)}
+}
+
+// checkLockout returns an error if any of principals is locked out
+func checkLockout(principals []string) error {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var lockouts []Lockout
+	err := db.Where("principal in (?) and locked_until > ?", principals, time.Now()).Find(&lockouts).Error
+	if err != nil {
+		return err
+	}
+	if len(lockouts) > 0 {
+		return fmt.Errorf("%s is locked out until %s", lockouts[0].Principal, lockouts[0].LockedUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// countFailure adds one to the failures of principal, forgetting those older than failureMemory and lockouts older
+// than maxLockoutWindow. It is one statement, so that concurrent failures are each counted.
+const countFailure = `INSERT INTO lockouts (created_at, updated_at, principal, failures, lockouts) VALUES (?, ?, ?, 1, 0)
+ON CONFLICT (principal) DO UPDATE SET updated_at = excluded.updated_at,
+	failures = CASE WHEN lockouts.updated_at < ? THEN 1 ELSE lockouts.failures + 1 END,
+	lockouts = CASE WHEN lockouts.updated_at < ? THEN 0 ELSE lockouts.lockouts END
+RETURNING id, failures, lockouts`
+
+// recordFailure counts a failed token request against each of principals, locking out those that reach their
+// threshold
+func recordFailure(principals []string, remoteAddr string) {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	for _, principal := range principals {
+		if err := recordPrincipalFailure(db, principal, remoteAddr); err != nil {
+			log.WithField("principal", principal).Errorf("unable to count failed token request; %s", err)
+		}
+	}
+}
+
+func recordPrincipalFailure(db *gorm.DB, principal, remoteAddr string) error {
+	now := time.Now()
+	// the row stays locked until the transaction ends, so no other failure is counted until the lockout is recorded
+	tx := db.Begin()
+	var (
+		id                 uint
+		failures, lockouts int
+	)
+	err := tx.Raw(countFailure, now, now, principal, now.Add(-failureMemory), now.Add(-maxLockoutWindow)).Row().Scan(&id, &failures, &lockouts)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if failures >= lockoutThreshold(principal) {
+		lockedUntil := now.Add(lockoutWindow(lockouts))
+		err = tx.Model(&Lockout{}).Where("id = ?", id).Updates(map[string]interface{}{"failures": 0, "lockouts": lockouts + 1, "locked_until": lockedUntil}).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		event := LockoutEvent{Principal: principal, Failures: failures, LockedUntil: lockedUntil, RemoteAddr: remoteAddr}
+		if err = tx.Create(&event).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit().Error; err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{"principal": principal, "locked_until": lockedUntil, "lockouts": lockouts + 1,
+			"remote_addr": remoteAddr}).Warn("locked out after repeated failed token requests")
+		return nil
+	}
+	return tx.Commit().Error
+}
+
+// clearFailures forgets the failures of a client that has presented its secret
+func clearFailures(clientID string) {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	if err := db.Unscoped().Where("principal = ?", lockoutClientPrefix+clientID).Delete(Lockout{}).Error; err != nil {
+		log.WithField("client_id", clientID).Errorf("unable to clear failed token requests; %s", err)
+	}
+}
+
+// Unlock ends the lockout of, and forgets the failures of, the client with clientID and the source address
+// address. Either may be empty. It fails only if neither has any failures recorded.
+func Unlock(clientID, address string) error {
+	var principals []string
+	if clientID != "" {
+		principals = append(principals, lockoutClientPrefix+clientID)
+	}
+	if address != "" {
+		principals = append(principals, lockoutAddressPrefix+address)
+	}
+	if len(principals) == 0 {
+		return errors.New("client ID or address required")
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var (
+		unlocked int
+		dbErr    error
+	)
+	for _, principal := range principals {
+		result := db.Unscoped().Where("principal = ?", principal).Delete(Lockout{})
+		err := result.Error
+		if err == nil && result.RowsAffected == 0 {
+			err = fmt.Errorf("no failed token requests recorded for %s", principal)
+		} else if err == nil {
+			unlocked++
+		} else if dbErr == nil {
+			dbErr = err
+		}
+		audit(cliActor, "unlock", principal, "", err)
+	}
+
+	if dbErr != nil {
+		return dbErr
+	}
+	if unlocked == 0 {
+		return fmt.Errorf("no failed token requests recorded for %s", strings.Join(principals, " or "))
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+	"github.com/CMSgov/bcda-app/bcda/utils"
+)
+
+// The ACOs, users, address allowlists and jobs that every API request looks up are answered from a cache of recent
+// lookups, so that a client polling a job does not cost a database round trip per check. Each answer is filed
+// under the ACO it is about. Whatever changes an ACO, its client or its users calls NotifyACOChanged, which sends a
+// NOTIFY on lookupChannel so that every instance forgets what it knows about the ACO; answers also expire after
+// BCDA_LOOKUP_CACHE_SECONDS, in case the database is changed some other way.
+//
+// These lookups are for authenticating requests. Code that changes an ACO reads it from the database.
+
+const (
+	lookupChannel   = "aco_changes"
+	lookupCacheSize = 10000
+)
+
+var lookups = newAnswerCache(lookupCacheSize, func() time.Duration {
+	return time.Duration(utils.GetEnvInt("BCDA_LOOKUP_CACHE_SECONDS", 300)) * time.Second
+})
+
+// NotifyACOChanged tells every listening instance, including this one, to forget what it knows about the ACO with
+// acoID. Call it after changing the ACO, its client or its users.
+func NotifyACOChanged(acoID string) {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	notifyACOChanged(db, acoID)
+}
+
+func notifyACOChanged(db *gorm.DB, acoID string) {
+	lookups.invalidate(acoID)
+	if err := db.Exec("SELECT pg_notify(?, ?)", lookupChannel, acoID).Error; err != nil {
+		log.Errorf("unable to notify listeners of change to ACO %q; %s", acoID, err)
+	}
+}
+
+// lookupACO returns the ACO with acoID
+func lookupACO(acoID string) (models.ACO, error) {
+	v, err := lookups.lookup("aco:"+acoID, func(db *gorm.DB) (interface{}, string, error) {
+		var aco models.ACO
+		if uuid.Parse(acoID) == nil || db.First(&aco, "uuid = ?", acoID).RecordNotFound() {
+			return nil, "", errors.New("no ACO record found for " + acoID)
+		}
+		return aco, aco.UUID.String(), nil
+	})
+	if err != nil {
+		return models.ACO{}, err
+	}
+	return v.(models.ACO), nil
+}
+
+// lookupACOByClientID returns the ACO whose client has clientID
+func lookupACOByClientID(clientID string) (models.ACO, error) {
+	v, err := lookups.lookup("client:"+clientID, func(db *gorm.DB) (interface{}, string, error) {
+		var aco models.ACO
+		if clientID == "" || db.First(&aco, "client_id = ?", clientID).RecordNotFound() {
+			return nil, "", errors.New("no ACO record found for " + clientID)
+		}
+		return aco, aco.UUID.String(), nil
+	})
+	if err != nil {
+		return models.ACO{}, err
+	}
+	return v.(models.ACO), nil
+}
+
+// lookupUser returns the user that requests authenticated as the ACO with acoID act as
+func lookupUser(acoID string) (models.User, error) {
+	v, err := lookups.lookup("user:"+acoID, func(db *gorm.DB) (interface{}, string, error) {
+		var user models.User
+		if db.Order("id").First(&user, "aco_id = ?", acoID).RecordNotFound() {
+			return nil, "", fmt.Errorf("no user for ACO with id of %v", acoID)
+		}
+		return user, acoID, nil
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return v.(models.User), nil
+}
+
+// lookupAllowedRanges returns the CIDR ranges the credentials of the ACO with acoID work from
+func lookupAllowedRanges(acoID string) ([]string, error) {
+	v, err := lookups.lookup("ranges:"+acoID, func(db *gorm.DB) (interface{}, string, error) {
+		var ranges []string
+		err := db.Model(&AllowedRange{}).Where("aco_id = ?", acoID).Order("id").Pluck("cidr", &ranges).Error
+		return ranges, acoID, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]string), nil
+}
+
+// jobOwner is what the auth middleware needs to know about a job. Neither changes once the job is created.
+type jobOwner struct {
+	ACOID      string
+	RequestURL string
+}
+
+// lookupJobOwner returns the ACO that made the job with jobID and the request it made
+func lookupJobOwner(jobID string) (jobOwner, error) {
+	if _, err := strconv.Atoi(jobID); err != nil {
+		return jobOwner{}, fmt.Errorf("invalid job ID %s", jobID)
+	}
+	v, err := lookups.lookup("job:"+jobID, func(db *gorm.DB) (interface{}, string, error) {
+		var job models.Job
+		if err := db.First(&job, "id = ?", jobID).Error; err != nil {
+			return nil, "", err
+		}
+		return jobOwner{ACOID: job.ACOID.String(), RequestURL: job.RequestURL}, job.ACOID.String(), nil
+	})
+	if err != nil {
+		return jobOwner{}, err
+	}
+	return v.(jobOwner), nil
+}
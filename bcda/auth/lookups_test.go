@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+)
+
+type LookupCacheTestSuite struct {
+	suite.Suite
+}
+
+func (s *LookupCacheTestSuite) TestNotifyACOChanged() {
+	models.InitializeGormModels()
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	cmsID := uuid.NewRandom().String()[0:4]
+	aco := models.ACO{UUID: uuid.NewRandom(), Name: "Lookup Test ACO", CMSID: &cmsID}
+	require.Nil(s.T(), db.Create(&aco).Error)
+	defer db.Unscoped().Delete(&aco)
+
+	found, err := lookupACO(aco.UUID.String())
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "", found.AuthProvider)
+
+	// the first lookup starts the listener, without which nothing is cached
+	listening := func() bool {
+		lookups.RLock()
+		defer lookups.RUnlock()
+		return lookups.listening
+	}
+	for i := 0; i < 50 && !listening(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.True(s.T(), listening())
+	_, err = lookupACO(aco.UUID.String())
+	require.Nil(s.T(), err)
+
+	require.Nil(s.T(), db.Model(&aco).Update("auth_provider", Okta).Error)
+	found, err = lookupACO(aco.UUID.String())
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "", found.AuthProvider, "a change that is not announced is not seen until the answer expires")
+
+	NotifyACOChanged(aco.UUID.String())
+	found, err = lookupACO(aco.UUID.String())
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), Okta, found.AuthProvider)
+
+	// what was not found is not cached
+	_, err = lookupACO("not-a-uuid")
+	assert.EqualError(s.T(), err, "no ACO record found for not-a-uuid")
+	_, err = lookupACOByClientID("")
+	assert.NotNil(s.T(), err)
+}
+
+func TestLookupCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(LookupCacheTestSuite))
+}
@@ -2,17 +2,17 @@ package auth
 
 import (
 	"context"
+	"crypto/x509"
+	"fmt"
 	"net/http"
 	"regexp"
-	"strconv"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-chi/chi"
 	log "github.com/sirupsen/logrus"
 
-	"github.com/CMSgov/bcda-app/bcda/database"
-	"github.com/CMSgov/bcda-app/bcda/models"
 	"github.com/CMSgov/bcda-app/bcda/responseutils"
+	"github.com/CMSgov/bcda-app/bcda/servicemux"
 )
 
 // Puts the decoded token and identity values into the request context. Decoded values have been
@@ -24,6 +24,19 @@ func ParseToken(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
+			// a client certificate registered to an ACO stands in for a token
+			if cert := servicemux.ClientCertificate(r); cert != nil {
+				ad, err := certificateAuthData(cert)
+				if err != nil {
+					log.Errorf("Unable to authenticate with client certificate; %s", err)
+					next.ServeHTTP(w, r)
+					return
+				}
+				ctx := context.WithValue(r.Context(), "certificate", cert)
+				ctx = context.WithValue(ctx, "ad", ad)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -37,7 +50,14 @@ func ParseToken(next http.Handler) http.Handler {
 		}
 
 		tokenString := authSubmatches[1]
-		token, err := GetProvider().DecodeJWT(tokenString)
+		_, provider, err := ProviderForToken(tokenString)
+		if err != nil {
+			log.Errorf("Unable to find the provider for Authorization header value; %s", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, err := provider.DecodeJWT(tokenString)
 		if err != nil {
 			log.Errorf("Unable to decode Authorization header value; %s", err)
 			next.ServeHTTP(w, r)
@@ -46,21 +66,18 @@ func ParseToken(next http.Handler) http.Handler {
 
 		var ad AuthData
 		if claims, ok := token.Claims.(*CommonClaims); ok && token.Valid {
-			// okta token
-			if claims.ClientID != "" && claims.Subject == claims.ClientID {
-				var aco, err = GetACOByClientID(claims.ClientID)
+			// okta token; other providers resolve the ACO when decoding
+			if claims.ClientID != "" && claims.Subject == claims.ClientID && claims.ACOID == "" {
+				var aco, err = lookupACOByClientID(claims.ClientID)
 				if err != nil {
 					log.Errorf("no aco for clientID %s because %v", claims.ClientID, err)
 					next.ServeHTTP(w, r)
 					return
 				}
 
-				db := database.GetGORMDbConnection()
-				defer database.Close(db)
-
-				var user models.User
-				if db.First(&user, "ACOID = ?", aco.UUID).RecordNotFound() {
-					log.Errorf("no user for ACO with id of %v", aco.UUID)
+				user, err := lookupUser(aco.UUID.String())
+				if err != nil {
+					log.Error(err)
 					next.ServeHTTP(w, r)
 					return
 				}
@@ -69,10 +86,11 @@ func ParseToken(next http.Handler) http.Handler {
 				ad.ACOID = aco.UUID.String()
 				ad.UserID = user.UUID.String()
 			} else {
-				ad.TokenID = claims.UUID
+				ad.TokenID = claims.Id
 				ad.ACOID = claims.ACOID
 				ad.UserID = claims.Subject
 			}
+			ad.Scopes = claims.Scopes
 		}
 		ctx := context.WithValue(r.Context(), "token", token)
 		ctx = context.WithValue(ctx, "ad", ad)
@@ -83,6 +101,22 @@ func ParseToken(next http.Handler) http.Handler {
 func RequireTokenAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token := r.Context().Value("token")
+		if cert, ok := r.Context().Value("certificate").(*x509.Certificate); ok && token == nil {
+			// ParseToken has found the ACO the certificate is registered to
+			ad, _ := r.Context().Value("ad").(AuthData)
+			// certificates are registered with us, as alpha credentials are
+			if err := checkEnrollment(ad.ACOID, Alpha); err != nil {
+				log.Error(err)
+				respond(w, http.StatusUnauthorized)
+				return
+			}
+			if err := checkAllowedAddress(ad.ACOID, clientIP(r)); err != nil {
+				refuseAddress(w, r, CertificateThumbprint(cert), ad.ACOID, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
 		if token == nil {
 			log.Error("No token found")
 			respond(w, http.StatusUnauthorized)
@@ -90,13 +124,41 @@ func RequireTokenAuth(next http.Handler) http.Handler {
 		}
 
 		if token, ok := token.(*jwt.Token); ok {
-			err := GetProvider().ValidateJWT(token.Raw)
+			name, provider, err := ProviderForToken(token.Raw)
+			if err != nil {
+				log.Error(err)
+				respond(w, http.StatusUnauthorized)
+				return
+			}
+
+			err = provider.ValidateJWT(token.Raw)
 			if err != nil {
 				log.Error(err)
 				respond(w, http.StatusUnauthorized)
 				return
 			}
 
+			if claims, ok := token.Claims.(*CommonClaims); ok {
+				if err = checkCertificateBinding(claims, servicemux.ClientCertificate(r)); err != nil {
+					log.Error(err)
+					respond(w, http.StatusUnauthorized)
+					return
+				}
+			}
+
+			ad, _ := r.Context().Value("ad").(AuthData)
+			err = checkEnrollment(ad.ACOID, name)
+			if err != nil {
+				log.Error(err)
+				respond(w, http.StatusUnauthorized)
+				return
+			}
+
+			if err = checkAllowedAddress(ad.ACOID, clientIP(r)); err != nil {
+				refuseAddress(w, r, ad.TokenID, ad.ACOID, err)
+				return
+			}
+
 			next.ServeHTTP(w, r)
 		}
 	})
@@ -113,29 +175,77 @@ func RequireTokenJobMatch(next http.Handler) http.Handler {
 		}
 
 		jobID := chi.URLParam(r, "jobID")
-		i, err := strconv.Atoi(jobID)
+		job, err := lookupJobOwner(jobID)
+		if err == nil && job.ACOID != ad.ACOID {
+			err = fmt.Errorf("job %s does not belong to ACO %s", jobID, ad.ACOID)
+		}
 		if err != nil {
 			log.Error(err)
 			oo := responseutils.CreateOpOutcome(responseutils.Error, responseutils.Exception, "", responseutils.Not_found)
 			responseutils.WriteError(oo, w, http.StatusNotFound)
 			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope lets through only requests whose token may read resources of resourceType
+func RequireScope(resourceType string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ad, ok := r.Context().Value("ad").(AuthData)
+			if !ok || !ad.CanRead(resourceType) {
+				log.Errorf("token %s does not grant read access to %s", ad.TokenID, resourceType)
+				oo := responseutils.CreateOpOutcome(responseutils.Error, responseutils.Forbidden, "", responseutils.ScopeErr)
+				responseutils.WriteError(oo, w, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-		db := database.GetGORMDbConnection()
-		defer database.Close(db)
+// RequireJobScope lets through only requests whose token may read the resource type exported by the job named in
+// the URL. It expects RequireTokenJobMatch to have already checked that the job belongs to the token's ACO.
+func RequireJobScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ad, ok := r.Context().Value("ad").(AuthData)
+		if !ok {
+			log.Error("no auth data in context")
+			oo := responseutils.CreateOpOutcome(responseutils.Error, responseutils.Exception, "", responseutils.Not_found)
+			responseutils.WriteError(oo, w, http.StatusNotFound)
+			return
+		}
 
-		var job models.Job
-		err = db.Find(&job, "id = ? and aco_id = ?", i, ad.ACOID).Error
+		jobID := chi.URLParam(r, "jobID")
+		job, err := lookupJobOwner(jobID)
+		if err == nil && job.ACOID != ad.ACOID {
+			err = fmt.Errorf("job %s does not belong to ACO %s", jobID, ad.ACOID)
+		}
 		if err != nil {
 			log.Error(err)
 			oo := responseutils.CreateOpOutcome(responseutils.Error, responseutils.Exception, "", responseutils.Not_found)
 			responseutils.WriteError(oo, w, http.StatusNotFound)
 			return
 		}
+
+		// a job whose resource type we can't tell may only be read by tokens that may read everything
+		resourceType := "*"
+		if m := exportResourceType.FindStringSubmatch(job.RequestURL); m != nil {
+			resourceType = m[1]
+		}
+		if !ad.CanRead(resourceType) {
+			log.Errorf("token %s does not grant read access to the files of job %s", ad.TokenID, jobID)
+			oo := responseutils.CreateOpOutcome(responseutils.Error, responseutils.Forbidden, "", responseutils.ScopeErr)
+			responseutils.WriteError(oo, w, http.StatusForbidden)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+var exportResourceType = regexp.MustCompile(`/(ExplanationOfBenefit|Patient|Coverage)/\$export`)
+
 func respond(w http.ResponseWriter, status int) {
 	oo := responseutils.CreateOpOutcome(responseutils.Error, responseutils.Exception, "", responseutils.TokenErr)
 	responseutils.WriteError(oo, w, status)
@@ -278,6 +278,63 @@ func (s *MiddlewareTestSuite) TestRequireTokenACOMatchInvalidToken() {
 	assert.Equal(s.T(), http.StatusNotFound, s.rr.Code)
 }
 
+func (s *MiddlewareTestSuite) TestRequireScope() {
+	handler := auth.RequireScope("Coverage")(mockHandler)
+
+	for _, test := range []struct {
+		scopes []string
+		status int
+	}{
+		{nil, http.StatusOK},
+		{[]string{"bcda_api"}, http.StatusOK},
+		{[]string{"system/*.read"}, http.StatusOK},
+		{[]string{"system/Patient.read", "system/Coverage.read"}, http.StatusOK},
+		{[]string{"system/ExplanationOfBenefit.read"}, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", "/api/v1/Coverage/$export", nil)
+		ad := auth.AuthData{ACOID: s.ad.ACOID, TokenID: s.ad.TokenID, Scopes: test.scopes}
+		req = req.WithContext(context.WithValue(req.Context(), "ad", ad))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(s.T(), test.status, rr.Code, "scopes %v", test.scopes)
+	}
+}
+
+func (s *MiddlewareTestSuite) TestRequireJobScope() {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	j := models.Job{
+		ACOID:      uuid.Parse(s.ad.ACOID),
+		UserID:     uuid.Parse(s.ad.UserID),
+		RequestURL: "/api/v1/Patient/$export",
+		Status:     "Completed",
+	}
+	db.Save(&j)
+	defer db.Delete(&j)
+
+	handler := auth.RequireJobScope(mockHandler)
+
+	for _, test := range []struct {
+		scopes []string
+		status int
+	}{
+		{nil, http.StatusOK},
+		{[]string{"system/Patient.read"}, http.StatusOK},
+		{[]string{"system/Coverage.read"}, http.StatusForbidden},
+	} {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("jobID", strconv.Itoa(int(j.ID)))
+		req := httptest.NewRequest("GET", "/data/1/file.ndjson", nil)
+		ad := auth.AuthData{ACOID: s.ad.ACOID, TokenID: s.ad.TokenID, Scopes: test.scopes}
+		ctx := context.WithValue(req.Context(), "ad", ad)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(s.T(), test.status, rr.Code, "scopes %v", test.scopes)
+	}
+}
+
 func TestMiddlewareTestSuite(t *testing.T) {
 	suite.Run(t, new(MiddlewareTestSuite))
 }
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"errors"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
@@ -19,6 +20,18 @@ func InitializeGormModels() *gorm.DB {
 	// Add your new models here
 	db.AutoMigrate(
 		&Token{},
+		&RevokedToken{},
+		&RevokedClient{},
+		&ClientKeys{},
+		&UsedAssertion{},
+		&ClientScope{},
+		&AdminClient{},
+		&AdminAuditEvent{},
+		&ResourceServer{},
+		&Lockout{},
+		&LockoutEvent{},
+		&ClientCertificate{},
+		&AllowedRange{},
 	)
 
 	// force manual deletion of foreign key and this related record (you can delete a Token, but not an aco with a token
@@ -30,19 +43,130 @@ func InitializeGormModels() *gorm.DB {
 type Token struct {
 	gorm.Model
 	// even though gorm.Model has an `id` field declared as the primary key, the following definition overrides that
-	UUID        uuid.UUID  `gorm:"primary_key" json:"uuid"`                      // uuid (primary key)
-	Value       string     `gorm:"type:varchar(511); unique" json:"value"`       // Deprecated: When can we drop Value without hurting existing alpha tokens?
-	Active      bool       `json:"active"`                                       // active
-	ACO         models.ACO `gorm:"foreignkey:ACOID;association_foreignkey:UUID"` // ACO needed here because user can belong to multiple ACOs
-	ACOID       uuid.UUID  `gorm:"type:uuid" json:"aco_id"`
-	IssuedAt    int64      `json:"issued_at"`  // standard token claim; unix date
-	ExpiresOn   int64      `json:"expires_on"` // standard token claim; unix date
-	TokenString string     `gorm:"-"`          // ignore; not for database
+	UUID             uuid.UUID  `gorm:"primary_key" json:"uuid"`                      // uuid (primary key)
+	Value            string     `gorm:"type:varchar(511); unique" json:"value"`       // Deprecated: When can we drop Value without hurting existing alpha tokens?
+	Active           bool       `json:"active"`                                       // active
+	ACO              models.ACO `gorm:"foreignkey:ACOID;association_foreignkey:UUID"` // ACO needed here because user can belong to multiple ACOs
+	ACOID            uuid.UUID  `gorm:"type:uuid" json:"aco_id"`
+	IssuedAt         int64      `json:"issued_at"`  // standard token claim; unix date
+	ExpiresOn        int64      `json:"expires_on"` // standard token claim; unix date
+	RevokedAt        *time.Time `json:"revoked_at"`
+	RevocationReason string     `json:"revocation_reason"`
+	Scope            string     `json:"scope"` // space delimited scopes granted to a SMART Backend Services client; empty for all
+	// thumbprint of the client certificate the token is bound to; empty when it is not bound
+	CertificateThumbprint string `json:"certificate_thumbprint"`
+	TokenString           string `gorm:"-"` // ignore; not for database
+}
+
+// RevokedToken records the revocation of a token we did not issue, and so cannot keep in tokens, by its jti claim
+type RevokedToken struct {
+	gorm.Model
+	JTI       string    `gorm:"unique_index" json:"jti"`
+	ClientID  string    `json:"client_id"`
+	ExpiresOn int64     `json:"expires_on"` // the token's exp claim; the record is of no use after this
+	RevokedAt time.Time `json:"revoked_at"`
+	Reason    string    `json:"reason"`
+}
+
+// RevokedClient records the revocation of a client's credentials. Tokens issued to the client at or before
+// RevokedAt are rejected.
+type RevokedClient struct {
+	gorm.Model
+	ClientID  string    `gorm:"unique_index" json:"client_id"`
+	RevokedAt time.Time `json:"revoked_at"`
+	Reason    string    `json:"reason"`
+}
+
+// ClientKeys holds the JSON Web Key Set a SMART Backend Services client signs its token requests with
+type ClientKeys struct {
+	gorm.Model
+	ClientID string    `gorm:"unique_index" json:"client_id"`
+	ACOID    uuid.UUID `gorm:"type:uuid" json:"aco_id"`
+	JWKS     string    `gorm:"type:text" json:"jwks"`
+}
+
+// UsedAssertion records the jti of a client assertion, so that the assertion cannot be presented again before it
+// expires
+type UsedAssertion struct {
+	gorm.Model
+	ClientID  string `gorm:"unique_index:idx_used_assertion_client_jti" json:"client_id"`
+	JTI       string `gorm:"unique_index:idx_used_assertion_client_jti" json:"jti"`
+	ExpiresOn int64  `json:"expires_on"` // the assertion's exp claim; the record is of no use after this
+}
+
+// ClientScope holds the space delimited scopes the tokens issued to a client are limited to
+type ClientScope struct {
+	gorm.Model
+	ClientID string `gorm:"unique_index" json:"client_id"`
+	Scope    string `json:"scope"`
+}
+
+// AdminClient is a credential for the administrative API
+type AdminClient struct {
+	gorm.Model
+	ClientID   string `gorm:"unique_index" json:"client_id"`
+	Name       string `json:"name"`
+	SecretHash string `json:"-"`
+	Active     bool   `json:"active"`
+}
+
+// ResourceServer is a credential for a service that introspects the tokens ACOs present to it
+type ResourceServer struct {
+	gorm.Model
+	ClientID   string `gorm:"unique_index" json:"client_id"`
+	Name       string `json:"name"`
+	SecretHash string `json:"-"`
+	Active     bool   `json:"active"`
+}
+
+// Lockout counts the failed token requests of a client ID or source address
+type Lockout struct {
+	gorm.Model
+	Principal   string     `gorm:"unique_index" json:"principal"` // client:<client id> or ip:<address>
+	Failures    int        `json:"failures"`                      // since the last lockout
+	Lockouts    int        `json:"lockouts"`                      // recent lockouts, which lengthen the next
+	LockedUntil *time.Time `json:"locked_until"`
+}
+
+// LockoutEvent records that a client ID or source address was locked out
+type LockoutEvent struct {
+	gorm.Model
+	Principal   string    `json:"principal"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	RemoteAddr  string    `json:"remote_addr"`
+}
+
+// ClientCertificate maps a TLS client certificate to the ACO whose requests it authenticates
+type ClientCertificate struct {
+	gorm.Model
+	Thumbprint string    `gorm:"unique_index" json:"thumbprint"` // base64url SHA-256 of the DER certificate, as in x5t#S256
+	ACOID      uuid.UUID `gorm:"type:uuid" json:"aco_id"`
+	Subject    string    `json:"subject"`
+	NotAfter   time.Time `json:"not_after"`
+	Active     bool      `json:"active"`
+}
+
+// AllowedRange is a CIDR range of addresses an ACO's credentials work from. An ACO with no ranges is not limited.
+type AllowedRange struct {
+	gorm.Model
+	ACOID uuid.UUID `gorm:"type:uuid;index" json:"aco_id"`
+	CIDR  string    `json:"cidr"`
+}
+
+// AdminAuditEvent records a change made through the administrative API or CLI
+type AdminAuditEvent struct {
+	gorm.Model
+	Actor      string `json:"actor"` // the admin client ID, or cli
+	Action     string `json:"action"`
+	Target     string `json:"target"`
+	Outcome    string `json:"outcome"` // success, or the error that prevented the change
+	RemoteAddr string `json:"remote_addr"`
 }
 
 // When getting a Token out of the database, reconstruct its string value and store it in TokenString.
 func (t *Token) AfterFind() error {
-	s, err := GenerateTokenString(t.UUID.String(), t.ACOID.String(), t.IssuedAt, t.ExpiresOn)
+	s, err := generateBoundTokenString(t.UUID.String(), t.ACOID.String(), t.Scope, t.CertificateThumbprint, t.IssuedAt, t.ExpiresOn)
 	if err == nil {
 		t.TokenString = s
 		return nil
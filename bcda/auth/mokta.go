@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -19,6 +20,14 @@ type Mokta struct {
 	privateKey  *rsa.PrivateKey
 	publicKeyID string
 	serverID    string
+	// clients added by AddClientApplication, by client id
+	clients map[string]moktaClient
+	// clients deactivated by DeactivateApplication, whose tokens introspect as inactive
+	deactivated map[string]bool
+	// when false, IntrospectToken behaves as an unconfigured OktaClient does
+	introspection bool
+	// number of IntrospectToken calls, so tests can tell whether answers were cached
+	introspections int
 }
 
 func NewMokta() *Mokta {
@@ -34,7 +43,12 @@ func NewMokta() *Mokta {
 	keys := make(map[string]rsa.PublicKey)
 	keys["mokta"] = publicKey
 
-	return &Mokta{publicKey, privateKey, "mokta", "mokta.fake.backend"}
+	return &Mokta{publicKey, privateKey, "mokta", "mokta.fake.backend", make(map[string]moktaClient), make(map[string]bool), true, 0}
+}
+
+type moktaClient struct {
+	secret   string
+	metadata map[string]interface{}
 }
 
 func (m *Mokta) PublicKeyFor(id string) (rsa.PublicKey, bool) {
@@ -61,9 +75,42 @@ func (m *Mokta) AddClientApplication(localId string) (clientID string, clientSec
 	clientID = base64.URLEncoding.EncodeToString(id)
 	clientSecret = base64.URLEncoding.EncodeToString(key)
 	clientName = fmt.Sprintf("BCDA %s", clientID)
+	m.clients[clientID] = moktaClient{clientSecret, map[string]interface{}{
+		"client_id":   clientID,
+		"client_name": clientName,
+	}}
 	return
 }
 
+func (m *Mokta) UpdateClientApplication(clientID string, update client.ClientUpdate) (map[string]interface{}, error) {
+	c, ok := m.clients[clientID]
+	if !ok {
+		return nil, errors.New("404 Not Found")
+	}
+
+	changes, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(changes, &c.metadata); err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{"client_secret": c.secret}
+	for k, v := range c.metadata {
+		result[k] = v
+	}
+	return result, nil
+}
+
+func (m *Mokta) RemoveClientApplication(clientID string) error {
+	if _, ok := m.clients[clientID]; !ok {
+		return errors.New("404 Not Found")
+	}
+	delete(m.clients, clientID)
+	return nil
+}
+
 func (m *Mokta) RequestAccessToken(creds client.Credentials) (client.OktaToken, error) {
 	if creds.ClientID == "" {
 		return client.OktaToken{}, fmt.Errorf("client ID required")
@@ -73,6 +120,11 @@ func (m *Mokta) RequestAccessToken(creds client.Credentials) (client.OktaToken,
 		return client.OktaToken{}, fmt.Errorf("client secret required")
 	}
 
+	// clients Mokta doesn't know about are accepted with any secret
+	if c, ok := m.clients[creds.ClientID]; m.deactivated[creds.ClientID] || (ok && c.secret != creds.ClientSecret) {
+		return client.OktaToken{}, errors.New("401 Unauthorized")
+	}
+
 	mt, err := m.NewToken(creds.ClientID)
 
 	if err != nil {
@@ -89,6 +141,16 @@ func (m *Mokta) RequestAccessToken(creds client.Credentials) (client.OktaToken,
 }
 
 func (m *Mokta) GenerateNewClientSecret(clientID string) (string, error) {
+	if c, ok := m.clients[clientID]; ok {
+		key, err := someRandomBytes(32)
+		if err != nil {
+			return "", err
+		}
+		c.secret = base64.URLEncoding.EncodeToString(key)
+		m.clients[clientID] = c
+		return c.secret, nil
+	}
+
 	if len(clientID) != 20 {
 		return "", errors.New("404 Not Found")
 	}
@@ -98,9 +160,26 @@ func (m *Mokta) GenerateNewClientSecret(clientID string) (string, error) {
 }
 
 func (m *Mokta) DeactivateApplication(clientID string) error {
+	m.deactivated[clientID] = true
 	return nil
 }
 
+func (m *Mokta) IntrospectToken(tokenString string) (bool, error) {
+	if !m.introspection {
+		return false, client.ErrIntrospectionDisabled
+	}
+	m.introspections++
+
+	var claims CommonClaims
+	t, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		return &m.publicKey, nil
+	})
+	if err != nil || !t.Valid {
+		return false, nil
+	}
+	return !m.deactivated[claims.ClientID], nil
+}
+
 func randomClientID() string {
 	b, err := someRandomBytes(4)
 	if err != nil {
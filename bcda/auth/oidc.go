@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/CMSgov/bcda-app/bcda/auth/client"
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+	"github.com/CMSgov/bcda-app/bcda/utils"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pborman/uuid"
+)
+
+// OIDCBackend is a standards-based authorization server, such as Keycloak, identified by its issuer URL
+type OIDCBackend interface {
+	// Returns the issuer identifier of the authorization server
+	Issuer() string
+
+	// Returns the server's public signing key with the given key id
+	PublicKeyFor(id string) (rsa.PublicKey, bool)
+
+	// Registers a client by dynamic client registration; returns client.ErrRegistrationUnsupported if the
+	// server does not allow it
+	RegisterClient(clientName string) (clientID string, clientSecret string, err error)
+
+	// Gets an access token with the client credentials grant
+	RequestAccessToken(creds client.Credentials) (client.OktaToken, error)
+}
+
+// How the value of the ACO claim identifies an ACO
+const (
+	OIDCLookupClientID = "client_id"
+	OIDCLookupUUID     = "uuid"
+	OIDCLookupCMSID    = "cms_id"
+)
+
+// OIDCAuthPlugin accepts access tokens issued by any OpenID Connect provider. The ACO a token is for is named by
+// the claim in OIDC_ACO_CLAIM (default client_id), whose value is matched against the ACO field named by
+// OIDC_ACO_LOOKUP: client_id (the default), uuid, or cms_id. If OIDC_AUDIENCE is set, tokens must be issued
+// for it.
+type OIDCAuthPlugin struct {
+	backend  OIDCBackend
+	acoClaim string
+	lookup   string
+	audience string
+}
+
+// Create a new plugin using the provided backend. Having the backend passed in facilitates testing with a fake
+// issuer.
+func NewOIDCAuthPlugin(backend OIDCBackend) OIDCAuthPlugin {
+	return OIDCAuthPlugin{
+		backend:  backend,
+		acoClaim: utils.FromEnv("OIDC_ACO_CLAIM", "client_id"),
+		lookup:   utils.FromEnv("OIDC_ACO_LOOKUP", OIDCLookupClientID),
+		audience: os.Getenv("OIDC_AUDIENCE"),
+	}
+}
+
+func (o OIDCAuthPlugin) RegisterClient(localID string) (Credentials, error) {
+	if localID == "" {
+		return Credentials{}, errors.New("you must provide a localID")
+	}
+
+	name := fmt.Sprintf("BCDA %s", localID)
+	id, secret, err := o.backend.RegisterClient(name)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	return Credentials{ClientID: id, ClientSecret: secret, ClientName: name}, nil
+}
+
+func (o OIDCAuthPlugin) UpdateClient(params []byte) ([]byte, error) {
+	return nil, errors.New("not yet implemented")
+}
+
+func (o OIDCAuthPlugin) DeleteClient(clientID string) error {
+	return errors.New("not yet implemented")
+}
+
+func (o OIDCAuthPlugin) GenerateClientCredentials(clientID string, ttl int) (Credentials, error) {
+	return Credentials{}, errors.New("GenerateClientCredentials is not implemented for oidc auth")
+}
+
+// RevokeClientCredentials rejects the tokens already issued to the client. The client must also be disabled in
+// the issuer, which we have no standard way to do.
+func (o OIDCAuthPlugin) RevokeClientCredentials(clientID string) error {
+	if clientID == "" {
+		return errors.New("client ID required")
+	}
+	return revokeClient(clientID, "client credentials revoked")
+}
+
+// Manufactures an access token for the given credentials
+func (o OIDCAuthPlugin) MakeAccessToken(credentials Credentials) (string, error) {
+	if credentials.ClientID == "" || credentials.ClientSecret == "" {
+		return "", fmt.Errorf("missing or incomplete credentials")
+	}
+
+	ot, err := o.backend.RequestAccessToken(client.Credentials{ClientID: credentials.ClientID, ClientSecret: credentials.ClientSecret})
+	if err != nil {
+		return "", fmt.Errorf("invalid credentials; %s", err)
+	}
+
+	return ot.AccessToken, nil
+}
+
+func (o OIDCAuthPlugin) RequestAccessToken(creds Credentials, ttl int) (Token, error) {
+	ts, err := o.MakeAccessToken(creds)
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{TokenString: ts}, nil
+}
+
+func (o OIDCAuthPlugin) RevokeAccessToken(tokenString string) error {
+	return o.RevokeAccessTokenWithReason(tokenString, RevocationReasonUnspecified)
+}
+
+// RevokeAccessTokenWithReason revokes the token in tokenString, recording why it was revoked. The issuer will
+// still consider the token active; only our own checks in ValidateJWT will reject it.
+func (o OIDCAuthPlugin) RevokeAccessTokenWithReason(tokenString, reason string) error {
+	t, err := o.DecodeJWT(tokenString)
+	if err != nil {
+		return err
+	}
+
+	c := t.Claims.(*CommonClaims)
+	return revokeTokenID(c.Id, c.ClientID, c.ExpiresAt, reason)
+}
+
+func (o OIDCAuthPlugin) ValidateJWT(tokenString string) error {
+	t, err := o.DecodeJWT(tokenString)
+	if err != nil {
+		return err
+	}
+
+	c := t.Claims.(*CommonClaims)
+
+	if c.Issuer != o.backend.Issuer() {
+		return fmt.Errorf("invalid iss claim; %s <> %s", c.Issuer, o.backend.Issuer())
+	}
+
+	if o.audience != "" {
+		raw := jwt.MapClaims{}
+		if _, _, err = new(jwt.Parser).ParseUnverified(tokenString, raw); err != nil || !hasAudience(raw, o.audience) {
+			return fmt.Errorf("invalid aud claim; %s not found", o.audience)
+		}
+	}
+
+	if err = c.Valid(); err != nil {
+		return err
+	}
+
+	if c.ExpiresAt == 0 || c.Id == "" {
+		return fmt.Errorf("missing one or more required claims")
+	}
+
+	revoked, err := isTokenIDRevoked(c.Id)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return fmt.Errorf("token %s has been revoked", c.Id)
+	}
+
+	if c.ClientID != "" {
+		revokedAt, err := clientRevokedAt(c.ClientID)
+		if err != nil {
+			return err
+		}
+		if revokedAt != 0 && c.IssuedAt <= revokedAt {
+			return fmt.Errorf("credentials for client %s have been revoked", c.ClientID)
+		}
+	}
+
+	return nil
+}
+
+// DecodeJWT decodes and verifies a token from the issuer. Since the ACO is named by a configurable claim, the
+// ACO is resolved here and its UUID put in the aco claim, and the jti copied to the id claim, so that ParseToken
+// can treat the token as it does our own. The client, if the token names one, is put in the cid claim.
+func (o OIDCAuthPlugin) DecodeJWT(tokenString string) (*jwt.Token, error) {
+	keyFinder := func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		keyID, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("no key id in token header? %v", token.Header)
+		}
+
+		key, ok := o.backend.PublicKeyFor(keyID)
+		if !ok {
+			return nil, fmt.Errorf("no key found with id %s", keyID)
+		}
+
+		return &key, nil
+	}
+
+	// the names of some claims are configurable, and aud may be an array, so the claims can't be parsed directly
+	// into CommonClaims
+	raw := jwt.MapClaims{}
+	t, err := jwt.ParseWithClaims(tokenString, raw, keyFinder)
+	if err != nil {
+		return t, err
+	}
+
+	c, err := o.commonClaims(raw)
+	t.Claims = c
+	return t, err
+}
+
+func (o OIDCAuthPlugin) issued(header map[string]interface{}, claims jwt.MapClaims) bool {
+	iss, _ := claims["iss"].(string)
+	return iss != "" && iss == o.backend.Issuer()
+}
+
+func (o OIDCAuthPlugin) commonClaims(raw jwt.MapClaims) (*CommonClaims, error) {
+	c := &CommonClaims{}
+
+	standard := jwt.MapClaims{}
+	for k, v := range raw {
+		standard[k] = v
+	}
+	if _, ok := standard["aud"].(string); !ok {
+		delete(standard, "aud")
+	}
+	b, err := json.Marshal(standard)
+	if err != nil {
+		return c, err
+	}
+	if err = json.Unmarshal(b, c); err != nil {
+		return c, err
+	}
+
+	if cid, ok := raw["client_id"].(string); ok && c.ClientID == "" {
+		c.ClientID = cid
+	}
+	// RFC 9068 access tokens name their scopes in a space delimited scope claim
+	if scope, ok := raw["scope"].(string); ok && len(c.Scopes) == 0 {
+		c.Scopes = strings.Fields(scope)
+	}
+	value, ok := raw[o.acoClaim].(string)
+	if !ok || value == "" {
+		return c, fmt.Errorf("missing %s claim", o.acoClaim)
+	}
+	aco, err := o.findACO(value)
+	if err != nil {
+		return c, fmt.Errorf("invalid %s claim; %s", o.acoClaim, err)
+	}
+	c.ACOID = aco.UUID.String()
+	c.UUID = c.Id
+
+	return c, nil
+}
+
+// hasAudience reports whether the aud claim, a string or an array of strings, includes audience
+func hasAudience(raw jwt.MapClaims, audience string) bool {
+	switch aud := raw["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (o OIDCAuthPlugin) findACO(value string) (models.ACO, error) {
+	var query string
+	switch o.lookup {
+	case OIDCLookupClientID:
+		query = "client_id = ?"
+	case OIDCLookupUUID:
+		if uuid.Parse(value) == nil {
+			return models.ACO{}, fmt.Errorf("%s is not a UUID", value)
+		}
+		query = "uuid = ?"
+	case OIDCLookupCMSID:
+		query = "cms_id = ?"
+	default:
+		return models.ACO{}, fmt.Errorf("unknown OIDC_ACO_LOOKUP %s", o.lookup)
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var aco models.ACO
+	if db.First(&aco, query, value).RecordNotFound() {
+		return aco, fmt.Errorf("no ACO record found for %s", value)
+	}
+	return aco, nil
+}
@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CMSgov/bcda-app/bcda/auth/client"
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeIssuer is an in-process OpenID provider, with just enough of discovery, JWKS, the token endpoint and
+// dynamic client registration to stand in for Keycloak and the like
+type fakeIssuer struct {
+	server       *httptest.Server
+	key          *rsa.PrivateKey
+	keyID        string
+	clients      map[string]string
+	registration bool
+}
+
+func newFakeIssuer() *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	f := &fakeIssuer{key: key, keyID: "fake-1", clients: make(map[string]string), registration: true}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		md := map[string]string{
+			"issuer":         f.server.URL,
+			"jwks_uri":       f.server.URL + "/certs",
+			"token_endpoint": f.server.URL + "/token",
+		}
+		if f.registration {
+			md["registration_endpoint"] = f.server.URL + "/register"
+		}
+		_ = json.NewEncoder(w).Encode(md)
+	})
+	mux.HandleFunc("/certs", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(client.KeyList{Keys: []*client.RsaJWK{
+			{KeyType: "RSA", Algorithm: "RS256", ID: f.keyID, Use: "sig",
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+				N: base64.RawURLEncoding.EncodeToString(f.key.N.Bytes())},
+			// issuers publish encryption keys too
+			{KeyType: "RSA", Algorithm: "RSA-OAEP", ID: "fake-enc", Use: "enc", E: "AQAB", N: "AQAB"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || r.FormValue("grant_type") != "client_credentials" || f.clients[id] == "" || f.clients[id] != secret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ts, _ := f.token(jwt.MapClaims{"client_id": id})
+		_ = json.NewEncoder(w).Encode(client.OktaToken{AccessToken: ts, TokenType: "Bearer", ExpiresIn: 300})
+	})
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		var md map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&md); err != nil || md["client_name"] == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		id, secret := uuid.NewRandom().String(), uuid.NewRandom().String()
+		f.clients[id] = secret
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"client_id": id, "client_secret": secret, "client_name": md["client_name"]})
+	})
+	f.server = httptest.NewServer(mux)
+	return f
+}
+
+// token signs a token with the usual claims, as overridden by claims
+func (f *fakeIssuer) token(claims jwt.MapClaims) (string, error) {
+	values := jwt.MapClaims{
+		"iss": f.server.URL,
+		"sub": "service-account",
+		"aud": []string{"bcda", "account"},
+		"jti": uuid.NewRandom().String(),
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(values, k)
+		} else {
+			values[k] = v
+		}
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, values)
+	t.Header["kid"] = f.keyID
+	return t.SignedString(f.key)
+}
+
+type OIDCAuthPluginTestSuite struct {
+	suite.Suite
+	issuer  *fakeIssuer
+	o       OIDCAuthPlugin
+	db      *gorm.DB
+	acoUUID uuid.UUID
+	cmsID   string
+}
+
+func (s *OIDCAuthPluginTestSuite) SetupSuite() {
+	models.InitializeGormModels()
+	InitializeGormModels()
+}
+
+func (s *OIDCAuthPluginTestSuite) SetupTest() {
+	s.issuer = newFakeIssuer()
+	s.o = NewOIDCAuthPlugin(client.NewOIDCClient(s.issuer.server.URL, ""))
+	s.db = database.GetGORMDbConnection()
+
+	var err error
+	s.cmsID = uuid.NewRandom().String()[0:4]
+	s.acoUUID, err = models.CreateACO("OIDC Test ACO", &s.cmsID)
+	require.Nil(s.T(), err)
+}
+
+func (s *OIDCAuthPluginTestSuite) TearDownTest() {
+	s.db.Unscoped().Delete(&models.ACO{}, "uuid = ?", s.acoUUID)
+	database.Close(s.db)
+	s.issuer.server.Close()
+}
+
+// registers a client for the test ACO
+func (s *OIDCAuthPluginTestSuite) register() Credentials {
+	creds, err := s.o.RegisterClient(s.acoUUID.String())
+	require.Nil(s.T(), err)
+	require.Nil(s.T(), s.db.Model(&models.ACO{}).Where("uuid = ?", s.acoUUID).Update("client_id", creds.ClientID).Error)
+	NotifyACOChanged(s.acoUUID.String())
+	return creds
+}
+
+func (s *OIDCAuthPluginTestSuite) TestRegisterClientAndMakeAccessToken() {
+	_, err := s.o.RegisterClient("")
+	assert.NotNil(s.T(), err)
+
+	creds := s.register()
+	assert.NotEmpty(s.T(), creds.ClientID)
+	assert.NotEmpty(s.T(), creds.ClientSecret)
+	assert.Equal(s.T(), "BCDA "+s.acoUUID.String(), creds.ClientName)
+
+	_, err = s.o.MakeAccessToken(Credentials{ClientID: creds.ClientID, ClientSecret: "not_the_right_secret"})
+	assert.Contains(s.T(), err.Error(), "invalid credentials")
+
+	ts, err := s.o.MakeAccessToken(creds)
+	require.Nil(s.T(), err)
+	require.Nil(s.T(), s.o.ValidateJWT(ts))
+
+	t, err := s.o.DecodeJWT(ts)
+	require.Nil(s.T(), err)
+	c := t.Claims.(*CommonClaims)
+	assert.Equal(s.T(), s.acoUUID.String(), c.ACOID)
+	assert.Equal(s.T(), creds.ClientID, c.ClientID)
+	assert.Equal(s.T(), c.Id, c.UUID)
+}
+
+func (s *OIDCAuthPluginTestSuite) TestRegistrationUnsupported() {
+	s.issuer.registration = false
+	_, err := s.o.RegisterClient(s.acoUUID.String())
+	assert.Equal(s.T(), client.ErrRegistrationUnsupported, err)
+}
+
+func (s *OIDCAuthPluginTestSuite) TestValidateJWT() {
+	creds := s.register()
+	valid := jwt.MapClaims{"client_id": creds.ClientID}
+
+	ts, err := s.issuer.token(valid)
+	require.Nil(s.T(), err)
+	assert.Nil(s.T(), s.o.ValidateJWT(ts))
+
+	ts, _ = s.issuer.token(jwt.MapClaims{"client_id": creds.ClientID, "iss": "https://not.our.issuer"})
+	err = s.o.ValidateJWT(ts)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "invalid iss")
+
+	ts, _ = s.issuer.token(jwt.MapClaims{"client_id": creds.ClientID, "exp": time.Now().Add(-time.Minute).Unix()})
+	err = s.o.ValidateJWT(ts)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "expired")
+
+	ts, _ = s.issuer.token(jwt.MapClaims{"client_id": creds.ClientID, "jti": nil})
+	err = s.o.ValidateJWT(ts)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "missing one or more required claims")
+
+	ts, _ = s.issuer.token(jwt.MapClaims{"client_id": "not_a_client"})
+	err = s.o.ValidateJWT(ts)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "invalid client_id claim")
+
+	ts, _ = s.issuer.token(jwt.MapClaims{})
+	err = s.o.ValidateJWT(ts)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "missing client_id claim")
+
+	// signed with a key the issuer does not publish
+	other, _ := rsa.GenerateKey(rand.Reader, 1024)
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": s.issuer.server.URL, "client_id": creds.ClientID})
+	t.Header["kid"] = "unknown"
+	ts, _ = t.SignedString(other)
+	err = s.o.ValidateJWT(ts)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "no key found")
+
+	s.o.audience = "bcda"
+	ts, _ = s.issuer.token(valid)
+	assert.Nil(s.T(), s.o.ValidateJWT(ts))
+	ts, _ = s.issuer.token(jwt.MapClaims{"client_id": creds.ClientID, "aud": "bcda"})
+	assert.Nil(s.T(), s.o.ValidateJWT(ts))
+	s.o.audience = "someone-else"
+	err = s.o.ValidateJWT(ts)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "invalid aud")
+}
+
+func (s *OIDCAuthPluginTestSuite) TestACOClaimMapping() {
+	s.o.acoClaim = "aco_id"
+	s.o.lookup = OIDCLookupUUID
+	ts, _ := s.issuer.token(jwt.MapClaims{"aco_id": s.acoUUID.String()})
+	assert.Nil(s.T(), s.o.ValidateJWT(ts))
+
+	ts, _ = s.issuer.token(jwt.MapClaims{"aco_id": "not-a-uuid"})
+	assert.NotNil(s.T(), s.o.ValidateJWT(ts))
+
+	s.o.acoClaim = "aco"
+	s.o.lookup = OIDCLookupCMSID
+	ts, _ = s.issuer.token(jwt.MapClaims{"aco": s.cmsID})
+	require.Nil(s.T(), s.o.ValidateJWT(ts))
+	t, _ := s.o.DecodeJWT(ts)
+	assert.Equal(s.T(), s.acoUUID.String(), t.Claims.(*CommonClaims).ACOID)
+
+	s.o.lookup = "no_such_field"
+	err := s.o.ValidateJWT(ts)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "unknown OIDC_ACO_LOOKUP")
+}
+
+func (s *OIDCAuthPluginTestSuite) TestRevocation() {
+	creds := s.register()
+	ts, err := s.o.MakeAccessToken(creds)
+	require.Nil(s.T(), err)
+	t, _ := s.o.DecodeJWT(ts)
+	jti := t.Claims.(*CommonClaims).Id
+	defer s.db.Unscoped().Delete(&RevokedToken{}, "jti = ?", jti)
+	defer s.db.Unscoped().Delete(&RevokedClient{}, "client_id = ?", creds.ClientID)
+
+	require.Nil(s.T(), s.o.RevokeAccessToken(ts))
+	err = s.o.ValidateJWT(ts)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "has been revoked")
+
+	ts, err = s.o.MakeAccessToken(creds)
+	require.Nil(s.T(), err)
+	require.Nil(s.T(), s.o.ValidateJWT(ts))
+	require.Nil(s.T(), s.o.RevokeClientCredentials(creds.ClientID))
+	err = s.o.ValidateJWT(ts)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), fmt.Sprintf("credentials for client %s have been revoked", creds.ClientID))
+}
+
+func TestOIDCAuthPluginSuite(t *testing.T) {
+	suite.Run(t, new(OIDCAuthPluginTestSuite))
+}
@@ -1,12 +1,20 @@
 package auth
 
 import (
+	"bytes"
 	"crypto/rsa"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/CMSgov/bcda-app/bcda/auth/client"
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/utils"
 	jwt "github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
 )
 
 type OktaBackend interface {
@@ -27,6 +35,15 @@ type OktaBackend interface {
 
 	// Deactivates a client application so it cannot be used
 	DeactivateApplication(clientID string) error
+
+	// Asks Okta whether a token is still active; returns client.ErrIntrospectionDisabled if we can't ask
+	IntrospectToken(tokenString string) (bool, error)
+
+	// Changes the metadata of a client application
+	UpdateClientApplication(clientID string, update client.ClientUpdate) (map[string]interface{}, error)
+
+	// Removes a client application from our Okta organization and the auth server's policy
+	RemoveClientApplication(clientID string) error
 }
 
 type OktaAuthPlugin struct {
@@ -52,12 +69,64 @@ func (o OktaAuthPlugin) RegisterClient(localID string) (Credentials, error) {
 	}, err
 }
 
+// UpdateClient changes the metadata of a client. params is a JSON object holding the client_id of the client and
+// the fields of client.ClientUpdate to change. The client's metadata is returned as JSON, without its secret.
 func (o OktaAuthPlugin) UpdateClient(params []byte) ([]byte, error) {
-	return nil, errors.New("not yet implemented")
+	var p struct {
+		ClientID string `json:"client_id"`
+		client.ClientUpdate
+	}
+	d := json.NewDecoder(bytes.NewReader(params))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&p); err != nil {
+		return nil, fmt.Errorf("invalid client update; %s", err)
+	}
+
+	if p.ClientID == "" {
+		return nil, errors.New("client_id required")
+	}
+	if p.ClientUpdate == (client.ClientUpdate{}) {
+		return nil, errors.New("nothing to update")
+	}
+
+	metadata, err := o.backend.UpdateClientApplication(p.ClientID, p.ClientUpdate)
+	if err != nil {
+		return nil, err
+	}
+	delete(metadata, "client_secret")
+
+	return json.Marshal(metadata)
 }
 
+// DeleteClient deactivates the client so it can no longer get tokens, rejects the tokens it already has, and
+// removes it from Okta. The ACO it belonged to is left without a client.
 func (o OktaAuthPlugin) DeleteClient(clientID string) error {
-	return errors.New("not yet implemented")
+	if clientID == "" {
+		return errors.New("client ID required")
+	}
+
+	if err := o.RevokeClientCredentials(clientID); err != nil {
+		return err
+	}
+
+	if err := o.backend.RemoveClientApplication(clientID); err != nil {
+		return err
+	}
+
+	aco, err := GetACOByClientID(clientID)
+	if err != nil {
+		// a client need not belong to an ACO, e.g. if registration failed part way through
+		log.Warnf("deleted client %s has no ACO; %s", clientID, err)
+		return nil
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	if err = db.Model(&aco).Update("client_id", "").Error; err != nil {
+		return err
+	}
+	notifyACOChanged(db, aco.UUID.String())
+	return nil
 }
 
 func (o OktaAuthPlugin) GenerateClientCredentials(clientID string, ttl int) (Credentials, error) {
@@ -80,12 +149,22 @@ func (o OktaAuthPlugin) RevokeClientCredentials(clientID string) error {
 		return err
 	}
 
-	return nil
+	// tokens already issued to the client remain valid in Okta's eyes until they expire
+	return revokeClient(clientID, "client credentials revoked")
 }
 
 // Manufactures an access token for the given credentials
 func (o OktaAuthPlugin) MakeAccessToken(credentials Credentials) (string, error) {
-	return "", nil
+	if credentials.ClientID == "" || credentials.ClientSecret == "" {
+		return "", fmt.Errorf("missing or incomplete credentials")
+	}
+
+	ot, err := o.backend.RequestAccessToken(client.Credentials{ClientID: credentials.ClientID, ClientSecret: credentials.ClientSecret})
+	if err != nil {
+		return "", fmt.Errorf("invalid credentials; %s", err)
+	}
+
+	return ot.AccessToken, nil
 }
 
 func (o OktaAuthPlugin) RequestAccessToken(creds Credentials, ttl int) (Token, error) {
@@ -114,7 +193,19 @@ func (o OktaAuthPlugin) RequestAccessToken(creds Credentials, ttl int) (Token, e
 }
 
 func (o OktaAuthPlugin) RevokeAccessToken(tokenString string) error {
-	return errors.New("not yet implemented")
+	return o.RevokeAccessTokenWithReason(tokenString, RevocationReasonUnspecified)
+}
+
+// RevokeAccessTokenWithReason revokes the token in tokenString, recording why it was revoked. Okta will still
+// consider the token active; only our own checks in ValidateJWT will reject it.
+func (o OktaAuthPlugin) RevokeAccessTokenWithReason(tokenString, reason string) error {
+	t, err := o.DecodeJWT(tokenString)
+	if err != nil {
+		return err
+	}
+
+	c := t.Claims.(*CommonClaims)
+	return revokeTokenID(c.Id, c.ClientID, c.ExpiresAt, reason)
 }
 
 func (o OktaAuthPlugin) ValidateJWT(tokenString string) error {
@@ -135,19 +226,110 @@ func (o OktaAuthPlugin) ValidateJWT(tokenString string) error {
 		return err
 	}
 
-	// need to check revocation here, which is not yet implemented
-	// options:
-	// keep an in-memory cache of tokens we have revoked and check that
-	// use the introspection endpoint okta provides (expensive network call)
-
-	_, err = GetACOByClientID(c.ClientID)
+	_, err = lookupACOByClientID(c.ClientID)
 	if err != nil {
 		return fmt.Errorf("invalid cid claim; %s", err)
 	}
 
+	return o.checkRevocation(tokenString, c)
+}
+
+// checkRevocation rejects tokens we have revoked, tokens issued to clients before we revoked them, and, when
+// introspection is configured, tokens that Okta no longer considers active
+func (o OktaAuthPlugin) checkRevocation(tokenString string, c *CommonClaims) error {
+	revoked, err := isTokenIDRevoked(c.Id)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return fmt.Errorf("token %s has been revoked", c.Id)
+	}
+
+	revokedAt, err := clientRevokedAt(c.ClientID)
+	if err != nil {
+		return err
+	}
+	if revokedAt != 0 && c.IssuedAt <= revokedAt {
+		return fmt.Errorf("credentials for client %s have been revoked", c.ClientID)
+	}
+
+	active, err := o.introspect(tokenString, c)
+	if err != nil {
+		return err
+	}
+	if !active {
+		return fmt.Errorf("token %s is not active", c.Id)
+	}
+
 	return nil
 }
 
+// Introspection is a network call to Okta, so answers are remembered for OKTA_INTROSPECTION_TTL seconds
+// (default 60). A token revoked in Okta may therefore be accepted for up to that long.
+type introspectionCache struct {
+	sync.Mutex
+	results map[string]introspectionResult
+}
+
+type introspectionResult struct {
+	active  bool
+	expires time.Time
+}
+
+var introspections = &introspectionCache{results: make(map[string]introspectionResult)}
+
+func introspectionTTL() time.Duration {
+	ttl := utils.FromEnv("OKTA_INTROSPECTION_TTL", "60")
+	n, err := strconv.Atoi(ttl)
+	if err != nil || n < 0 {
+		log.Warnf("invalid OKTA_INTROSPECTION_TTL %s; using 60", ttl)
+		n = 60
+	}
+	return time.Second * time.Duration(n)
+}
+
+// introspect reports whether Okta considers the token active. Tokens are considered active when introspection
+// is not configured.
+func (o OktaAuthPlugin) introspect(tokenString string, c *CommonClaims) (bool, error) {
+	introspections.Lock()
+	result, found := introspections.results[c.Id]
+	introspections.Unlock()
+	if found && c.Id != "" && time.Now().Before(result.expires) {
+		return result.active, nil
+	}
+
+	active, err := o.backend.IntrospectToken(tokenString)
+	if err == client.ErrIntrospectionDisabled {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to introspect token %s; %s", c.Id, err)
+	}
+
+	// no sense remembering the answer past the token's expiration
+	expires := time.Now().Add(introspectionTTL())
+	if exp := time.Unix(c.ExpiresAt, 0); exp.Before(expires) {
+		expires = exp
+	}
+
+	if c.Id == "" {
+		return active, nil
+	}
+
+	introspections.Lock()
+	defer introspections.Unlock()
+	if len(introspections.results) >= revocationCacheSize {
+		introspections.results = make(map[string]introspectionResult)
+	}
+	introspections.results[c.Id] = introspectionResult{active, expires}
+	return active, nil
+}
+
+func (o OktaAuthPlugin) issued(header map[string]interface{}, claims jwt.MapClaims) bool {
+	iss, _ := claims["iss"].(string)
+	return iss != "" && iss == o.backend.ServerID()
+}
+
 func (o OktaAuthPlugin) DecodeJWT(tokenString string) (*jwt.Token, error) {
 	keyFinder := func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
@@ -1,9 +1,11 @@
 package auth
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/CMSgov/bcda-app/bcda/database"
 	"github.com/CMSgov/bcda-app/bcda/models"
@@ -48,6 +50,7 @@ func (s *OktaAuthPluginTestSuite) SetupSuite() {
 func (s *OktaAuthPluginTestSuite) SetupTest() {
 	s.m = NewMokta()
 	s.o = NewOktaAuthPlugin(s.m)
+	introspections.results = make(map[string]introspectionResult)
 }
 
 func (s *OktaAuthPluginTestSuite) TestOktaRegisterClient() {
@@ -60,12 +63,101 @@ func (s *OktaAuthPluginTestSuite) TestOktaRegisterClient() {
 func (s *OktaAuthPluginTestSuite) TestOktaUpdateClient() {
 	c, err := s.o.UpdateClient([]byte("{}"))
 	assert.Nil(s.T(), c)
-	assert.Equal(s.T(), "not yet implemented", err.Error())
+	assert.Equal(s.T(), "client_id required", err.Error())
+
+	creds, err := s.o.RegisterClient(KnownFixtureACO)
+	require.Nil(s.T(), err)
+
+	c, err = s.o.UpdateClient([]byte(fmt.Sprintf(`{"client_id": "%s"}`, creds.ClientID)))
+	assert.Nil(s.T(), c)
+	assert.Equal(s.T(), "nothing to update", err.Error())
+
+	c, err = s.o.UpdateClient([]byte(fmt.Sprintf(`{"client_id": "%s", "client_secret": "mine"}`, creds.ClientID)))
+	assert.Nil(s.T(), c)
+	assert.Contains(s.T(), err.Error(), "invalid client update")
+
+	c, err = s.o.UpdateClient([]byte(`{"client_id": "not_a_client", "client_name": "Renamed"}`))
+	assert.Nil(s.T(), c)
+	assert.Equal(s.T(), "404 Not Found", err.Error())
+
+	c, err = s.o.UpdateClient([]byte(fmt.Sprintf(`{"client_id": "%s", "client_name": "Renamed", "client_uri": "https://aco.example.com"}`, creds.ClientID)))
+	require.Nil(s.T(), err)
+	var metadata map[string]interface{}
+	require.Nil(s.T(), json.Unmarshal(c, &metadata))
+	assert.Equal(s.T(), creds.ClientID, metadata["client_id"])
+	assert.Equal(s.T(), "Renamed", metadata["client_name"])
+	assert.Equal(s.T(), "https://aco.example.com", metadata["client_uri"])
+	assert.NotContains(s.T(), metadata, "client_secret")
+
+	// fields not mentioned are unchanged
+	c, err = s.o.UpdateClient([]byte(fmt.Sprintf(`{"client_id": "%s", "logo_uri": "https://aco.example.com/logo.png"}`, creds.ClientID)))
+	require.Nil(s.T(), err)
+	require.Nil(s.T(), json.Unmarshal(c, &metadata))
+	assert.Equal(s.T(), "Renamed", metadata["client_name"])
+	assert.Equal(s.T(), "https://aco.example.com/logo.png", metadata["logo_uri"])
 }
 
 func (s *OktaAuthPluginTestSuite) TestOktaDeleteClient() {
 	err := s.o.DeleteClient("")
-	assert.Equal(s.T(), "not yet implemented", err.Error())
+	assert.Equal(s.T(), "client ID required", err.Error())
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	cmsID := uuid.NewRandom().String()[0:4]
+	acoUUID, err := models.CreateACO("TestOktaDeleteClient", &cmsID)
+	require.Nil(s.T(), err)
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+
+	creds, err := s.o.RegisterClient(acoUUID.String())
+	require.Nil(s.T(), err)
+	defer db.Unscoped().Delete(&RevokedClient{}, "client_id = ?", creds.ClientID)
+	require.Nil(s.T(), db.Model(&models.ACO{}).Where("uuid = ?", acoUUID).Update("client_id", creds.ClientID).Error)
+	NotifyACOChanged(acoUUID.String())
+
+	token, err := s.o.MakeAccessToken(creds)
+	require.Nil(s.T(), err)
+	require.Nil(s.T(), s.o.ValidateJWT(token))
+
+	err = s.o.DeleteClient(creds.ClientID)
+	require.Nil(s.T(), err)
+
+	assert.NotNil(s.T(), s.o.ValidateJWT(token), "tokens issued before deletion must be rejected")
+	_, err = s.o.MakeAccessToken(creds)
+	assert.NotNil(s.T(), err)
+	_, ok := s.m.clients[creds.ClientID]
+	assert.False(s.T(), ok)
+	var aco models.ACO
+	db.First(&aco, "uuid = ?", acoUUID)
+	assert.Empty(s.T(), aco.ClientID)
+
+	err = s.o.DeleteClient(creds.ClientID)
+	assert.Equal(s.T(), "404 Not Found", err.Error())
+}
+
+func (s *OktaAuthPluginTestSuite) TestOktaMakeAccessToken() {
+	_, err := s.o.MakeAccessToken(Credentials{})
+	assert.Contains(s.T(), err.Error(), "missing or incomplete credentials")
+
+	creds, err := s.o.RegisterClient(KnownFixtureACO)
+	require.Nil(s.T(), err)
+
+	_, err = s.o.MakeAccessToken(Credentials{ClientID: creds.ClientID, ClientSecret: "not_the_right_secret"})
+	assert.Contains(s.T(), err.Error(), "invalid credentials")
+
+	token, err := s.o.MakeAccessToken(creds)
+	require.Nil(s.T(), err)
+	t, err := s.o.DecodeJWT(token)
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), creds.ClientID, t.Claims.(*CommonClaims).ClientID)
+
+	// a new secret replaces the old one
+	newCreds, err := s.o.GenerateClientCredentials(creds.ClientID, 0)
+	require.Nil(s.T(), err)
+	_, err = s.o.MakeAccessToken(creds)
+	assert.NotNil(s.T(), err)
+	_, err = s.o.MakeAccessToken(newCreds)
+	assert.Nil(s.T(), err)
 }
 
 func (s *OktaAuthPluginTestSuite) TestGenerateClientCredentials() {
@@ -80,8 +172,37 @@ func (s *OktaAuthPluginTestSuite) TestGenerateClientCredentials() {
 }
 
 func (s *OktaAuthPluginTestSuite) TestRevokeClientCredentials() {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	defer func() {
+		db.Unscoped().Delete(&RevokedClient{}, "client_id in (?)", []string{"fakeClientID", KnownClientID})
+		revocations.invalidate("")
+	}()
+
 	err := s.o.RevokeClientCredentials("fakeClientID")
 	assert.Nil(s.T(), err)
+
+	// only our own record of the revocation stands between the client's tokens and the API
+	s.m.introspection = false
+	before, err := s.m.NewToken(KnownClientID)
+	require.Nil(s.T(), err)
+	require.Nil(s.T(), s.o.ValidateJWT(before))
+
+	err = s.o.RevokeClientCredentials(KnownClientID)
+	assert.Nil(s.T(), err)
+	err = s.o.ValidateJWT(before)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "have been revoked")
+
+	// tokens issued after the revocation, say to a reactivated client, are not affected
+	var rc RevokedClient
+	db.First(&rc, "client_id = ?", KnownClientID)
+	assert.Equal(s.T(), "client credentials revoked", rc.Reason)
+	db.Model(&rc).Update("revoked_at", rc.RevokedAt.Add(-time.Hour))
+	revocations.invalidate("client:" + KnownClientID)
+	after, err := s.m.NewToken(KnownClientID)
+	require.Nil(s.T(), err)
+	assert.Nil(s.T(), s.o.ValidateJWT(after))
 }
 
 func (s *OktaAuthPluginTestSuite) TestRequestAccessToken() {
@@ -102,7 +223,63 @@ func (s *OktaAuthPluginTestSuite) TestRequestAccessToken() {
 
 func (s *OktaAuthPluginTestSuite) TestOktaRevokeAccessToken() {
 	err := s.o.RevokeAccessToken("")
-	assert.Equal(s.T(), "not yet implemented", err.Error())
+	assert.NotNil(s.T(), err)
+
+	token, err := s.m.NewToken(KnownClientID)
+	require.Nil(s.T(), err)
+	require.Nil(s.T(), s.o.ValidateJWT(token))
+	t, _ := s.o.DecodeJWT(token)
+	jti := t.Claims.(*CommonClaims).Id
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	defer db.Unscoped().Delete(&RevokedToken{}, "jti = ?", jti)
+
+	err = s.o.RevokeAccessTokenWithReason(token, "testing")
+	require.Nil(s.T(), err)
+	err = s.o.ValidateJWT(token)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "has been revoked")
+
+	var rt RevokedToken
+	db.First(&rt, "jti = ?", jti)
+	assert.Equal(s.T(), KnownClientID, rt.ClientID)
+	assert.Equal(s.T(), "testing", rt.Reason)
+
+	err = s.o.RevokeAccessToken(token)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "already been revoked")
+
+	// other tokens for the client are unaffected
+	other, err := s.m.NewToken(KnownClientID)
+	require.Nil(s.T(), err)
+	assert.Nil(s.T(), s.o.ValidateJWT(other))
+}
+
+func (s *OktaAuthPluginTestSuite) TestIntrospection() {
+	token, err := s.m.NewToken(KnownClientID)
+	require.Nil(s.T(), err)
+	require.Nil(s.T(), s.o.ValidateJWT(token))
+	assert.Equal(s.T(), 1, s.m.introspections)
+
+	// answers are cached
+	require.Nil(s.T(), s.o.ValidateJWT(token))
+	assert.Equal(s.T(), 1, s.m.introspections)
+
+	// deactivated in Okta, but not through us
+	_ = s.m.DeactivateApplication(KnownClientID)
+	require.Nil(s.T(), s.o.ValidateJWT(token))
+
+	introspections.results = make(map[string]introspectionResult)
+	err = s.o.ValidateJWT(token)
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "is not active")
+	assert.Equal(s.T(), 2, s.m.introspections)
+
+	// without introspection, Okta's view is not consulted
+	s.m.introspection = false
+	introspections.results = make(map[string]introspectionResult)
+	assert.Nil(s.T(), s.o.ValidateJWT(token))
 }
 
 func (s *OktaAuthPluginTestSuite) TestValidateJWT() {
@@ -3,16 +3,16 @@ package auth
 import (
 	"os"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	log "github.com/sirupsen/logrus"
-
-	"github.com/CMSgov/bcda-app/bcda/auth/client"
 )
 
 const (
 	Alpha = "alpha"
 	Okta  = "okta"
+	OIDC  = "oidc"
 )
 
 var providerName = Alpha
@@ -20,6 +20,7 @@ var providerName = Alpha
 func init() {
 	log.SetFormatter(&log.JSONFormatter{})
 	SetProvider(strings.ToLower(os.Getenv(`BCDA_AUTH_PROVIDER`)))
+	SetAcceptedProviders(os.Getenv(`BCDA_AUTH_PROVIDERS`))
 }
 
 func SetProvider(name string) {
@@ -29,6 +30,8 @@ func SetProvider(name string) {
 			providerName = name
 		case Alpha:
 			providerName = name
+		case OIDC:
+			providerName = name
 		default:
 			log.Infof(`Unknown providerName %s; using %s`, name, providerName)
 		}
@@ -40,21 +43,17 @@ func GetProviderName() string {
 	return providerName
 }
 
+// GetProvider returns the provider named by BCDA_AUTH_PROVIDER, which registers clients and issues tokens. Tokens
+// are verified by the provider returned by ProviderForToken.
 func GetProvider() Provider {
-	switch providerName {
-	case Alpha:
-		return AlphaAuthPlugin{}
-	case Okta:
-		return NewOktaAuthPlugin(client.NewOktaClient())
-	default:
-		return AlphaAuthPlugin{}
-	}
+	return providerNamed(providerName)
 }
 
 type AuthData struct {
 	ACOID   string
 	UserID  string
 	TokenID string
+	Scopes  []string
 }
 
 type Credentials struct {
@@ -63,6 +62,10 @@ type Credentials struct {
 	ClientSecret string
 	Token        Token
 	ClientName   string
+	// when ClientSecret stops working; never when zero
+	ExpiresAt time.Time
+	// thumbprint of the client certificate the token requested with these credentials is bound to; none when empty
+	CertificateThumbprint string
 }
 
 // Provider defines operations performed through an authentication provider.
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/CMSgov/bcda-app/bcda/auth/client"
+)
+
+// Tokens are verified by whichever accepted provider issued them, so that ACOs can be moved from one provider to
+// another a few at a time instead of all at once. The providers accepted are named, comma separated, in
+// BCDA_AUTH_PROVIDERS. The provider named by BCDA_AUTH_PROVIDER, which registers clients and issues tokens, is
+// always accepted.
+var acceptedProviders []string
+
+// providers to use in place of the ones providerNamed would make; for testing with Mokta and fake issuers
+var registeredProviders = make(map[string]Provider)
+
+// tokenIssuer is implemented by providers that can recognize their own tokens from the unverified header and claims
+type tokenIssuer interface {
+	issued(header map[string]interface{}, claims jwt.MapClaims) bool
+}
+
+// IsValidProvider reports whether name is the name of an auth provider
+func IsValidProvider(name string) bool {
+	switch name {
+	case Alpha, Okta, OIDC:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetAcceptedProviders sets the providers, besides the one named by BCDA_AUTH_PROVIDER, whose tokens are accepted.
+// names is a comma separated list; unknown names are ignored.
+func SetAcceptedProviders(names string) {
+	acceptedProviders = nil
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !IsValidProvider(name) {
+			log.Infof(`Unknown provider name %s in BCDA_AUTH_PROVIDERS; ignoring it`, name)
+			continue
+		}
+		acceptedProviders = append(acceptedProviders, name)
+	}
+}
+
+// AcceptedProviders returns the names of the providers whose tokens are accepted, starting with the one named by
+// BCDA_AUTH_PROVIDER
+func AcceptedProviders() []string {
+	names := []string{providerName}
+	for _, name := range acceptedProviders {
+		found := false
+		for _, n := range names {
+			found = found || n == name
+		}
+		if !found {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func providerNamed(name string) Provider {
+	if p, ok := registeredProviders[name]; ok {
+		return p
+	}
+
+	switch name {
+	case Alpha:
+		return AlphaAuthPlugin{}
+	case Okta:
+		return NewOktaAuthPlugin(client.NewOktaClient())
+	case OIDC:
+		return NewOIDCAuthPlugin(client.NewOIDCClient(os.Getenv("OIDC_ISSUER"), os.Getenv("OIDC_REGISTRATION_TOKEN")))
+	default:
+		return AlphaAuthPlugin{}
+	}
+}
+
+// ProviderForToken returns the name of the accepted provider that issued tokenString, along with the provider
+// itself. Our own tokens are recognized by their kid, and those of other providers by their iss. When only one
+// provider is accepted, it is returned without looking at the token.
+func ProviderForToken(tokenString string) (string, Provider, error) {
+	names := AcceptedProviders()
+	if len(names) == 1 {
+		return names[0], providerNamed(names[0]), nil
+	}
+
+	claims := jwt.MapClaims{}
+	t, _, err := new(jwt.Parser).ParseUnverified(tokenString, claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, name := range names {
+		p := providerNamed(name)
+		if ti, ok := p.(tokenIssuer); ok && ti.issued(t.Header, claims) {
+			return name, p, nil
+		}
+	}
+
+	iss, _ := claims["iss"].(string)
+	kid, _ := t.Header["kid"].(string)
+	return "", nil, fmt.Errorf("token with iss %q and kid %q was not issued by an accepted auth provider", iss, kid)
+}
+
+// checkEnrollment asserts that the ACO identified by acoID may use tokens issued by the named provider. ACOs that
+// are not enrolled in a provider may use any accepted provider.
+func checkEnrollment(acoID, name string) error {
+	if acoID == "" {
+		return nil
+	}
+
+	aco, err := lookupACO(acoID)
+	if err != nil {
+		return err
+	}
+
+	if aco.AuthProvider != "" && aco.AuthProvider != name {
+		return fmt.Errorf("ACO %s is enrolled in the %s auth provider, not %s", acoID, aco.AuthProvider, name)
+	}
+	return nil
+}
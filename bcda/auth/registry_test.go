@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type RegistryTestSuite struct {
+	suite.Suite
+	m                 *Mokta
+	primary           string
+	accepted          []string
+	originalProviders map[string]Provider
+}
+
+func (s *RegistryTestSuite) SetupSuite() {
+	models.InitializeGormModels()
+	InitializeGormModels()
+}
+
+func (s *RegistryTestSuite) SetupTest() {
+	s.primary = providerName
+	s.accepted = acceptedProviders
+	s.originalProviders = registeredProviders
+
+	s.m = NewMokta()
+	registeredProviders = map[string]Provider{Okta: NewOktaAuthPlugin(s.m)}
+	SetProvider(Alpha)
+	SetAcceptedProviders("okta")
+}
+
+func (s *RegistryTestSuite) TearDownTest() {
+	SetProvider(s.primary)
+	acceptedProviders = s.accepted
+	registeredProviders = s.originalProviders
+}
+
+func (s *RegistryTestSuite) TestSetAcceptedProviders() {
+	SetAcceptedProviders(" OKTA, bogus,,alpha,oidc ")
+	assert.Equal(s.T(), []string{Alpha, Okta, OIDC}, AcceptedProviders())
+
+	SetAcceptedProviders("")
+	assert.Equal(s.T(), []string{Alpha}, AcceptedProviders())
+}
+
+func (s *RegistryTestSuite) TestProviderForToken() {
+	alphaToken, err := TokenStringWithIDs(uuid.NewRandom().String(), KnownFixtureACO)
+	require.Nil(s.T(), err)
+	name, p, err := ProviderForToken(alphaToken)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), Alpha, name)
+	assert.IsType(s.T(), AlphaAuthPlugin{}, p)
+
+	oktaToken, err := s.m.NewToken(KnownClientID)
+	require.Nil(s.T(), err)
+	name, p, err = ProviderForToken(oktaToken)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), Okta, name)
+	assert.IsType(s.T(), OktaAuthPlugin{}, p)
+
+	strangerToken, err := s.m.NewCustomToken(OktaToken{Issuer: "https://elsewhere.example.com"})
+	require.Nil(s.T(), err)
+	_, _, err = ProviderForToken(strangerToken)
+	assert.Contains(s.T(), err.Error(), "was not issued by an accepted auth provider")
+
+	_, _, err = ProviderForToken("not a token")
+	assert.NotNil(s.T(), err)
+
+	// with only one provider accepted, it verifies every token
+	SetAcceptedProviders("")
+	name, _, err = ProviderForToken(oktaToken)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), Alpha, name)
+}
+
+func (s *RegistryTestSuite) TestCheckEnrollment() {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	cmsID := uuid.NewRandom().String()[0:4]
+	aco := models.ACO{UUID: uuid.NewRandom(), Name: "Registry Test ACO", CMSID: &cmsID}
+	require.Nil(s.T(), db.Create(&aco).Error)
+	defer db.Unscoped().Delete(&aco)
+
+	// not enrolled in any provider
+	assert.Nil(s.T(), checkEnrollment(aco.UUID.String(), Alpha))
+	assert.Nil(s.T(), checkEnrollment(aco.UUID.String(), Okta))
+
+	require.Nil(s.T(), db.Model(&aco).Update("auth_provider", Okta).Error)
+	NotifyACOChanged(aco.UUID.String())
+	assert.Nil(s.T(), checkEnrollment(aco.UUID.String(), Okta))
+	err := checkEnrollment(aco.UUID.String(), Alpha)
+	assert.EqualError(s.T(), err, "ACO "+aco.UUID.String()+" is enrolled in the okta auth provider, not alpha")
+
+	err = checkEnrollment(uuid.NewRandom().String(), Alpha)
+	assert.Contains(s.T(), err.Error(), "no ACO record found")
+}
+
+func TestRegistryTestSuite(t *testing.T) {
+	suite.Run(t, new(RegistryTestSuite))
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/CMSgov/bcda-app/bcda/database"
+)
+
+// Alpha access tokens are revoked by marking their row in the tokens table inactive; tokens from other providers
+// and the clients they were issued to are recorded in revoked_tokens and revoked_clients. Every API instance has
+// to honor a revocation, so ValidateJWT asks a small cache of recent answers, which is invalidated by a NOTIFY on
+// revocationChannel whenever something is revoked.
+
+const (
+	revocationChannel   = "token_revocations"
+	revocationCacheSize = 10000
+
+	// RevocationReasonUnspecified is recorded when a token is revoked without a reason
+	RevocationReasonUnspecified = "unspecified"
+)
+
+// revocations maps a key to the unix time it was revoked, or zero if it has not been. Each answer is its own group,
+// so that a revocation forgets only the answer about what was revoked.
+var revocations = newAnswerCache(revocationCacheSize, nil)
+
+// revokedAt returns when key was revoked, or zero if it has not been, asking lookup only if the cache can't say
+func revokedAt(key string, lookup func(db *gorm.DB) (int64, error)) (int64, error) {
+	v, err := revocations.lookup(key, func(db *gorm.DB) (interface{}, string, error) {
+		t, err := lookup(db)
+		return t, key, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v.(int64), nil
+}
+
+// isRevoked reports whether the alpha token identified by tokenID has been revoked. Tokens that were never
+// persisted are not revoked.
+func isRevoked(tokenID string) (bool, error) {
+	// only tokens with UUIDs are persisted
+	if uuid.Parse(tokenID) == nil {
+		return false, nil
+	}
+
+	t, err := revokedAt(tokenID, func(db *gorm.DB) (int64, error) {
+		var times []*time.Time
+		err := db.Model(&Token{}).Where("uuid = ? and active = ?", tokenID, false).Pluck("revoked_at", &times).Error
+		if err != nil || len(times) == 0 {
+			return 0, err
+		}
+		// tokens deactivated before we recorded revocation times
+		if times[0] == nil {
+			return 1, nil
+		}
+		return times[0].Unix(), nil
+	})
+	return t != 0, err
+}
+
+// revokeToken marks token revoked, creating its record if it was issued before tokens were persisted
+func revokeToken(token Token, reason string) error {
+	if reason == "" {
+		reason = RevocationReasonUnspecified
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var active []bool
+	if err := db.Model(&Token{}).Where("uuid = ?", token.UUID).Pluck("active", &active).Error; err != nil {
+		return err
+	}
+
+	if len(active) == 0 {
+		token.Active = false
+		now := time.Now()
+		token.RevokedAt = &now
+		token.RevocationReason = reason
+		if err := db.Create(&token).Error; err != nil {
+			return err
+		}
+	} else {
+		if !active[0] {
+			return fmt.Errorf("access token %s has already been revoked", token.UUID)
+		}
+		err := db.Model(&Token{}).Where("uuid = ?", token.UUID).Updates(map[string]interface{}{
+			"active":            false,
+			"revoked_at":        time.Now(),
+			"revocation_reason": reason,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	notifyRevoked(db, token.UUID.String())
+	log.WithField("token_id", token.UUID.String()).WithField("reason", reason).Info("access token revoked")
+	return nil
+}
+
+// RevokeACOTokens revokes every active alpha access token issued to the ACO identified by acoID, returning the
+// number of tokens revoked.
+func RevokeACOTokens(acoID, reason string) (int64, error) {
+	if uuid.Parse(acoID) == nil {
+		return 0, errors.New("ACO ID must be a UUID")
+	}
+	if reason == "" {
+		reason = RevocationReasonUnspecified
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	result := db.Model(&Token{}).Where("aco_id = ? and active = ?", acoID, true).Updates(map[string]interface{}{
+		"active":            false,
+		"revoked_at":        time.Now(),
+		"revocation_reason": reason,
+	})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	// an empty payload invalidates every cached answer
+	notifyRevoked(db, "")
+	log.WithField("aco_id", acoID).WithField("reason", reason).Infof("%d access tokens revoked", result.RowsAffected)
+	return result.RowsAffected, nil
+}
+
+// notifyRevoked tells every listening instance, including this one, to forget what it knows about tokenID
+func notifyRevoked(db *gorm.DB, tokenID string) {
+	revocations.invalidate(tokenID)
+	if err := db.Exec("SELECT pg_notify(?, ?)", revocationChannel, tokenID).Error; err != nil {
+		log.Errorf("unable to notify listeners of revocation of %q; %s", tokenID, err)
+	}
+}
+
+// isTokenIDRevoked reports whether the token with the given jti claim has been revoked
+func isTokenIDRevoked(jti string) (bool, error) {
+	t, err := revokedAt("jti:"+jti, func(db *gorm.DB) (int64, error) {
+		var times []time.Time
+		err := db.Model(&RevokedToken{}).Where("jti = ?", jti).Pluck("revoked_at", &times).Error
+		if err != nil || len(times) == 0 {
+			return 0, err
+		}
+		return times[0].Unix(), nil
+	})
+	return t != 0, err
+}
+
+// clientRevokedAt returns the unix time at which the credentials of clientID were revoked, or zero if they
+// have not been
+func clientRevokedAt(clientID string) (int64, error) {
+	return revokedAt("client:"+clientID, func(db *gorm.DB) (int64, error) {
+		var times []time.Time
+		err := db.Model(&RevokedClient{}).Where("client_id = ?", clientID).Pluck("revoked_at", &times).Error
+		if err != nil || len(times) == 0 {
+			return 0, err
+		}
+		return times[0].Unix(), nil
+	})
+}
+
+// revokeTokenID records the revocation of the token with the given jti claim
+func revokeTokenID(jti, clientID string, expiresOn int64, reason string) error {
+	if jti == "" {
+		return errors.New("token has no jti claim")
+	}
+	if reason == "" {
+		reason = RevocationReasonUnspecified
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var count int
+	if err := db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("access token %s has already been revoked", jti)
+	}
+
+	rt := RevokedToken{JTI: jti, ClientID: clientID, ExpiresOn: expiresOn, RevokedAt: time.Now(), Reason: reason}
+	if err := db.Create(&rt).Error; err != nil {
+		return err
+	}
+
+	notifyRevoked(db, "jti:"+jti)
+	log.WithField("jti", jti).WithField("client_id", clientID).WithField("reason", reason).Info("access token revoked")
+	return nil
+}
+
+// revokeClient records that every token issued to clientID until now is revoked
+func revokeClient(clientID, reason string) error {
+	if reason == "" {
+		reason = RevocationReasonUnspecified
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var rc RevokedClient
+	err := db.Where(RevokedClient{ClientID: clientID}).
+		Assign(RevokedClient{RevokedAt: time.Now(), Reason: reason}).
+		FirstOrCreate(&rc).Error
+	if err != nil {
+		return err
+	}
+
+	notifyRevoked(db, "client:"+clientID)
+	log.WithField("client_id", clientID).WithField("reason", reason).Info("client credentials revoked")
+	return nil
+}
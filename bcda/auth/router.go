@@ -2,7 +2,6 @@ package auth
 
 import (
 	"net/http"
-	"os"
 
 	"github.com/CMSgov/bcda-app/bcda/monitoring"
 	"github.com/go-chi/chi"
@@ -13,12 +12,19 @@ func NewAuthRouter(middlewares ...func(http.Handler) http.Handler) http.Handler
 	m := monitoring.GetMonitor()
 	r.Use(middlewares...)
 	r.Post(m.WrapHandler("/auth/token", GetAuthToken))
-	// TODO: remove conditional when new authentication implemented for administrative endpoints
-	if os.Getenv("DEBUG") == "true" {
-		r.Get(m.WrapHandler("/auth/group", GetAuthGroups))
-		r.Post(m.WrapHandler("/auth/group", CreateAuthGroup))
-		r.Put(m.WrapHandler("/auth/group", EditAuthGroup))
-		r.Delete(m.WrapHandler("/auth/group", DeactivateAuthGroup))
-	}
+	r.Post(m.WrapHandler("/auth/introspect", IntrospectToken))
+	r.Get(m.WrapHandler("/auth/.well-known/jwks.json", GetJWKS))
+	r.Post(m.WrapHandler("/auth/admin/token", GetAdminToken))
+	r.Route("/auth/admin", func(r chi.Router) {
+		r.Use(RequireAdmin)
+		r.Get(m.WrapHandler("/acos", AdminListACOs))
+		r.Post(m.WrapHandler("/acos", AdminCreateACO))
+		r.Delete(m.WrapHandler("/acos/{acoID}", AdminDeactivateACO))
+		r.Get(m.WrapHandler("/acos/{acoID}/users", AdminListUsers))
+		r.Post(m.WrapHandler("/acos/{acoID}/users", AdminCreateUser))
+		r.Delete(m.WrapHandler("/acos/{acoID}/users/{userID}", AdminDeactivateUser))
+		r.Post(m.WrapHandler("/acos/{acoID}/client", AdminRegisterClient))
+		r.Delete(m.WrapHandler("/acos/{acoID}/client", AdminDeactivateClient))
+	})
 	return r
 }
@@ -4,8 +4,8 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strings"
+	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -17,7 +17,6 @@ type AuthRouterTestSuite struct {
 }
 
 func (s *AuthRouterTestSuite) SetupTest() {
-	os.Setenv("DEBUG", "true")
 	s.authRouter = NewAuthRouter()
 }
 
@@ -33,22 +32,40 @@ func (s *AuthRouterTestSuite) TestAuthTokenRoute() {
 	assert.Equal(s.T(), http.StatusBadRequest, res.StatusCode)
 }
 
-func (s *AuthRouterTestSuite) TestGetAuthGroupRoute() {
-	res := s.reqAuthRoute("GET", "/auth/group", nil)
-	assert.Equal(s.T(), http.StatusUnauthorized, res.StatusCode)
+func (s *AuthRouterTestSuite) TestJWKSRoute() {
+	originalProvider := GetProviderName()
+	defer SetProvider(originalProvider)
+	SetProvider(Okta)
+	res := s.reqAuthRoute("GET", "/auth/.well-known/jwks.json", nil)
+	assert.Equal(s.T(), http.StatusNotFound, res.StatusCode)
 }
 
-func (s *AuthRouterTestSuite) TestPostAuthGroupRoute() {
-	res := s.reqAuthRoute("POST", "/auth/group", nil)
-	assert.Equal(s.T(), http.StatusNotImplemented, res.StatusCode)
+func (s *AuthRouterTestSuite) TestAdminTokenRoute() {
+	res := s.reqAuthRoute("POST", "/auth/admin/token", nil)
+	assert.Equal(s.T(), http.StatusBadRequest, res.StatusCode)
+}
+
+func (s *AuthRouterTestSuite) TestIntrospectRoute() {
+	res := s.reqAuthRoute("POST", "/auth/introspect", nil)
+	assert.Equal(s.T(), http.StatusUnauthorized, res.StatusCode)
+	assert.NotEmpty(s.T(), res.Header.Get("WWW-Authenticate"))
 }
 
-func (s *AuthRouterTestSuite) TestPutAuthGroupRoute() {
-	res := s.reqAuthRoute("PUT", "/auth/group", nil)
-	assert.Equal(s.T(), http.StatusNotImplemented, res.StatusCode)
+func (s *AuthRouterTestSuite) TestAdminRoutesRequireAdminToken() {
+	acoID := "DBBD1CE1-AE24-435C-807D-ED45953077D3"
+	for _, route := range []struct{ verb, path string }{
+		{"GET", "/auth/admin/acos"},
+		{"POST", "/auth/admin/acos"},
+		{"GET", "/auth/admin/acos/" + acoID + "/users"},
+		{"POST", "/auth/admin/acos/" + acoID + "/users"},
+		{"POST", "/auth/admin/acos/" + acoID + "/client"},
+		{"DELETE", "/auth/admin/acos/" + acoID + "/client"},
+	} {
+		res := s.reqAuthRoute(route.verb, route.path, nil)
+		assert.Equal(s.T(), http.StatusUnauthorized, res.StatusCode, "%s %s", route.verb, route.path)
+	}
 }
 
-func (s *AuthRouterTestSuite) TestDeleteAuthGroupRoute() {
-	res := s.reqAuthRoute("DELETE", "/auth/group", nil)
-	assert.Equal(s.T(), http.StatusNotImplemented, res.StatusCode)
+func TestAuthRouterTestSuite(t *testing.T) {
+	suite.Run(t, new(AuthRouterTestSuite))
 }
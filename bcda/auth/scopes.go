@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/CMSgov/bcda-app/bcda/database"
+)
+
+// Tokens may be limited to reading some resource types by SMART scopes, such as system/Coverage.read, in their scp
+// claim. Tokens with no SMART scopes, which includes those issued before scopes were introduced, may read every
+// resource type.
+
+// AllResourcesScope is the scope that allows reading every resource type
+const AllResourcesScope = "system/*.read"
+
+// scopes we grant; clients may only read
+var grantableScope = regexp.MustCompile(`^system/(\*|Patient|Coverage|ExplanationOfBenefit)\.(read|\*)$`)
+
+// grantScopes returns, space delimited, the requested scopes that we grant. Requests for write access are
+// granted read access.
+func grantScopes(requested string) string {
+	var granted []string
+	for _, s := range strings.Fields(requested) {
+		m := grantableScope.FindStringSubmatch(s)
+		if m == nil {
+			continue
+		}
+		granted = appendScope(granted, fmt.Sprintf("system/%s.read", m[1]))
+	}
+	return strings.Join(granted, " ")
+}
+
+func appendScope(scopes []string, scope string) []string {
+	for _, s := range scopes {
+		if s == scope {
+			return scopes
+		}
+	}
+	return append(scopes, scope)
+}
+
+// NormalizeScopes checks that every scope in the space delimited list scope is one we grant, returning the list
+// with write access reduced to read access
+func NormalizeScopes(scope string) (string, error) {
+	for _, s := range strings.Fields(scope) {
+		if !grantableScope.MatchString(s) {
+			return "", fmt.Errorf("invalid scope %s; scopes must be system/*.read or system/<resource type>.read", s)
+		}
+	}
+	return grantScopes(scope), nil
+}
+
+// limitScopes returns the scopes in granted that are also in allowed. A grant of every resource type is limited
+// to the resource types allowed. Empty allowed scopes allow everything.
+func limitScopes(granted, allowed string) string {
+	if allowed == "" {
+		return granted
+	}
+
+	allowedScopes := strings.Fields(allowed)
+	allowsAll := false
+	for _, a := range allowedScopes {
+		allowsAll = allowsAll || a == AllResourcesScope
+	}
+
+	var limited []string
+	for _, g := range strings.Fields(granted) {
+		switch {
+		case allowsAll:
+			limited = appendScope(limited, g)
+		case g == AllResourcesScope:
+			for _, a := range allowedScopes {
+				limited = appendScope(limited, a)
+			}
+		default:
+			for _, a := range allowedScopes {
+				if a == g {
+					limited = appendScope(limited, g)
+				}
+			}
+		}
+	}
+	return strings.Join(limited, " ")
+}
+
+// SetClientScope limits the tokens issued to a client to the space delimited scopes in scope. Empty scope removes
+// the limit.
+func SetClientScope(clientID, scope string) error {
+	if clientID == "" {
+		return fmt.Errorf("client ID required")
+	}
+
+	scope, err := NormalizeScopes(scope)
+	if err != nil {
+		return err
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var cs ClientScope
+	err = db.Where(ClientScope{ClientID: clientID}).Assign(ClientScope{Scope: scope}).FirstOrCreate(&cs).Error
+	if err != nil {
+		return fmt.Errorf("unable to save scope for client %s; %s", clientID, err)
+	}
+
+	log.WithField("client_id", clientID).WithField("scope", scope).Info("client scope set")
+	return nil
+}
+
+// clientScope returns the scopes the client's tokens are limited to; empty if they are not limited
+func clientScope(clientID string) (string, error) {
+	if clientID == "" {
+		return "", nil
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var scopes []string
+	if err := db.Model(&ClientScope{}).Where("client_id = ?", clientID).Pluck("scope", &scopes).Error; err != nil {
+		return "", err
+	}
+	if len(scopes) == 0 {
+		return "", nil
+	}
+	return scopes[0], nil
+}
+
+// tokenScopes returns, space delimited, the scopes in a token's scp claim, or its scope claim if it has no scp
+func tokenScopes(claims jwt.MapClaims) string {
+	if scp, ok := claims["scp"].([]interface{}); ok {
+		var scopes []string
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+		return strings.Join(scopes, " ")
+	}
+	scope, _ := claims["scope"].(string)
+	return scope
+}
+
+// CanRead reports whether the token may read resources of resourceType
+func (ad AuthData) CanRead(resourceType string) bool {
+	limited := false
+	for _, s := range ad.Scopes {
+		if !strings.HasPrefix(s, "system/") {
+			continue
+		}
+		limited = true
+		if s == AllResourcesScope || s == fmt.Sprintf("system/%s.read", resourceType) {
+			return true
+		}
+	}
+	return !limited
+}
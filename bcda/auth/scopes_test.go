@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ScopesTestSuite struct {
+	suite.Suite
+}
+
+func (s *ScopesTestSuite) TestGrantScopes() {
+	assert.Equal(s.T(), "system/Patient.read system/Coverage.read", grantScopes("system/Patient.read system/Observation.read system/Coverage.*"))
+	assert.Equal(s.T(), "system/*.read", grantScopes("system/*.* system/*.read"))
+	assert.Equal(s.T(), "", grantScopes("patient/*.read user/Coverage.read"))
+}
+
+func (s *ScopesTestSuite) TestNormalizeScopes() {
+	scope, err := NormalizeScopes("system/Coverage.* system/ExplanationOfBenefit.read")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "system/Coverage.read system/ExplanationOfBenefit.read", scope)
+
+	scope, err = NormalizeScopes("")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "", scope)
+
+	_, err = NormalizeScopes("system/Coverage.read system/Observation.read")
+	assert.EqualError(s.T(), err, "invalid scope system/Observation.read; scopes must be system/*.read or system/<resource type>.read")
+}
+
+func (s *ScopesTestSuite) TestLimitScopes() {
+	assert.Equal(s.T(), "system/*.read", limitScopes("system/*.read", ""))
+	assert.Equal(s.T(), "system/Coverage.read", limitScopes("system/*.read", "system/Coverage.read"))
+	assert.Equal(s.T(), "system/Coverage.read", limitScopes("system/Patient.read system/Coverage.read", "system/Coverage.read"))
+	assert.Equal(s.T(), "system/Patient.read", limitScopes("system/Patient.read", "system/*.read"))
+	assert.Equal(s.T(), "", limitScopes("system/Patient.read", "system/Coverage.read"))
+}
+
+func (s *ScopesTestSuite) TestTokenScopes() {
+	assert.Equal(s.T(), "system/Patient.read bcda_api", tokenScopes(jwt.MapClaims{"scp": []interface{}{"system/Patient.read", "bcda_api"}}))
+	assert.Equal(s.T(), "system/Coverage.read", tokenScopes(jwt.MapClaims{"scope": "system/Coverage.read"}))
+	assert.Equal(s.T(), "", tokenScopes(jwt.MapClaims{}))
+}
+
+func (s *ScopesTestSuite) TestCanRead() {
+	assert.True(s.T(), AuthData{}.CanRead("Patient"))
+	assert.True(s.T(), AuthData{Scopes: []string{"bcda_api"}}.CanRead("Patient"))
+	assert.True(s.T(), AuthData{Scopes: []string{"system/*.read"}}.CanRead("Patient"))
+	assert.True(s.T(), AuthData{Scopes: []string{"system/Patient.read"}}.CanRead("Patient"))
+	assert.False(s.T(), AuthData{Scopes: []string{"system/Patient.read"}}.CanRead("Coverage"))
+	assert.False(s.T(), AuthData{Scopes: []string{"bcda_api", "system/Patient.read"}}.CanRead("*"))
+}
+
+func TestScopesTestSuite(t *testing.T) {
+	suite.Run(t, new(ScopesTestSuite))
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/CMSgov/bcda-app/bcda/auth/client"
+	"github.com/CMSgov/bcda-app/bcda/database"
+	"github.com/CMSgov/bcda-app/bcda/models"
+)
+
+// SMART Backend Services (https://hl7.org/fhir/uv/bulkdata/authorization/) clients authenticate with a JWT
+// signed by a key they have registered with us, instead of a secret. The tokens we issue them are our own, so
+// the alpha provider must be among those accepted.
+const (
+	ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// SMART Backend Services requires that a client assertion expire no more than five minutes after it is made
+	maxAssertionLifetime = 5 * time.Minute
+)
+
+// RegisterClientKeys registers the JSON Web Key Set that the SMART Backend Services client of the ACO identified by
+// acoID signs its token requests with, replacing any set registered before. It returns the client ID, which is
+// the ACO's client ID, or its UUID if it has none.
+func RegisterClientKeys(acoID string, jwks []byte) (string, error) {
+	if uuid.Parse(acoID) == nil {
+		return "", errors.New("ACO ID must be a UUID")
+	}
+
+	keys, err := client.ParseSigningKeys(jwks)
+	if err != nil {
+		return "", fmt.Errorf("invalid JWKS; %s", err)
+	}
+	if len(keys) == 0 {
+		return "", errors.New("invalid JWKS; no RSA signing keys found")
+	}
+	if _, ok := keys[""]; ok {
+		return "", errors.New("invalid JWKS; every key must have a kid")
+	}
+
+	aco, err := getACOFromDB(acoID)
+	if err != nil {
+		return "", err
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	clientID := aco.ClientID
+	if clientID == "" {
+		clientID = aco.UUID.String()
+		if err = db.Model(&aco).Update("client_id", clientID).Error; err != nil {
+			return "", err
+		}
+		notifyACOChanged(db, aco.UUID.String())
+	}
+
+	var ck ClientKeys
+	err = db.Where(ClientKeys{ClientID: clientID}).Assign(ClientKeys{ACOID: aco.UUID, JWKS: string(jwks)}).FirstOrCreate(&ck).Error
+	if err != nil {
+		return "", fmt.Errorf("unable to save keys for client %s; %s", clientID, err)
+	}
+
+	log.WithField("client_id", clientID).WithField("key_count", len(keys)).Info("client keys registered")
+	return clientID, nil
+}
+
+func clientKeys(clientID string) (map[string]rsa.PublicKey, error) {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var ck ClientKeys
+	if db.First(&ck, "client_id = ?", clientID).RecordNotFound() {
+		return nil, fmt.Errorf("no keys registered for client %s", clientID)
+	}
+	return client.ParseSigningKeys([]byte(ck.JWKS))
+}
+
+// verifyClientAssertion asserts that assertion was signed by a key registered to the client named in its iss and
+// sub claims, is for audience, expires soon, and has not been presented before. It returns the client's ACO.
+func verifyClientAssertion(assertion, audience string) (models.ACO, error) {
+	unverified := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(assertion, unverified); err != nil {
+		return models.ACO{}, err
+	}
+	clientID, _ := unverified["iss"].(string)
+	if sub, _ := unverified["sub"].(string); clientID == "" || sub != clientID {
+		return models.ACO{}, errors.New("iss and sub claims must both be the client ID")
+	}
+
+	keys, err := clientKeys(clientID)
+	if err != nil {
+		return models.ACO{}, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(assertion, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		keyID, _ := token.Header["kid"].(string)
+		key, ok := keys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("no key found with id %s for client %s", keyID, clientID)
+		}
+		return &key, nil
+	})
+	if err != nil {
+		return models.ACO{}, err
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return models.ACO{}, errors.New("missing exp claim")
+	}
+	if time.Unix(int64(exp), 0).After(time.Now().Add(maxAssertionLifetime)) {
+		return models.ACO{}, fmt.Errorf("exp claim is more than %v in the future", maxAssertionLifetime)
+	}
+
+	if !hasAudience(claims, audience) {
+		return models.ACO{}, fmt.Errorf("invalid aud claim; %s not found", audience)
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return models.ACO{}, errors.New("missing jti claim")
+	}
+	if err = useAssertion(clientID, jti, int64(exp)); err != nil {
+		return models.ACO{}, err
+	}
+
+	return GetACOByClientID(clientID)
+}
+
+// useAssertion records that the client has presented the assertion with the given jti, failing if it has been
+// presented already
+func useAssertion(clientID, jti string, expiresOn int64) error {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	// forget assertions that can no longer be presented
+	db.Unscoped().Where("expires_on < ?", time.Now().Unix()).Delete(UsedAssertion{})
+
+	var count int
+	if err := db.Model(&UsedAssertion{}).Where("client_id = ? and jti = ?", clientID, jti).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("client assertion %s has already been used", jti)
+	}
+
+	// the unique index catches a replay that arrives while we are here
+	if err := db.Create(&UsedAssertion{ClientID: clientID, JTI: jti, ExpiresOn: expiresOn}).Error; err != nil {
+		return fmt.Errorf("unable to record client assertion %s; %s", jti, err)
+	}
+	return nil
+}
+
+// issueScopedToken issues an access token, limited to scope, for a SMART Backend Services client of aco. The token
+// is bound to the client certificate with thumbprint, if not empty.
+func issueScopedToken(aco models.ACO, scope, thumbprint string) (Token, error) {
+	now := time.Now()
+	token := Token{
+		UUID:                  uuid.NewRandom(),
+		ACOID:                 aco.UUID,
+		IssuedAt:              now.Unix(),
+		ExpiresOn:             now.Add(TokenTTL).Unix(),
+		Active:                true,
+		Scope:                 scope,
+		CertificateThumbprint: thumbprint,
+	}
+
+	if err := saveToken(token); err != nil {
+		return Token{}, err
+	}
+
+	var err error
+	token.TokenString, err = generateBoundTokenString(token.UUID.String(), token.ACOID.String(), token.Scope, token.CertificateThumbprint, token.IssuedAt, token.ExpiresOn)
+	if err != nil {
+		return Token{}, err
+	}
+	return token, nil
+}
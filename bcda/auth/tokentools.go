@@ -1,7 +1,10 @@
 package auth
 
 import (
+	"errors"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -18,10 +21,11 @@ func init() {
 }
 
 type CommonClaims struct {
-	ClientID string   `json:"cid,omitempty"`
-	Scopes   []string `json:"scp,omitempty"`
-	ACOID    string   `json:"aco,omitempty"`
-	UUID     string   `json:"id,omitempty"`
+	ClientID     string        `json:"cid,omitempty"`
+	Scopes       []string      `json:"scp,omitempty"`
+	ACOID        string        `json:"aco,omitempty"`
+	UUID         string        `json:"id,omitempty"`
+	Confirmation *Confirmation `json:"cnf,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -38,14 +42,108 @@ func TokenStringExpiration(tokenID, acoID string, duration time.Duration) (strin
 
 // GenerateTokenString construct a token string for which all claims are specified in the call.
 func GenerateTokenString(id, acoID string, issuedAt int64, expiresAt int64) (string, error) {
+	return generateScopedTokenString(id, acoID, "", issuedAt, expiresAt)
+}
+
+// generateScopedTokenString constructs a token string limited to the space delimited scopes in scope. Tokens
+// with no scopes are not limited. The token is identified by its jti claim; the id claim repeats it for older
+// consumers.
+func generateScopedTokenString(id, acoID, scope string, issuedAt int64, expiresAt int64) (string, error) {
+	return generateBoundTokenString(id, acoID, scope, "", issuedAt, expiresAt)
+}
+
+// generateBoundTokenString constructs a scoped token string bound, by its cnf claim, to the client certificate with
+// thumbprint. Tokens with no thumbprint are not bound.
+func generateBoundTokenString(id, acoID, scope, thumbprint string, issuedAt int64, expiresAt int64) (string, error) {
+	iss, err := alphaIssuer()
+	if err != nil {
+		return "", err
+	}
+	aud, err := alphaAudience()
+	if err != nil {
+		return "", err
+	}
+
 	token := jwt.New(jwt.SigningMethodRS512)
-	token.Claims = jwt.MapClaims{
+	claims := jwt.MapClaims{
+		"iss": iss,
+		"aud": aud,
 		"exp": expiresAt,
 		"iat": issuedAt,
 		"aco": acoID,
 		"id":  id,
+		"jti": id,
+	}
+	if scope != "" {
+		claims["scp"] = strings.Fields(scope)
+	}
+	if thumbprint != "" {
+		claims["cnf"] = Confirmation{X5TS256: thumbprint}
+	}
+	token.Claims = claims
+	return InitAlphaBackend().SignJwtToken(*token)
+}
+
+// generateAdminTokenString constructs a token for the administrative API. It names no ACO, so it is not accepted by
+// the other APIs.
+func generateAdminTokenString(id, adminClientID string, issuedAt int64, expiresAt int64) (string, error) {
+	iss, err := alphaIssuer()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.New(jwt.SigningMethodRS512)
+	token.Claims = jwt.MapClaims{
+		"iss": iss,
+		"exp": expiresAt,
+		"iat": issuedAt,
+		"sub": adminClientID,
+		"aud": adminAudience,
+		"scp": []string{AdminScope},
+		"jti": id,
+	}
+	return InitAlphaBackend().SignJwtToken(*token)
+}
+
+// The iss and aud claims of alpha tokens name the deployment that issued them and the API they are for, so that a
+// token minted by another deployment that shares the signing key is not accepted. Each deployment names itself with
+// BCDA_TOKEN_ISSUER, or else with the URL of its token endpoint in BCDA_TOKEN_URL; with neither, alpha tokens are not
+// issued or accepted. The audience is BCDA_TOKEN_AUDIENCE, or else the issuer.
+func alphaIssuer() (string, error) {
+	if iss := os.Getenv("BCDA_TOKEN_ISSUER"); iss != "" {
+		return iss, nil
+	}
+	if u := os.Getenv("BCDA_TOKEN_URL"); u != "" {
+		return u, nil
+	}
+	return "", errors.New("no token issuer; set BCDA_TOKEN_ISSUER")
+}
+
+func alphaAudience() (string, error) {
+	if aud := os.Getenv("BCDA_TOKEN_AUDIENCE"); aud != "" {
+		return aud, nil
+	}
+	return alphaIssuer()
+}
+
+// CheckTokenIssuer returns an error if alpha tokens are accepted and the deployment has no name for their iss claim.
+// It warns if the name is taken from BCDA_TOKEN_URL, which may change, and if admin tokens, which are alpha tokens
+// whatever the provider, can't be issued.
+func CheckTokenIssuer() error {
+	iss, err := alphaIssuer()
+	if err != nil {
+		for _, name := range AcceptedProviders() {
+			if name == Alpha {
+				return err
+			}
+		}
+		log.Warnf("%s; admin tokens will not be issued", err)
+		return nil
+	}
+	if os.Getenv("BCDA_TOKEN_ISSUER") == "" {
+		log.Warnf("BCDA_TOKEN_ISSUER is not set; alpha tokens are issued by %s, from BCDA_TOKEN_URL. Set BCDA_TOKEN_ISSUER, so that tokens are not rejected if the URL changes.", iss)
 	}
-	return token.SignedString(InitAlphaBackend().PrivateKey)
+	return nil
 }
 
 // for testing only; we don't support changing the ttl during runtime
@@ -7,6 +7,7 @@ import (
 
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 
 	"github.com/CMSgov/bcda-app/bcda/auth"
@@ -52,6 +53,47 @@ func (s *TokenToolsTestSuite) TestTokenDurationEmptyOverride() {
 	assert.Equal(s.T(), time.Hour, auth.TokenTTL)
 }
 
+func (s *TokenToolsTestSuite) TestTokenClaims() {
+	for _, name := range []string{"BCDA_TOKEN_ISSUER", "BCDA_TOKEN_AUDIENCE", "BCDA_TOKEN_URL"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	claims := func() *auth.CommonClaims {
+		ts, err := auth.TokenStringWithIDs(uuid.NewRandom().String(), uuid.NewRandom().String())
+		require.Nil(s.T(), err)
+		t, err := auth.AlphaAuthPlugin{}.DecodeJWT(ts)
+		require.Nil(s.T(), err)
+		return t.Claims.(*auth.CommonClaims)
+	}
+
+	os.Setenv("BCDA_TOKEN_ISSUER", "bcda-sandbox")
+	os.Setenv("BCDA_TOKEN_AUDIENCE", "bcda-sandbox-api")
+	os.Unsetenv("BCDA_TOKEN_URL")
+	c := claims()
+	assert.Equal(s.T(), "bcda-sandbox", c.Issuer)
+	assert.Equal(s.T(), "bcda-sandbox-api", c.Audience)
+	assert.NotEmpty(s.T(), c.Id)
+	assert.NotEmpty(s.T(), c.ACOID)
+	assert.Nil(s.T(), auth.CheckTokenIssuer())
+
+	// the audience defaults to the issuer
+	os.Unsetenv("BCDA_TOKEN_AUDIENCE")
+	assert.Equal(s.T(), "bcda-sandbox", claims().Audience)
+
+	// which defaults to the deployment's token URL
+	os.Unsetenv("BCDA_TOKEN_ISSUER")
+	os.Setenv("BCDA_TOKEN_URL", "https://sandbox.bcda.example.com/auth/token")
+	c = claims()
+	assert.Equal(s.T(), "https://sandbox.bcda.example.com/auth/token", c.Issuer)
+	assert.Equal(s.T(), "https://sandbox.bcda.example.com/auth/token", c.Audience)
+	assert.Nil(s.T(), auth.CheckTokenIssuer())
+
+	// there is no default shared by every deployment
+	os.Unsetenv("BCDA_TOKEN_URL")
+	_, err := auth.TokenStringWithIDs(uuid.NewRandom().String(), uuid.NewRandom().String())
+	assert.EqualError(s.T(), err, "no token issuer; set BCDA_TOKEN_ISSUER")
+	assert.EqualError(s.T(), auth.CheckTokenIssuer(), "no token issuer; set BCDA_TOKEN_ISSUER")
+}
+
 func (s *TokenToolsTestSuite) TestUnavailableSigner() {
 	acoUUID := "DBBD1CE1-AE24-435C-807D-ED45953077D3"
 	token, err := auth.TokenStringWithIDs(uuid.NewRandom().String(), acoUUID)
@@ -1,16 +1,30 @@
 package main
 
 import (
+	"archive/zip"
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/CMSgov/bcda-app/bcda/auth"
+	"github.com/CMSgov/bcda-app/bcda/database"
 	"github.com/CMSgov/bcda-app/bcda/models"
 )
 
@@ -36,14 +50,223 @@ func createACO(name, cmsID string) (string, error) {
 	return acoUUID.String(), nil
 }
 
+func setEncryptionFormat(acoID, format string) error {
+	if acoID == "" {
+		return errors.New("ACO ID (--aco-id) must be provided")
+	}
+
+	acoUUID := uuid.Parse(acoID)
+	if acoUUID == nil {
+		return errors.New("ACO ID must be a UUID")
+	}
+
+	format = strings.ToLower(format)
+	if format == "bcda" {
+		format = models.EncryptionFormatBCDA
+	}
+	if !models.IsValidEncryptionFormat(format) {
+		return errors.New("invalid argument for --format.  Please use 'bcda' or 'jwe'")
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var aco models.ACO
+	if db.First(&aco, "uuid = ?", acoUUID).RecordNotFound() {
+		return fmt.Errorf("unable to locate ACO with id of %v", acoID)
+	}
+
+	if err := db.Model(&aco).Update("encryption_format", format).Error; err != nil {
+		return err
+	}
+	auth.NotifyACOChanged(aco.UUID.String())
+	return nil
+}
+
+func setAuthProvider(acoID, provider string) error {
+	if acoID == "" {
+		return errors.New("ACO ID (--aco-id) must be provided")
+	}
+
+	acoUUID := uuid.Parse(acoID)
+	if acoUUID == nil {
+		return errors.New("ACO ID must be a UUID")
+	}
+
+	// an ACO enrolled in no provider accepts tokens from any of them
+	provider = strings.ToLower(provider)
+	if provider == "any" {
+		provider = ""
+	} else if !auth.IsValidProvider(provider) {
+		return errors.New("invalid argument for --provider.  Please use 'alpha', 'okta', 'oidc', or 'any'")
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var aco models.ACO
+	if db.First(&aco, "uuid = ?", acoUUID).RecordNotFound() {
+		return fmt.Errorf("unable to locate ACO with id of %v", acoID)
+	}
+
+	if err := db.Model(&aco).Update("auth_provider", provider).Error; err != nil {
+		return err
+	}
+	auth.NotifyACOChanged(aco.UUID.String())
+	return nil
+}
+
+func registerClientKeys(acoID, jwksFile, scope string) (string, error) {
+	if acoID == "" {
+		return "", errors.New("ACO ID (--aco-id) must be provided")
+	}
+	if jwksFile == "" {
+		return "", errors.New("JWKS file path (--jwks) must be provided")
+	}
+
+	if _, err := auth.NormalizeScopes(scope); err != nil {
+		return "", err
+	}
+
+	jwks, err := ioutil.ReadFile(filepath.Clean(jwksFile))
+	if err != nil {
+		return "", err
+	}
+
+	clientID, err := auth.RegisterClientKeys(acoID, jwks)
+	if err != nil {
+		return "", err
+	}
+
+	return clientID, auth.SetClientScope(clientID, scope)
+}
+
+// listAllowedRanges writes the CIDR ranges the credentials of the ACO with acoID work from to w, one per line
+func listAllowedRanges(w io.Writer, acoID string) error {
+	if acoID == "" {
+		return errors.New("ACO ID (--aco-id) must be provided")
+	}
+
+	ranges, err := auth.AllowedRanges(acoID)
+	if err != nil {
+		return err
+	}
+	if len(ranges) == 0 {
+		fmt.Fprintf(w, "ACO %s may use its credentials from any address\n", acoID)
+		return nil
+	}
+	for _, r := range ranges {
+		fmt.Fprintf(w, "%s\n", r)
+	}
+	return nil
+}
+
+// inspectToken writes what support engineers need to know about tokenString: its header and claims, with times
+// made readable; the ACO it was issued to; and the result of each check made in verifying it
+func inspectToken(w io.Writer, tokenString string) error {
+	if tokenString == "" {
+		return errors.New("access token (--token) must be provided")
+	}
+
+	ti, err := auth.InspectToken(tokenString)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Provider: %s\n", ti.Provider)
+	fmt.Fprintln(w, "Header:")
+	for _, k := range sortedKeys(ti.Header) {
+		fmt.Fprintf(w, "  %s: %v\n", k, ti.Header[k])
+	}
+	fmt.Fprintln(w, "Claims:")
+	for _, k := range sortedKeys(ti.Claims) {
+		fmt.Fprintf(w, "  %s: %s\n", k, claimString(k, ti.Claims[k]))
+	}
+
+	if ti.ACO != nil {
+		cmsID := "none"
+		if ti.ACO.CMSID != nil {
+			cmsID = *ti.ACO.CMSID
+		}
+		fmt.Fprintf(w, "ACO: %s (CMS ID %s, UUID %s)\n", ti.ACO.Name, cmsID, ti.ACO.UUID)
+	} else {
+		fmt.Fprintln(w, "ACO: not found")
+	}
+
+	fmt.Fprintln(w, "Checks:")
+	for _, c := range ti.Checks {
+		if c.Err != nil {
+			fmt.Fprintf(w, "  %s: FAILED; %s\n", c.Name, c.Err)
+		} else {
+			fmt.Fprintf(w, "  %s: ok\n", c.Name)
+		}
+	}
+
+	if ti.Err != nil {
+		fmt.Fprintf(w, "Result: token is not accepted; %s\n", ti.Err)
+	} else {
+		fmt.Fprintln(w, "Result: token is accepted")
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// claimString formats a claim value, writing the times in exp, iat and nbf as dates and relative to now
+func claimString(name string, value interface{}) string {
+	seconds, ok := value.(float64)
+	if !ok || (name != "exp" && name != "iat" && name != "nbf") {
+		if b, err := json.Marshal(value); err == nil {
+			return strings.Trim(string(b), `"`)
+		}
+		return fmt.Sprintf("%v", value)
+	}
+
+	t := time.Unix(int64(seconds), 0).UTC()
+	d := time.Until(t).Round(time.Second)
+	if d < 0 {
+		return fmt.Sprintf("%d (%s, %s ago)", int64(seconds), t.Format(time.RFC3339), -d)
+	}
+	return fmt.Sprintf("%d (%s, in %s)", int64(seconds), t.Format(time.RFC3339), d)
+}
+
+// registerClientCertificate registers the PEM encoded TLS client certificate in certFile to authenticate the ACO
+// with acoID, and returns its thumbprint
+func registerClientCertificate(acoID, certFile string) (string, error) {
+	if acoID == "" {
+		return "", errors.New("ACO ID (--aco-id) must be provided")
+	}
+	if certFile == "" {
+		return "", errors.New("certificate file path (--cert) must be provided")
+	}
+
+	certPEM, err := ioutil.ReadFile(filepath.Clean(certFile))
+	if err != nil {
+		return "", err
+	}
+
+	return auth.RegisterClientCertificate(acoID, certPEM)
+}
+
 type cclfFileMetadata struct {
 	env       string
 	acoID     string
 	cclfNum   int
+	perfYear  int
 	timestamp time.Time
 }
 
-func importCCLF8(filePath string) error {
+// importCCLF8 replaces the roster of the ACO whose CMS ID is in the name of the CCLF8 file at filePath with the
+// beneficiaries listed in the file. Unless force is set, a file is imported only once, and only if it is newer than
+// the last CCLF8 file imported for the ACO.
+func importCCLF8(w io.Writer, filePath string, force bool) (err error) {
 	if filePath == "" {
 		return errors.New("file path (--file) must be provided")
 	}
@@ -57,31 +280,68 @@ func importCCLF8(filePath string) error {
 		return errors.New("invalid CCLF8 filename")
 	}
 
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	cclfFile, err := startCCLFImport(db, filePath, fileMetadata, force)
+	if err != nil {
+		return err
+	}
+	defer func() { finishCCLFImport(db, cclfFile, err) }()
+
 	file, err := os.Open(filepath.Clean(filePath))
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	fmt.Printf("File contains %s data for ACO %s at %s.\n", fileMetadata.env, fileMetadata.acoID, fileMetadata.timestamp)
+	fmt.Fprintf(w, "File contains %s data for ACO %s at %s.\n", fileMetadata.env, fileMetadata.acoID, fileMetadata.timestamp)
 
 	const (
 		mbiStart, mbiEnd   = 0, 11
 		hicnStart, hicnEnd = 11, 22
 	)
 
+	var benes []models.Beneficiary
 	sc := bufio.NewScanner(file)
-	for sc.Scan() {
+	for line := 1; sc.Scan(); line++ {
 		b := sc.Bytes()
-		if len(bytes.TrimSpace(b)) > 0 {
-			fmt.Printf("\nMBI: %s\n", b[mbiStart:mbiEnd])
-			fmt.Printf("HICN: %s\n", b[hicnStart:hicnEnd])
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+		if len(b) < hicnEnd {
+			return fmt.Errorf("line %d of %s is too short", line, filePath)
 		}
+		mbi := string(bytes.TrimSpace(b[mbiStart:mbiEnd]))
+		if mbi == "" {
+			return fmt.Errorf("line %d of %s has no MBI", line, filePath)
+		}
+		benes = append(benes, models.Beneficiary{MBI: mbi, HICN: string(bytes.TrimSpace(b[hicnStart:hicnEnd]))})
+	}
+	if err = sc.Err(); err != nil {
+		return err
 	}
+	cclfFile.Records = len(benes)
 
+	var aco models.ACO
+	if db.First(&aco, "cms_id = ?", fileMetadata.acoID).RecordNotFound() {
+		return fmt.Errorf("unable to locate ACO with CMS ID of %s", fileMetadata.acoID)
+	}
+
+	counts, err := models.ReplaceACOBeneficiaries(db, aco.UUID, benes)
+	if err != nil {
+		return fmt.Errorf("unable to replace roster of ACO %s; %s", fileMetadata.acoID, err)
+	}
+	cclfFile.Imported = counts.Added + counts.Unchanged
+
+	fmt.Fprintf(w, "%d beneficiaries added, %d removed, %d unchanged.\n", counts.Added, counts.Removed, counts.Unchanged)
 	return nil
 }
 
-func importCCLF9(filePath string) error {
+// importCCLF9 applies the identifier cross-references in the CCLF9 file at filePath to the beneficiaries they name.
+// Unless force is set, a file is imported only once, and only if it is newer than the last CCLF9 file imported for
+// the ACO.
+func importCCLF9(w io.Writer, filePath string, force bool) (err error) {
 	if filePath == "" {
 		return errors.New("file path (--file) must be provided")
 	}
@@ -95,12 +355,22 @@ func importCCLF9(filePath string) error {
 		return errors.New("invalid CCLF9 filename")
 	}
 
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	cclfFile, err := startCCLFImport(db, filePath, fileMetadata, force)
+	if err != nil {
+		return err
+	}
+	defer func() { finishCCLFImport(db, cclfFile, err) }()
+
 	file, err := os.Open(filepath.Clean(filePath))
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	fmt.Printf("File contains %s data for ACO %s at %s.\n", fileMetadata.env, fileMetadata.acoID, fileMetadata.timestamp)
+	fmt.Fprintf(w, "File contains %s data for ACO %s at %s.\n", fileMetadata.env, fileMetadata.acoID, fileMetadata.timestamp)
 
 	const (
 		currIDStart, currIDEnd               = 1, 12
@@ -109,32 +379,77 @@ func importCCLF9(filePath string) error {
 		prevIDObsDateStart, prevIDObsDateEnd = 33, 43
 	)
 
+	var xrefs []models.IdentifierXref
 	sc := bufio.NewScanner(file)
-	for sc.Scan() {
+	for line := 1; sc.Scan(); line++ {
 		b := sc.Bytes()
-		if len(bytes.TrimSpace(b)) > 0 {
-			fmt.Printf("\nXREF: %s\n", b[0:1])
-			fmt.Printf("Current identifier: %s\n", b[currIDStart:currIDEnd])
-			fmt.Printf("Previous identifier: %s\n", b[prevIDStart:prevIDEnd])
-			fmt.Printf("Previous identifier effective date: %s\n", b[prevIDEffDateStart:prevIDEffDateEnd])
-			fmt.Printf("Previous identifier obsolete date: %s\n", b[prevIDObsDateStart:prevIDObsDateEnd])
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+		if len(b) < prevIDObsDateEnd {
+			return fmt.Errorf("line %d of %s is too short", line, filePath)
+		}
+
+		x := models.IdentifierXref{
+			Current:  string(bytes.TrimSpace(b[currIDStart:currIDEnd])),
+			Previous: string(bytes.TrimSpace(b[prevIDStart:prevIDEnd])),
+		}
+		switch b[0] {
+		case 'M':
+			x.Type = models.IdentifierMBI
+		case 'H':
+			x.Type = models.IdentifierHICN
+		default:
+			return fmt.Errorf("line %d of %s has invalid XREF %q", line, filePath, b[0:1])
 		}
+		if x.EffectiveDate, err = cclfDate(b[prevIDEffDateStart:prevIDEffDateEnd]); err != nil {
+			return fmt.Errorf("line %d of %s has invalid effective date; %s", line, filePath, err)
+		}
+		if x.ObsoleteDate, err = cclfDate(b[prevIDObsDateStart:prevIDObsDateEnd]); err != nil {
+			return fmt.Errorf("line %d of %s has invalid obsolete date; %s", line, filePath, err)
+		}
+		xrefs = append(xrefs, x)
+	}
+	if err = sc.Err(); err != nil {
+		return err
 	}
+	cclfFile.Records = len(xrefs)
 
+	counts, err := models.ApplyIdentifierXrefs(db, xrefs)
+	if err != nil {
+		return fmt.Errorf("unable to apply identifier cross-references; %s", err)
+	}
+	cclfFile.Imported = len(xrefs) - counts.Unmatched
+
+	fmt.Fprintf(w, "%d identifiers updated, %d previous identifiers recorded, %d records matched no beneficiary.\n", counts.Updated, counts.Recorded, counts.Unmatched)
 	return nil
 }
 
+// cclfDate parses a date in a CCLF file, which may be blank
+func cclfDate(b []byte) (*time.Time, error) {
+	s := string(bytes.TrimSpace(b))
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CCLF8/9 filename convention for SSP: P.A****.ACO.ZC*Y**.Dyymmdd.Thhmmsst
+// Prefix: T = test, P = prod; A**** = ACO ID; ZC* = CCLF file number; Y** = performance year
+var cclfFilenameRegexp = regexp.MustCompile(`(T|P)\.(A\d{4})\.ACO\.ZC(8|9)Y(\d{2})\.(D\d{6}\.T\d{6})\d`)
+
 func getCCLFFileMetadata(filePath string) (cclfFileMetadata, error) {
 	var metadata cclfFileMetadata
-	// CCLF8/9 filename convention for SSP: P.A****.ACO.ZC*Y**.Dyymmdd.Thhmmsst
-	// Prefix: T = test, P = prod; A**** = ACO ID; ZC* = CCLF file number; Y** = performance year
-	filenameRegexp := regexp.MustCompile(`(T|P)\.(A\d{4})\.ACO\.ZC(8|9)Y\d{2}\.(D\d{6}\.T\d{6})\d`)
-	filenameMatches := filenameRegexp.FindStringSubmatch(filePath)
-	if len(filenameMatches) < 5 {
+	filenameMatches := cclfFilenameRegexp.FindStringSubmatch(filePath)
+	if len(filenameMatches) < 6 {
 		return metadata, errors.New("invalid filename")
 	}
 
-	filenameDate := filenameMatches[4]
+	filenameDate := filenameMatches[5]
 	t, err := time.Parse("D060102.T150405", filenameDate)
 	if err != nil {
 		return metadata, fmt.Errorf("failed to parse date '%s' from filename", filenameDate)
@@ -145,6 +460,11 @@ func getCCLFFileMetadata(filePath string) (cclfFileMetadata, error) {
 		return metadata, err
 	}
 
+	perfYear, err := strconv.Atoi(filenameMatches[4])
+	if err != nil {
+		return metadata, err
+	}
+
 	if filenameMatches[1] == "T" {
 		metadata.env = "test"
 	} else if filenameMatches[1] == "P" {
@@ -152,8 +472,269 @@ func getCCLFFileMetadata(filePath string) (cclfFileMetadata, error) {
 	}
 
 	metadata.cclfNum = cclfNum
+	metadata.perfYear = 2000 + perfYear
 	metadata.acoID = filenameMatches[2]
 	metadata.timestamp = t
 
 	return metadata, nil
 }
+
+// cclfRefusedError is returned by startCCLFImport for a file that is not wrong, only already imported or out of date
+type cclfRefusedError struct {
+	error
+}
+
+// startCCLFImport records the start of the import of the CCLF file at filePath. Unless force is set, it refuses a
+// file that has already been imported, or that is no newer than the last file of its kind imported for its ACO.
+func startCCLFImport(db *gorm.DB, filePath string, metadata cclfFileMetadata, force bool) (*models.CCLFFile, error) {
+	checksum, err := fileChecksum(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !force {
+		var imported models.CCLFFile
+		if !db.First(&imported, "checksum = ? and status = ?", checksum, models.CCLFStatusCompleted).RecordNotFound() {
+			return nil, cclfRefusedError{fmt.Errorf("%s has already been imported, as %s at %s; use --force to import it again", filepath.Base(filePath), imported.Name, imported.UpdatedAt.Format(time.RFC3339))}
+		}
+
+		var latest models.CCLFFile
+		err = db.Order("timestamp desc").First(&latest, "aco_cms_id = ? and cclf_num = ? and status = ?", metadata.acoID, metadata.cclfNum, models.CCLFStatusCompleted).Error
+		if err == nil && !metadata.timestamp.After(latest.Timestamp) {
+			return nil, cclfRefusedError{fmt.Errorf("%s is no newer than %s, already imported for ACO %s; use --force to import it anyway", filepath.Base(filePath), latest.Name, metadata.acoID)}
+		}
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			return nil, err
+		}
+	}
+
+	cclfFile := models.CCLFFile{
+		Name:            filepath.Base(filePath),
+		ACOCMSID:        metadata.acoID,
+		CCLFNum:         metadata.cclfNum,
+		PerformanceYear: metadata.perfYear,
+		Timestamp:       metadata.timestamp,
+		Env:             metadata.env,
+		Checksum:        checksum,
+		Status:          models.CCLFStatusImporting,
+	}
+	if err = db.Create(&cclfFile).Error; err != nil {
+		return nil, err
+	}
+	return &cclfFile, nil
+}
+
+// finishCCLFImport records the outcome of an import started by startCCLFImport, which failed if err is not nil
+func finishCCLFImport(db *gorm.DB, cclfFile *models.CCLFFile, err error) {
+	cclfFile.Status = models.CCLFStatusCompleted
+	if err != nil {
+		cclfFile.Status = models.CCLFStatusFailed
+	}
+	if err := db.Save(cclfFile).Error; err != nil {
+		log.Errorf("unable to record status of import of %s; %s", cclfFile.Name, err)
+	}
+}
+
+func fileChecksum(filePath string) (string, error) {
+	file, err := os.Open(filepath.Clean(filePath))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// listCCLFImports writes the CCLF files imported, or that failed to import, for the ACO with cmsID, oldest first
+func listCCLFImports(w io.Writer, cmsID string) error {
+	if cmsID == "" {
+		return errors.New("ACO CMS ID (--cms-id) must be provided")
+	}
+
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var files []models.CCLFFile
+	if err := db.Order("created_at, id").Find(&files, "aco_cms_id = ?", cmsID).Error; err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(w, "No CCLF files have been imported for ACO %s\n", cmsID)
+		return nil
+	}
+	for _, f := range files {
+		fmt.Fprintf(w, "%s %s CCLF%d %d %s %s %s %d/%d records\n", f.CreatedAt.UTC().Format(time.RFC3339), f.Name, f.CCLFNum,
+			f.PerformanceYear, f.Env, f.Timestamp.UTC().Format(time.RFC3339), f.Status, f.Imported, f.Records)
+	}
+	return nil
+}
+
+// importCCLFDirectory imports the CCLF files in the directory, or ZIP archive, at path. Files are found by name;
+// others are ignored. For each ACO, its CCLF8 files are imported before its CCLF9 files, and files of each kind
+// oldest first. A file that can't be imported is reported, and the rest of the files are imported anyway.
+func importCCLFDirectory(w io.Writer, path string, force bool) error {
+	if path == "" {
+		return errors.New("directory or ZIP archive path (--directory) must be provided")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	var (
+		files    []string
+		failures []string
+		ignored  int
+	)
+	if info.IsDir() {
+		files, ignored, err = findCCLFFiles(path)
+	} else {
+		var dir string
+		dir, err = ioutil.TempDir("", "cclf")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		files, failures, ignored, err = extractCCLFFiles(path, dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	type cclfFile struct {
+		path     string
+		metadata cclfFileMetadata
+	}
+	var (
+		batch    []cclfFile
+		skipped  []string
+		imported int
+	)
+	for _, f := range files {
+		metadata, err := getCCLFFileMetadata(filepath.Base(f))
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", filepath.Base(f), err))
+			continue
+		}
+		batch = append(batch, cclfFile{f, metadata})
+	}
+	sort.SliceStable(batch, func(i, j int) bool {
+		a, b := batch[i].metadata, batch[j].metadata
+		if a.acoID != b.acoID {
+			return a.acoID < b.acoID
+		}
+		if a.cclfNum != b.cclfNum {
+			return a.cclfNum < b.cclfNum
+		}
+		return a.timestamp.Before(b.timestamp)
+	})
+
+	for _, f := range batch {
+		name := filepath.Base(f.path)
+		fmt.Fprintf(w, "Importing %s\n", name)
+		if f.metadata.cclfNum == 8 {
+			err = importCCLF8(w, f.path, force)
+		} else {
+			err = importCCLF9(w, f.path, force)
+		}
+
+		switch err.(type) {
+		case nil:
+			imported++
+		case cclfRefusedError:
+			skipped = append(skipped, fmt.Sprintf("%s: %s", name, err))
+		default:
+			log.Errorf("unable to import %s; %s", name, err)
+			failures = append(failures, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+
+	fmt.Fprintf(w, "%d CCLF files imported, %d skipped, %d failed; %d other files ignored.\n", imported, len(skipped), len(failures), ignored)
+	for _, s := range skipped {
+		fmt.Fprintf(w, "Skipped %s\n", s)
+	}
+	for _, f := range failures {
+		fmt.Fprintf(w, "Failed %s\n", f)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d CCLF files failed to import", len(failures), len(failures)+len(skipped)+imported)
+	}
+	return nil
+}
+
+// findCCLFFiles returns the paths of the files under dir named as CCLF files are, and the number of other files
+func findCCLFFiles(dir string) (files []string, ignored int, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if cclfFilenameRegexp.MatchString(info.Name()) {
+			files = append(files, path)
+		} else {
+			ignored++
+		}
+		return nil
+	})
+	return files, ignored, err
+}
+
+// extractCCLFFiles extracts the files in the ZIP archive at archivePath that are named as CCLF files are into dir,
+// returning their paths, why any of them could not be extracted, and the number of other files in the archive
+func extractCCLFFiles(archivePath, dir string) (files, failures []string, ignored int, err error) {
+	r, err := zip.OpenReader(filepath.Clean(archivePath))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer r.Close()
+
+	for i, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		// only the name of an entry is used, so that none is written outside of dir
+		name := filepath.Base(f.Name)
+		if !cclfFilenameRegexp.MatchString(name) {
+			ignored++
+			continue
+		}
+
+		// entries in different folders may have the same name
+		path := filepath.Join(dir, strconv.Itoa(i), name)
+		if err := extractZipFile(f, path); err != nil {
+			log.Errorf("unable to extract %s; %s", f.Name, err)
+			failures = append(failures, fmt.Sprintf("%s: unable to extract; %s", name, err))
+			continue
+		}
+		files = append(files, path)
+	}
+	return files, failures, ignored, nil
+}
+
+func extractZipFile(f *zip.File, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	/* #nosec -- CCLF files are large, and come from CMS */
+	if _, err = io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/CMSgov/bcda-app/bcda/auth"
 	"github.com/CMSgov/bcda-app/bcda/database"
 	"github.com/CMSgov/bcda-app/bcda/models"
 	"github.com/CMSgov/bcda-app/bcda/testUtils"
+	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"github.com/urfave/cli"
@@ -104,7 +110,341 @@ func (s *CLITestSuite) TestCreateACO() {
 	buf.Reset()
 }
 
+func (s *CLITestSuite) TestSetEncryptionFormat() {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	buf := new(bytes.Buffer)
+	s.testApp.Writer = buf
+
+	assert := assert.New(s.T())
+
+	acoUUID, err := models.CreateACO("Unit Test ACO JWE", nil)
+	assert.Nil(err)
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+
+	args := []string{"bcda", "set-encryption-format", "--aco-id", acoUUID.String(), "--format", "jwe"}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Contains(buf.String(), "set to jwe")
+	buf.Reset()
+	var aco models.ACO
+	db.First(&aco, "uuid = ?", acoUUID)
+	assert.Equal(models.EncryptionFormatJWE, aco.EncryptionFormat)
+
+	args = []string{"bcda", "set-encryption-format", "--aco-id", acoUUID.String(), "--format", "bcda"}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	buf.Reset()
+	aco = models.ACO{}
+	db.First(&aco, "uuid = ?", acoUUID)
+	assert.Equal(models.EncryptionFormatBCDA, aco.EncryptionFormat)
+
+	// Negative tests
+	args = []string{"bcda", "set-encryption-format", "--format", "jwe"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "ACO ID (--aco-id) must be provided")
+
+	args = []string{"bcda", "set-encryption-format", "--aco-id", "not-a-uuid", "--format", "jwe"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "ACO ID must be a UUID")
+
+	args = []string{"bcda", "set-encryption-format", "--aco-id", acoUUID.String(), "--format", "pgp"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "invalid argument for --format.  Please use 'bcda' or 'jwe'")
+	assert.Equal(0, buf.Len())
+}
+
+func (s *CLITestSuite) TestSetAuthProvider() {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	buf := new(bytes.Buffer)
+	s.testApp.Writer = buf
+
+	assert := assert.New(s.T())
+
+	acoUUID, err := models.CreateACO("Unit Test ACO Auth Provider", nil)
+	assert.Nil(err)
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+
+	args := []string{"bcda", "set-auth-provider", "--aco-id", acoUUID.String(), "--provider", "okta"}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Contains(buf.String(), "set to okta")
+	buf.Reset()
+	var aco models.ACO
+	db.First(&aco, "uuid = ?", acoUUID)
+	assert.Equal("okta", aco.AuthProvider)
+
+	args = []string{"bcda", "set-auth-provider", "--aco-id", acoUUID.String(), "--provider", "any"}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	buf.Reset()
+	aco = models.ACO{}
+	db.First(&aco, "uuid = ?", acoUUID)
+	assert.Equal("", aco.AuthProvider)
+
+	// Negative tests
+	args = []string{"bcda", "set-auth-provider", "--provider", "okta"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "ACO ID (--aco-id) must be provided")
+
+	args = []string{"bcda", "set-auth-provider", "--aco-id", "not-a-uuid", "--provider", "okta"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "ACO ID must be a UUID")
+
+	args = []string{"bcda", "set-auth-provider", "--aco-id", acoUUID.String(), "--provider", "saml"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "invalid argument for --provider.  Please use 'alpha', 'okta', 'oidc', or 'any'")
+
+	args = []string{"bcda", "set-auth-provider", "--aco-id", uuid.NewRandom().String(), "--provider", "okta"}
+	err = s.testApp.Run(args)
+	assert.Contains(err.Error(), "unable to locate ACO")
+	assert.Equal(0, buf.Len())
+}
+
+func (s *CLITestSuite) TestRegisterClientKeys() {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	buf := new(bytes.Buffer)
+	s.testApp.Writer = buf
+
+	assert := assert.New(s.T())
+
+	acoUUID, err := models.CreateACO("Unit Test ACO Client Keys", nil)
+	assert.Nil(err)
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	defer db.Unscoped().Delete(&auth.ClientKeys{}, "aco_id = ?", acoUUID)
+
+	jwks := `{"keys":[{"kty":"RSA","kid":"key-1","use":"sig","alg":"RS384","e":"AQAB","n":"u1SU1LfVLPHCozMxH2Mo4lgOEePzNm0tRgeLezV6ffAt0gunVTLw7onLRnrq0_IzW7yWR7QkrmBL7jTKEn5u-qKhbwKfBstIs-bMY2Zkp18gnTxKLxoS2tFczGkPLPgizskuemMghRniWaoLcyehkd3qqGElvW_VDL5AaWTg0nLVkjRo9z-40RQzuVaE8AkAFmxZzow3x-VJYKdjykkJ0iT9wCS0DRTXu269V264Vf_3jvredZiKRkgwlL9xNAwxXFg0x_XFw005UWVRIkdgcKWTjpBP2dPwVZ4WWC-9aGVd-Gyn1o0CLelf4rEjGoXbAAEgAqeGUxrcIlbjXfbcmw"}]}`
+	f, err := ioutil.TempFile("", "jwks")
+	assert.Nil(err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(jwks)
+	assert.Nil(err)
+	assert.Nil(f.Close())
+
+	args := []string{"bcda", "register-client-keys", "--aco-id", acoUUID.String(), "--jwks", f.Name(), "--scope", "system/Coverage.read"}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Equal(acoUUID.String()+"\n", buf.String())
+	buf.Reset()
+	var aco models.ACO
+	db.First(&aco, "uuid = ?", acoUUID)
+	assert.Equal(acoUUID.String(), aco.ClientID)
+	defer db.Unscoped().Delete(&auth.ClientScope{}, "client_id = ?", aco.ClientID)
+	var keys auth.ClientKeys
+	assert.False(db.First(&keys, "client_id = ?", aco.ClientID).RecordNotFound())
+	assert.JSONEq(jwks, keys.JWKS)
+	var cs auth.ClientScope
+	assert.False(db.First(&cs, "client_id = ?", aco.ClientID).RecordNotFound())
+	assert.Equal("system/Coverage.read", cs.Scope)
+
+	// Negative tests
+	args = []string{"bcda", "register-client-keys", "--jwks", f.Name()}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "ACO ID (--aco-id) must be provided")
+
+	args = []string{"bcda", "register-client-keys", "--aco-id", acoUUID.String()}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "JWKS file path (--jwks) must be provided")
+
+	args = []string{"bcda", "register-client-keys", "--aco-id", "not-a-uuid", "--jwks", f.Name()}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "ACO ID must be a UUID")
+
+	args = []string{"bcda", "register-client-keys", "--aco-id", acoUUID.String(), "--jwks", f.Name(), "--scope", "system/Observation.read"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "invalid scope system/Observation.read; scopes must be system/*.read or system/<resource type>.read")
+
+	assert.Nil(ioutil.WriteFile(f.Name(), []byte(`{"keys":[]}`), 0600))
+	args = []string{"bcda", "register-client-keys", "--aco-id", acoUUID.String(), "--jwks", f.Name()}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "invalid JWKS; no RSA signing keys found")
+	assert.Equal(0, buf.Len())
+}
+
+func (s *CLITestSuite) TestRegisterClientCertificate() {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	buf := new(bytes.Buffer)
+	s.testApp.Writer = buf
+
+	assert := assert.New(s.T())
+
+	acoUUID, err := models.CreateACO("Unit Test ACO Client Certificate", nil)
+	assert.Nil(err)
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	defer db.Unscoped().Delete(&auth.ClientCertificate{}, "aco_id = ?", acoUUID)
+
+	cert, certPEM := testUtils.ClientCertificate("Unit Test ACO Client Certificate", time.Now().Add(time.Hour))
+	f, err := ioutil.TempFile("", "cert")
+	assert.Nil(err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(certPEM)
+	assert.Nil(err)
+	assert.Nil(f.Close())
+
+	thumbprint := auth.CertificateThumbprint(cert)
+	defer db.Unscoped().Delete(&auth.AdminAuditEvent{}, "target = ?", thumbprint)
+
+	args := []string{"bcda", "register-client-certificate", "--aco-id", acoUUID.String(), "--cert", f.Name()}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Equal(thumbprint+"\n", buf.String())
+	buf.Reset()
+	var cc auth.ClientCertificate
+	assert.False(db.First(&cc, "thumbprint = ?", thumbprint).RecordNotFound())
+	assert.Equal(acoUUID.String(), cc.ACOID.String())
+	assert.Equal("CN=Unit Test ACO Client Certificate", cc.Subject)
+	assert.True(cc.Active)
+
+	args = []string{"bcda", "revoke-client-certificate", "--thumbprint", thumbprint}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Equal("Client certificate "+thumbprint+" has been revoked\n", buf.String())
+	buf.Reset()
+	db.First(&cc, "thumbprint = ?", thumbprint)
+	assert.False(cc.Active)
+
+	// Negative tests
+	args = []string{"bcda", "register-client-certificate", "--cert", f.Name()}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "ACO ID (--aco-id) must be provided")
+
+	args = []string{"bcda", "register-client-certificate", "--aco-id", acoUUID.String()}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "certificate file path (--cert) must be provided")
+
+	assert.Nil(ioutil.WriteFile(f.Name(), []byte("not a certificate"), 0600))
+	args = []string{"bcda", "register-client-certificate", "--aco-id", acoUUID.String(), "--cert", f.Name()}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "no PEM encoded certificate found")
+
+	args = []string{"bcda", "revoke-client-certificate", "--thumbprint", thumbprint}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "no active client certificate "+thumbprint)
+	assert.Equal(0, buf.Len())
+}
+
+func (s *CLITestSuite) TestIPRanges() {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	buf := new(bytes.Buffer)
+	s.testApp.Writer = buf
+
+	assert := assert.New(s.T())
+
+	acoUUID, err := models.CreateACO("Unit Test ACO IP Ranges", nil)
+	assert.Nil(err)
+	acoID := acoUUID.String()
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	defer db.Unscoped().Delete(&auth.AllowedRange{}, "aco_id = ?", acoUUID)
+	defer db.Unscoped().Delete(&auth.AdminAuditEvent{}, "target like ?", acoID+" %")
+
+	args := []string{"bcda", "list-ip-ranges", "--aco-id", acoID}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Equal("ACO "+acoID+" may use its credentials from any address\n", buf.String())
+	buf.Reset()
+
+	args = []string{"bcda", "allow-ip-range", "--aco-id", acoID, "--cidr", "203.0.113.0/24"}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Equal("203.0.113.0/24\n", buf.String())
+	buf.Reset()
+
+	args = []string{"bcda", "allow-ip-range", "--aco-id", acoID, "--cidr", "198.51.100.9/32"}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Equal("203.0.113.0/24\n198.51.100.9/32\n", buf.String())
+	buf.Reset()
+
+	args = []string{"bcda", "remove-ip-range", "--aco-id", acoID, "--cidr", "203.0.113.0/24"}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Equal("198.51.100.9/32\n", buf.String())
+	buf.Reset()
+
+	// Negative tests
+	args = []string{"bcda", "list-ip-ranges"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "ACO ID (--aco-id) must be provided")
+
+	args = []string{"bcda", "allow-ip-range", "--aco-id", acoID, "--cidr", "203.0.113.300/24"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "invalid CIDR range 203.0.113.300/24")
+
+	args = []string{"bcda", "remove-ip-range", "--aco-id", acoID, "--cidr", "203.0.113.0/24"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "203.0.113.0/24 is not allowed for ACO "+acoID)
+	assert.Equal(0, buf.Len())
+}
+
+func (s *CLITestSuite) TestInspectToken() {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	buf := new(bytes.Buffer)
+	s.testApp.Writer = buf
+
+	assert := assert.New(s.T())
+
+	cmsID := testUtils.RandomHexID()[0:4]
+	acoUUID, err := models.CreateACO("Unit Test ACO Inspect Token", &cmsID)
+	assert.Nil(err)
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+
+	tokenID := uuid.NewRandom().String()
+	ts, err := auth.TokenStringWithIDs(tokenID, acoUUID.String())
+	assert.Nil(err)
+
+	args := []string{"bcda", "inspect-token", "--token", ts}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	out := buf.String()
+	assert.Contains(out, "Provider: alpha\n")
+	assert.Contains(out, "  alg: RS512\n")
+	assert.Contains(out, "  id: "+tokenID+"\n")
+	assert.Regexp(`  exp: \d+ \(\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ, in [\dhms]+\)\n`, out)
+	assert.Contains(out, "ACO: Unit Test ACO Inspect Token (CMS ID "+cmsID+", UUID "+acoUUID.String()+")\n")
+	assert.Contains(out, "  signature: ok\n")
+	assert.Contains(out, "Result: token is accepted\n")
+	buf.Reset()
+
+	ts, err = auth.TokenStringExpiration(tokenID, acoUUID.String(), -time.Hour)
+	assert.Nil(err)
+	args = []string{"bcda", "inspect-token", "--token", ts}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	out = buf.String()
+	assert.Regexp(`  exp: \d+ \(\S+, 1h0m\d+s ago\)\n`, out)
+	assert.Contains(out, "  signature: ok\n")
+	assert.Contains(out, "  expiry: FAILED; token is expired by 1h0m")
+	assert.Contains(out, "Result: token is not accepted; token is expired by 1h0m")
+	buf.Reset()
+
+	// Negative tests
+	args = []string{"bcda", "inspect-token"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "access token (--token) must be provided")
+
+	args = []string{"bcda", "inspect-token", "--token", "not-a-token"}
+	err = s.testApp.Run(args)
+	assert.Contains(err.Error(), "not a JWT")
+}
+
 func (s *CLITestSuite) TestImportCCLF8() {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	buf := new(bytes.Buffer)
+	s.testApp.Writer = buf
+
 	assert := assert.New(s.T())
 
 	args := []string{"bcda", "import-cclf8"}
@@ -123,12 +463,105 @@ func (s *CLITestSuite) TestImportCCLF8() {
 	err = s.testApp.Run(args)
 	assert.EqualError(err, "invalid filename")
 
+	defer db.Unscoped().Delete(&models.CCLFFile{}, "aco_cms_id = ?", "A0001")
 	args = []string{"bcda", "import-cclf8", "--file", "../shared_files/cclf/T.A0001.ACO.ZC8Y18.D181120.T1000009"}
 	err = s.testApp.Run(args)
+	assert.EqualError(err, "unable to locate ACO with CMS ID of A0001")
+	buf.Reset()
+
+	cmsID := "A0001"
+	acoUUID, err := models.CreateACO("Unit Test ACO CCLF8", &cmsID)
+	assert.Nil(err)
+	mbis := []string{"1A69B98CD30", "1A69B98CD31", "1A69B98CD32", "1A69B98CD33", "1A69B98CD34", "1A69B98CD35", "1A69B98CD99"}
+	defer db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	defer db.Unscoped().Delete(&models.Beneficiary{}, "mbi in (?)", mbis)
+	defer db.Unscoped().Delete(&models.ACOBeneficiary{}, "aco_id = ?", acoUUID)
+
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Equal("File contains test data for ACO A0001 at 2018-11-20 10:00:00 +0000 UTC.\n6 beneficiaries added, 0 removed, 0 unchanged.\n", buf.String())
+	buf.Reset()
+
+	var bene models.Beneficiary
+	assert.Nil(db.First(&bene, "mbi = ?", "1A69B98CD34").Error)
+	assert.Equal("203031405C7", bene.HICN)
+	var count int
+	db.Model(&models.ACOBeneficiary{}).Where("aco_id = ?", acoUUID).Count(&count)
+	assert.Equal(6, count)
+
+	// a file is imported once, unless forced
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "T.A0001.ACO.ZC8Y18.D181120.T1000009 has already been imported, as T.A0001.ACO.ZC8Y18.D181120.T1000009 at "+
+		s.lastCCLFImport("A0001", 8).UpdatedAt.Format(time.RFC3339)+"; use --force to import it again")
+	buf.Reset()
+
+	// as are files older than the last one imported
+	dir, err := ioutil.TempDir("", "cclf")
 	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	older := dir + "/T.A0001.ACO.ZC8Y18.D181119.T1000009"
+	contents, err := ioutil.ReadFile(args[3])
+	assert.Nil(err)
+	assert.Nil(ioutil.WriteFile(older, append(contents, '\n'), 0600))
+	err = s.testApp.Run([]string{"bcda", "import-cclf8", "--file", older})
+	assert.EqualError(err, "T.A0001.ACO.ZC8Y18.D181119.T1000009 is no newer than T.A0001.ACO.ZC8Y18.D181120.T1000009, already imported for ACO A0001; use --force to import it anyway")
+	buf.Reset()
+
+	// importing the same file again changes nothing; beneficiaries no longer in the file are removed
+	other := models.Beneficiary{MBI: "1A69B98CD99", HICN: "203031499A"}
+	assert.Nil(db.Create(&other).Error)
+	assert.Nil(db.Create(&models.ACOBeneficiary{ACOID: acoUUID, BeneficiaryID: other.ID}).Error)
+	err = s.testApp.Run(append(args, "--force"))
+	assert.Nil(err)
+	assert.Contains(buf.String(), "0 beneficiaries added, 1 removed, 6 unchanged.\n")
+	db.Model(&models.ACOBeneficiary{}).Where("aco_id = ?", acoUUID).Count(&count)
+	assert.Equal(6, count)
+	db.Model(&models.Beneficiary{}).Where("mbi in (?)", mbis).Count(&count)
+	assert.Equal(7, count, "beneficiaries are removed from the roster, not deleted")
+	buf.Reset()
+
+	last := s.lastCCLFImport("A0001", 8)
+	assert.Equal(models.CCLFStatusCompleted, last.Status)
+	assert.Equal(2018, last.PerformanceYear)
+	assert.Equal("test", last.Env)
+	assert.Equal(6, last.Records)
+	assert.Equal(6, last.Imported)
+	assert.Len(last.Checksum, 64)
+
+	err = s.testApp.Run([]string{"bcda", "list-cclf-imports", "--cms-id", "A0001"})
+	assert.Nil(err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(lines, 3)
+	assert.Regexp(`^\S+ T.A0001.ACO.ZC8Y18.D181120.T1000009 CCLF8 2018 test 2018-11-20T10:00:00Z Failed 0/6 records$`, lines[0])
+	assert.Regexp(`^\S+ T.A0001.ACO.ZC8Y18.D181120.T1000009 CCLF8 2018 test 2018-11-20T10:00:00Z Completed 6/6 records$`, lines[1])
+	assert.Regexp(`Completed 6/6 records$`, lines[2])
+	buf.Reset()
+
+	err = s.testApp.Run([]string{"bcda", "list-cclf-imports", "--cms-id", "A0000"})
+	assert.Nil(err)
+	assert.Equal("No CCLF files have been imported for ACO A0000\n", buf.String())
+
+	err = s.testApp.Run([]string{"bcda", "list-cclf-imports"})
+	assert.EqualError(err, "ACO CMS ID (--cms-id) must be provided")
+}
+
+// lastCCLFImport returns the record of the last CCLF file of type cclfNum imported for the ACO with cmsID
+func (s *CLITestSuite) lastCCLFImport(cmsID string, cclfNum int) models.CCLFFile {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	var f models.CCLFFile
+	db.Order("id desc").First(&f, "aco_cms_id = ? and cclf_num = ?", cmsID, cclfNum)
+	return f
 }
 
 func (s *CLITestSuite) TestImportCCLF9() {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	buf := new(bytes.Buffer)
+	s.testApp.Writer = buf
+
 	assert := assert.New(s.T())
 
 	args := []string{"bcda", "import-cclf9"}
@@ -147,9 +580,177 @@ func (s *CLITestSuite) TestImportCCLF9() {
 	err = s.testApp.Run(args)
 	assert.EqualError(err, "invalid filename")
 
+	// a beneficiary with a previous HICN, and one with an MBI that has changed three times
+	mbis := []string{"1A69B98CD30", "1A69B98CD32"}
+	a := models.Beneficiary{MBI: mbis[0], HICN: "203031401A"}
+	assert.Nil(db.Create(&a).Error)
+	b := models.Beneficiary{MBI: mbis[1], HICN: "203031403A"}
+	assert.Nil(db.Create(&b).Error)
+	defer db.Unscoped().Delete(&models.Beneficiary{}, "id in (?)", []uint{a.ID, b.ID})
+	defer db.Unscoped().Delete(&models.BeneficiaryIdentifier{}, "beneficiary_id in (?)", []uint{a.ID, b.ID})
+
+	defer db.Unscoped().Delete(&models.CCLFFile{}, "aco_cms_id = ?", "A0001")
 	args = []string{"bcda", "import-cclf9", "--file", "../shared_files/cclf/T.A0001.ACO.ZC9Y18.D181120.T1000010"}
 	err = s.testApp.Run(args)
 	assert.Nil(err)
+	assert.Equal("File contains test data for ACO A0001 at 2018-11-20 10:00:01 +0000 UTC.\n4 identifiers updated, 4 previous identifiers recorded, 2 records matched no beneficiary.\n", buf.String())
+	buf.Reset()
+
+	assert.Nil(db.First(&a, a.ID).Error)
+	assert.Equal("203031401M", a.HICN)
+	assert.Nil(db.First(&b, b.ID).Error)
+	assert.Equal("1A69B98CD35", b.MBI)
+
+	// previous identifiers still find the beneficiary
+	for _, id := range []string{"1A69B98CD32", "1A69B98CD33", "1A69B98CD34", "1A69B98CD35"} {
+		found, err := models.FindBeneficiaryByIdentifier(db, id)
+		assert.Nil(err)
+		assert.Equal(b.ID, found.ID, id)
+	}
+	var previous models.BeneficiaryIdentifier
+	assert.Nil(db.First(&previous, "identifier = ?", "203031401A").Error)
+	assert.Equal(models.IdentifierHICN, previous.Type)
+	assert.Equal("1959-12-31", previous.EffectiveDate.Format("2006-01-02"))
+	assert.Equal("2016-12-31", previous.ObsoleteDate.Format("2006-01-02"))
+
+	// applying the same file again changes nothing
+	err = s.testApp.Run(args)
+	assert.Contains(err.Error(), "has already been imported")
+	err = s.testApp.Run(append(args, "--force"))
+	assert.Nil(err)
+	assert.Contains(buf.String(), "0 identifiers updated, 0 previous identifiers recorded, 2 records matched no beneficiary.\n")
+	assert.Nil(db.First(&b, b.ID).Error)
+	assert.Equal("1A69B98CD35", b.MBI)
+}
+
+func (s *CLITestSuite) TestImportCCLFDirectory() {
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+
+	buf := new(bytes.Buffer)
+	s.testApp.Writer = buf
+
+	assert := assert.New(s.T())
+
+	err := s.testApp.Run([]string{"bcda", "import-cclf-directory"})
+	assert.EqualError(err, "directory or ZIP archive path (--directory) must be provided")
+
+	cmsID := "A0001"
+	acoUUID, err := models.CreateACO("Unit Test ACO CCLF Directory", &cmsID)
+	assert.Nil(err)
+	defer func() {
+		var beneIDs []uint
+		db.Model(&models.ACOBeneficiary{}).Where("aco_id = ?", acoUUID).Pluck("beneficiary_id", &beneIDs)
+		db.Unscoped().Delete(&models.BeneficiaryIdentifier{}, "beneficiary_id in (?)", beneIDs)
+		db.Unscoped().Delete(&models.ACOBeneficiary{}, "aco_id = ?", acoUUID)
+		db.Unscoped().Delete(&models.Beneficiary{}, "id in (?)", beneIDs)
+		db.Unscoped().Delete(&models.CCLFFile{}, "aco_cms_id in (?)", []string{"A0001", "A0002"})
+		db.Unscoped().Delete(&models.ACO{}, "uuid = ?", acoUUID)
+	}()
+
+	cclf8, err := ioutil.ReadFile("../shared_files/cclf/T.A0001.ACO.ZC8Y18.D181120.T1000009")
+	assert.Nil(err)
+	cclf9, err := ioutil.ReadFile("../shared_files/cclf/T.A0001.ACO.ZC9Y18.D181120.T1000010")
+	assert.Nil(err)
+
+	dir, err := ioutil.TempDir("", "cclf")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	// the CCLF9 file comes first in the archive, but the CCLF8 file is imported first; one bad file doesn't stop the
+	// others being imported
+	archive := dir + "/cclf.zip"
+	out, err := os.Create(archive)
+	assert.Nil(err)
+	zw := zip.NewWriter(out)
+	for _, f := range []struct {
+		name     string
+		contents []byte
+	}{
+		{"cclf/T.A0001.ACO.ZC9Y18.D181120.T1000010", cclf9},
+		{"cclf/T.A0001.ACO.ZC8Y18.D181120.T1000009", cclf8},
+		// a file with the same contents as one already imported would be skipped as a duplicate
+		{"cclf/T.A0002.ACO.ZC8Y18.D181120.T1000009", append(append([]byte{}, cclf8...), '\n')},
+		{"cclf/T.A0001.ACO.ZC8Y18.D181399.T1000009", cclf8},
+		{"cclf/README.txt", []byte("not a CCLF file")},
+	} {
+		fw, err := zw.Create(f.name)
+		assert.Nil(err)
+		_, err = fw.Write(f.contents)
+		assert.Nil(err)
+	}
+	assert.Nil(zw.Close())
+	assert.Nil(out.Close())
+
+	err = s.testApp.Run([]string{"bcda", "import-cclf-directory", "--directory", archive})
+	assert.EqualError(err, "2 of 4 CCLF files failed to import")
+	output := buf.String()
+	assert.Contains(output, "6 beneficiaries added, 0 removed, 0 unchanged.\n")
+	assert.True(strings.Index(output, "Importing T.A0001.ACO.ZC8Y18.D181120.T1000009\n") < strings.Index(output, "Importing T.A0001.ACO.ZC9Y18.D181120.T1000010\n"))
+	assert.Contains(output, "2 CCLF files imported, 0 skipped, 2 failed; 1 other files ignored.\n")
+	assert.Contains(output, "Failed T.A0001.ACO.ZC8Y18.D181399.T1000009: failed to parse date")
+	assert.Contains(output, "Failed T.A0002.ACO.ZC8Y18.D181120.T1000009: unable to locate ACO with CMS ID of A0002\n")
+	assert.Equal(models.CCLFStatusCompleted, s.lastCCLFImport("A0001", 8).Status)
+	assert.Equal(models.CCLFStatusCompleted, s.lastCCLFImport("A0001", 9).Status)
+	assert.Equal(models.CCLFStatusFailed, s.lastCCLFImport("A0002", 8).Status)
+	buf.Reset()
+
+	// files already imported are skipped, unless forced
+	assert.Nil(os.Mkdir(dir+"/cclf", 0700))
+	assert.Nil(ioutil.WriteFile(dir+"/cclf/T.A0001.ACO.ZC8Y18.D181120.T1000009", cclf8, 0600))
+	assert.Nil(ioutil.WriteFile(dir+"/cclf/T.A0001.ACO.ZC9Y18.D181120.T1000010", cclf9, 0600))
+	err = s.testApp.Run([]string{"bcda", "import-cclf-directory", "--directory", dir + "/cclf"})
+	assert.Nil(err)
+	output = buf.String()
+	assert.Contains(output, "0 CCLF files imported, 2 skipped, 0 failed; 0 other files ignored.\n")
+	assert.Contains(output, "Skipped T.A0001.ACO.ZC8Y18.D181120.T1000009: T.A0001.ACO.ZC8Y18.D181120.T1000009 has already been imported")
+	buf.Reset()
+
+	err = s.testApp.Run([]string{"bcda", "import-cclf-directory", "--directory", dir + "/cclf", "--force"})
+	assert.Nil(err)
+	output = buf.String()
+	assert.Contains(output, "0 beneficiaries added, 0 removed, 6 unchanged.\n")
+	assert.Contains(output, "2 CCLF files imported, 0 skipped, 0 failed; 0 other files ignored.\n")
+	buf.Reset()
+
+	err = s.testApp.Run([]string{"bcda", "import-cclf-directory", "--directory", dir + "/missing"})
+	assert.NotNil(err)
+}
+
+func (s *CLITestSuite) TestExtractCCLFFiles() {
+	assert := assert.New(s.T())
+
+	dir, err := ioutil.TempDir("", "cclf")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	// an entry whose contents don't match its checksum can't be extracted, but the others still are
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, name := range []string{"T.A0001.ACO.ZC8Y18.D181120.T1000009", "T.A0001.ACO.ZC9Y18.D181120.T1000010", "README.txt"} {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: "cclf/" + name, Method: zip.Store})
+		assert.Nil(err)
+		_, err = fw.Write([]byte("contents of " + name))
+		assert.Nil(err)
+	}
+	assert.Nil(zw.Close())
+	corrupt := bytes.Replace(archive.Bytes(), []byte("contents of T.A0001.ACO.ZC8Y18"), []byte("CONTENTS OF T.A0001.ACO.ZC8Y18"), 1)
+	archivePath := dir + "/cclf.zip"
+	assert.Nil(ioutil.WriteFile(archivePath, corrupt, 0600))
+
+	files, failures, ignored, err := extractCCLFFiles(archivePath, dir+"/extracted")
+	assert.Nil(err)
+	assert.Len(files, 1)
+	assert.Equal("T.A0001.ACO.ZC9Y18.D181120.T1000010", filepath.Base(files[0]))
+	contents, err := ioutil.ReadFile(files[0])
+	assert.Nil(err)
+	assert.Equal("contents of T.A0001.ACO.ZC9Y18.D181120.T1000010", string(contents))
+	assert.Len(failures, 1)
+	assert.Contains(failures[0], "T.A0001.ACO.ZC8Y18.D181120.T1000009: unable to extract; zip: checksum error")
+	assert.Equal(1, ignored)
+
+	_, _, _, err = extractCCLFFiles(dir+"/missing.zip", dir+"/extracted")
+	assert.NotNil(err)
 }
 
 func (s *CLITestSuite) TestGetCCLFFileMetadata() {
@@ -166,6 +767,7 @@ func (s *CLITestSuite) TestGetCCLFFileMetadata() {
 	assert.Equal("test", metadata.env)
 	assert.Equal("A0000", metadata.acoID)
 	assert.Equal(8, metadata.cclfNum)
+	assert.Equal(2018, metadata.perfYear)
 	assert.Equal(expTime, metadata.timestamp)
 	assert.Nil(err)
 
@@ -7,6 +7,7 @@ import (
 	"crypto/rsa"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -72,7 +73,9 @@ func EncryptBytes(publicKey *rsa.PublicKey, plaintext []byte, label string) (cip
 	}
 }
 
-func EncryptAndMove(fromPath, toPath, fileName string, key *rsa.PublicKey, jobID uint) error {
+// EncryptAndMove encrypts a file in the format requested (one of the models.EncryptionFormat values), saving
+// its encrypted key as a JobKey for the job.
+func EncryptAndMove(fromPath, toPath, fileName string, key *rsa.PublicKey, jobID uint, format string) error {
 	// Open and read the file
 	/*#nosec*/
 	fileBytes, err := ioutil.ReadFile(fromPath + "/" + fileName)
@@ -81,7 +84,15 @@ func EncryptAndMove(fromPath, toPath, fileName string, key *rsa.PublicKey, jobID
 		return err
 	}
 	// Encrypt the file and get the encrypted key
-	encryptedFile, encryptedKey, err := EncryptBytes(key, fileBytes, fileName)
+	var encryptedFile, encryptedKey []byte
+	switch format {
+	case models.EncryptionFormatBCDA:
+		encryptedFile, encryptedKey, err = EncryptBytes(key, fileBytes, fileName)
+	case models.EncryptionFormatJWE:
+		encryptedFile, encryptedKey, err = EncryptBytesJWE(key, fileBytes)
+	default:
+		err = fmt.Errorf("unsupported encryption format %s", format)
+	}
 	if err != nil {
 		log.Error(err)
 		return err
@@ -90,7 +101,7 @@ func EncryptAndMove(fromPath, toPath, fileName string, key *rsa.PublicKey, jobID
 	// Save the encrypted key before trying anything dangerous
 	db := database.GetGORMDbConnection()
 	defer database.Close(db)
-	err = db.Create(&models.JobKey{JobID: jobID, EncryptedKey: encryptedKey, FileName: fileName}).Error
+	err = db.Create(&models.JobKey{JobID: jobID, EncryptedKey: encryptedKey, FileName: fileName, EncryptionFormat: format}).Error
 	if err != nil {
 		log.Error(err)
 		return err
@@ -84,7 +84,7 @@ func (s *EncryptionTestSuite) TestEncryptAndMove() {
 	}
 	s.db.Save(&j)
 	// Do the Encrypt and Move
-	err := EncryptAndMove(fromPath, toPath, fileName, models.GetATOPublicKey(), j.ID)
+	err := EncryptAndMove(fromPath, toPath, fileName, models.GetATOPublicKey(), j.ID, models.EncryptionFormatBCDA)
 	// No Errors
 	assert.Nil(s.T(), err)
 	// Should have some Job Keys
@@ -0,0 +1,126 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// JSON Web Encryption (RFC 7516) output for ACOs that would rather use a standard JOSE library than the
+// decryption_utils.  The payload is encrypted with A256GCM under a random content encryption key and written
+// as a flattened JWE JSON serialization using direct key agreement ("alg": "dir").  The content encryption key
+// is delivered separately, as an oct JWK wrapped in a compact JWE using RSA-OAEP-256 and A256GCM.  Decrypting
+// the compact JWE with the ACO's private key yields the JWK needed to decrypt the file.
+
+type jweHeader struct {
+	Algorithm   string `json:"alg"`
+	Encryption  string `json:"enc"`
+	ContentType string `json:"cty,omitempty"`
+}
+
+// jweJSON is the flattened JWE JSON serialization described in RFC 7516 section 7.2.2
+type jweJSON struct {
+	Protected    string `json:"protected"`
+	EncryptedKey string `json:"encrypted_key,omitempty"`
+	IV           string `json:"iv"`
+	Ciphertext   string `json:"ciphertext"`
+	Tag          string `json:"tag"`
+}
+
+type octJWK struct {
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg"`
+	K         string `json:"k"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// EncryptBytesJWE encrypts plaintext for the holder of the private key matching publicKey.  The returned
+// ciphertext is a flattened JWE JSON serialization of plaintext; encryptedKey is a compact JWE containing the
+// content encryption key needed to decrypt it.
+func EncryptBytesJWE(publicKey *rsa.PublicKey, plaintext []byte) (ciphertext []byte, encryptedKey []byte, err error) {
+	// 64GB is the max file size we can do.  64*1024^3 = 64 GB
+	if len(plaintext) > 64*1024*1024*1024 {
+		return nil, nil, errors.New("Max File size of 64 GB exceeded.  Unable to encrypt file.")
+	}
+	if publicKey == nil {
+		return nil, nil, errors.New("a public key is required")
+	}
+
+	symmetricKey := newEncryptionKey()
+	payload, err := sealJWE(jweHeader{Algorithm: "dir", Encryption: "A256GCM"}, symmetricKey, nil, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err = json.Marshal(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	jwk, err := json.Marshal(octJWK{KeyType: "oct", Algorithm: "A256GCM", K: b64.EncodeToString(symmetricKey[:])})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The JWK is wrapped under its own content encryption key, as RSA-OAEP-256 with A256GCM requires
+	wrappingKey := newEncryptionKey()
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, wrappingKey[:], nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyJWE, err := sealJWE(jweHeader{Algorithm: "RSA-OAEP-256", Encryption: "A256GCM", ContentType: "jwk+json"}, wrappingKey, wrappedKey, jwk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ciphertext, []byte(keyJWE.compact()), nil
+}
+
+// sealJWE encrypts plaintext with A256GCM under cek, using the encoded protected header as additional
+// authenticated data as RFC 7516 section 5.1 requires.
+func sealJWE(header jweHeader, cek *[32]byte, encryptedKey []byte, plaintext []byte) (jweJSON, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return jweJSON{}, err
+	}
+	protected := b64.EncodeToString(h)
+
+	block, err := aes.NewCipher(cek[:])
+	if err != nil {
+		return jweJSON{}, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return jweJSON{}, err
+	}
+
+	iv := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, iv)
+	if err != nil {
+		return jweJSON{}, err
+	}
+
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(protected))
+	tagStart := len(sealed) - gcm.Overhead()
+
+	return jweJSON{
+		Protected:    protected,
+		EncryptedKey: b64.EncodeToString(encryptedKey),
+		IV:           b64.EncodeToString(iv),
+		Ciphertext:   b64.EncodeToString(sealed[:tagStart]),
+		Tag:          b64.EncodeToString(sealed[tagStart:]),
+	}, nil
+}
+
+// compact returns the JWE compact serialization described in RFC 7516 section 7.1
+func (j jweJSON) compact() string {
+	return strings.Join([]string{j.Protected, j.EncryptedKey, j.IV, j.Ciphertext, j.Tag}, ".")
+}
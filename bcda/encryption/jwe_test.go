@@ -0,0 +1,118 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type JWETestSuite struct {
+	suite.Suite
+	privateKey *rsa.PrivateKey
+}
+
+func (s *JWETestSuite) SetupSuite() {
+	var err error
+	s.privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		s.FailNow(err.Error())
+	}
+}
+
+func (s *JWETestSuite) TestEncryptBytesJWE() {
+	testBytes := []byte(uuid.NewRandom().String())
+	ciphertext, encryptedKey, err := EncryptBytesJWE(&s.privateKey.PublicKey, testBytes)
+	assert.Nil(s.T(), err)
+
+	// The key is a compact JWE wrapping an oct JWK
+	parts := strings.Split(string(encryptedKey), ".")
+	assert.Len(s.T(), parts, 5)
+	keyJWE := jweJSON{Protected: parts[0], EncryptedKey: parts[1], IV: parts[2], Ciphertext: parts[3], Tag: parts[4]}
+	header := s.header(keyJWE)
+	assert.Equal(s.T(), "RSA-OAEP-256", header.Algorithm)
+	assert.Equal(s.T(), "A256GCM", header.Encryption)
+	assert.Equal(s.T(), "jwk+json", header.ContentType)
+
+	wrappedKey, err := b64.DecodeString(keyJWE.EncryptedKey)
+	assert.Nil(s.T(), err)
+	wrappingKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, s.privateKey, wrappedKey, nil)
+	assert.Nil(s.T(), err)
+	jwkBytes := s.open(keyJWE, wrappingKey)
+	var jwk octJWK
+	assert.Nil(s.T(), json.Unmarshal(jwkBytes, &jwk))
+	assert.Equal(s.T(), "oct", jwk.KeyType)
+	symmetricKey, err := b64.DecodeString(jwk.K)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), symmetricKey, 32)
+
+	// The payload is a flattened JSON serialization using the key directly
+	var payload jweJSON
+	assert.Nil(s.T(), json.Unmarshal(ciphertext, &payload))
+	assert.Empty(s.T(), payload.EncryptedKey)
+	header = s.header(payload)
+	assert.Equal(s.T(), "dir", header.Algorithm)
+	assert.Equal(s.T(), "A256GCM", header.Encryption)
+	assert.Equal(s.T(), testBytes, s.open(payload, symmetricKey))
+}
+
+func (s *JWETestSuite) TestEncryptBytesJWETampered() {
+	ciphertext, _, err := EncryptBytesJWE(&s.privateKey.PublicKey, []byte("tamper with me"))
+	assert.Nil(s.T(), err)
+
+	var payload jweJSON
+	assert.Nil(s.T(), json.Unmarshal(ciphertext, &payload))
+	payload.Protected = b64.EncodeToString([]byte(`{"alg":"dir","enc":"A128GCM"}`))
+
+	key := newEncryptionKey()
+	_, err = s.decrypt(payload, key[:])
+	assert.NotNil(s.T(), err)
+}
+
+func (s *JWETestSuite) TestEncryptBytesJWENoKey() {
+	ciphertext, encryptedKey, err := EncryptBytesJWE(nil, []byte("no key"))
+	assert.Nil(s.T(), ciphertext)
+	assert.Nil(s.T(), encryptedKey)
+	assert.EqualError(s.T(), err, "a public key is required")
+}
+
+func (s *JWETestSuite) header(j jweJSON) jweHeader {
+	var h jweHeader
+	b, err := b64.DecodeString(j.Protected)
+	assert.Nil(s.T(), err)
+	assert.Nil(s.T(), json.Unmarshal(b, &h))
+	return h
+}
+
+func (s *JWETestSuite) open(j jweJSON, key []byte) []byte {
+	plaintext, err := s.decrypt(j, key)
+	assert.Nil(s.T(), err)
+	return plaintext
+}
+
+func (s *JWETestSuite) decrypt(j jweJSON, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	iv, _ := b64.DecodeString(j.IV)
+	ct, _ := b64.DecodeString(j.Ciphertext)
+	tag, _ := b64.DecodeString(j.Tag)
+	return gcm.Open(nil, iv, append(ct, tag...), []byte(j.Protected))
+}
+
+func TestJWETestSuite(t *testing.T) {
+	suite.Run(t, new(JWETestSuite))
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/CMSgov/bcda-app/bcda/utils"
 
 	"github.com/CMSgov/bcda-app/bcda/auth"
+	"github.com/CMSgov/bcda-app/bcda/auth/client"
 	"github.com/CMSgov/bcda-app/bcda/database"
 	"github.com/CMSgov/bcda-app/bcda/models"
 	"github.com/CMSgov/bcda-app/bcda/monitoring"
@@ -71,12 +72,17 @@ func setUpApp() *cli.App {
 	app.Name = Name
 	app.Usage = Usage
 	app.Version = version
-	var acoName, acoCMSID, acoID, userName, userEmail, tokenID, tokenSecret, accessToken, ttl, threshold, acoSize, filePath string
+	var acoName, acoCMSID, acoID, userName, userEmail, tokenID, tokenSecret, accessToken, ttl, threshold, acoSize, filePath, encryptionFormat, reason, authProvider, scope, port, serverID, apiToken, address, cidr string
+	var force bool
 	app.Commands = []cli.Command{
 		{
 			Name:  "start-api",
 			Usage: "Start the API",
 			Action: func(c *cli.Context) error {
+				if err := auth.CheckTokenIssuer(); err != nil {
+					return err
+				}
+
 				// Worker queue connection
 				queueDatabaseURL := os.Getenv("QUEUE_DATABASE_URL")
 				pgxcfg, err := pgx.ParseURI(queueDatabaseURL)
@@ -139,6 +145,45 @@ func setUpApp() *cli.App {
 				return nil
 			},
 		},
+		{
+			Name:  "start-mokta",
+			Usage: "Start a fake Okta server, for running the okta auth provider without a connection to Okta",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "port",
+					Usage:       "Port to listen on",
+					Value:       "3002",
+					Destination: &port,
+				},
+				cli.StringFlag{
+					Name:        "server-id",
+					Usage:       "ID of the authorization server; set OKTA_OAUTH_SERVER_ID to match",
+					Value:       "mokta",
+					Destination: &serverID,
+				},
+				cli.StringFlag{
+					Name:        "api-token",
+					Usage:       "Token the admin APIs accept; set OKTA_CLIENT_TOKEN to match",
+					Value:       "mokta",
+					Destination: &apiToken,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				mokta, err := client.NewMoktaServer(serverID, apiToken)
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(app.Writer, "Starting mokta on port %s...\n", port)
+				srv := &http.Server{
+					Handler:      mokta.Handler(),
+					Addr:         ":" + port,
+					ReadTimeout:  10 * time.Second,
+					WriteTimeout: 10 * time.Second,
+				}
+				return srv.ListenAndServe()
+			},
+		},
 		{
 			Name:     "create-aco",
 			Category: "Authentication tools",
@@ -164,6 +209,271 @@ func setUpApp() *cli.App {
 				return nil
 			},
 		},
+		{
+			Name:     "set-encryption-format",
+			Category: "Authentication tools",
+			Usage:    "Choose how an ACO's export files are encrypted",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "aco-id",
+					Usage:       "UUID of ACO",
+					Destination: &acoID,
+				},
+				cli.StringFlag{
+					Name:        "format",
+					Usage:       "Encryption format.  Must be one of 'bcda' or 'jwe'",
+					Destination: &encryptionFormat,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				err := setEncryptionFormat(acoID, encryptionFormat)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "Encryption format for ACO %s set to %s\n", acoID, encryptionFormat)
+				return nil
+			},
+		},
+		{
+			Name:     "set-auth-provider",
+			Category: "Authentication tools",
+			Usage:    "Enroll an ACO in the auth provider whose tokens it will use",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "aco-id",
+					Usage:       "UUID of ACO",
+					Destination: &acoID,
+				},
+				cli.StringFlag{
+					Name:        "provider",
+					Usage:       "Auth provider.  Must be one of 'alpha', 'okta', 'oidc', or 'any'",
+					Destination: &authProvider,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				err := setAuthProvider(acoID, authProvider)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "Auth provider for ACO %s set to %s\n", acoID, authProvider)
+				return nil
+			},
+		},
+		{
+			Name:     "register-client-keys",
+			Category: "Authentication tools",
+			Usage:    "Register the public keys an ACO's SMART Backend Services client signs token requests with",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "aco-id",
+					Usage:       "UUID of ACO",
+					Destination: &acoID,
+				},
+				cli.StringFlag{
+					Name:        "jwks",
+					Usage:       "Path to a file holding the client's JSON Web Key Set",
+					Destination: &filePath,
+				},
+				cli.StringFlag{
+					Name:        "scope",
+					Usage:       "Space delimited scopes the client's tokens are limited to, such as 'system/Coverage.read'",
+					Destination: &scope,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				clientID, err := registerClientKeys(acoID, filePath, scope)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "%s\n", clientID)
+				return nil
+			},
+		},
+		{
+			Name:     "register-client-certificate",
+			Category: "Authentication tools",
+			Usage:    "Register a TLS client certificate that authenticates an ACO's requests in place of a token",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "aco-id",
+					Usage:       "UUID of ACO",
+					Destination: &acoID,
+				},
+				cli.StringFlag{
+					Name:        "cert",
+					Usage:       "Path to a file holding the PEM encoded certificate",
+					Destination: &filePath,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				thumbprint, err := registerClientCertificate(acoID, filePath)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "%s\n", thumbprint)
+				return nil
+			},
+		},
+		{
+			Name:     "revoke-client-certificate",
+			Category: "Authentication tools",
+			Usage:    "Stop a TLS client certificate from authenticating its ACO",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "thumbprint",
+					Usage:       "Thumbprint of the certificate, as output by register-client-certificate",
+					Destination: &tokenID,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if err := auth.RevokeClientCertificate(tokenID); err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "Client certificate %s has been revoked\n", tokenID)
+				return nil
+			},
+		},
+		{
+			Name:     "allow-ip-range",
+			Category: "Authentication tools",
+			Usage:    "Limit the addresses an ACO's credentials work from, adding a range to those allowed",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "aco-id",
+					Usage:       "UUID of ACO",
+					Destination: &acoID,
+				},
+				cli.StringFlag{
+					Name:        "cidr",
+					Usage:       "CIDR range of addresses, such as 203.0.113.0/24",
+					Destination: &cidr,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if err := auth.AddAllowedRange(acoID, cidr); err != nil {
+					return err
+				}
+				return listAllowedRanges(app.Writer, acoID)
+			},
+		},
+		{
+			Name:     "remove-ip-range",
+			Category: "Authentication tools",
+			Usage:    "Remove a range from the addresses an ACO's credentials work from; removing the last lifts the limit",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "aco-id",
+					Usage:       "UUID of ACO",
+					Destination: &acoID,
+				},
+				cli.StringFlag{
+					Name:        "cidr",
+					Usage:       "CIDR range of addresses, as given to allow-ip-range",
+					Destination: &cidr,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if err := auth.RemoveAllowedRange(acoID, cidr); err != nil {
+					return err
+				}
+				return listAllowedRanges(app.Writer, acoID)
+			},
+		},
+		{
+			Name:     "list-ip-ranges",
+			Category: "Authentication tools",
+			Usage:    "List the ranges of addresses an ACO's credentials work from",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "aco-id",
+					Usage:       "UUID of ACO",
+					Destination: &acoID,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return listAllowedRanges(app.Writer, acoID)
+			},
+		},
+		{
+			Name:     "create-admin-client",
+			Category: "Authentication tools",
+			Usage:    "Create a credential for the administrative API",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "name",
+					Usage:       "Name of the administrator or service that will use the credential",
+					Destination: &userName,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				creds, err := auth.CreateAdminClient(userName)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "%s\n%s\n%s\n", creds.ClientName, creds.ClientID, creds.ClientSecret)
+				return nil
+			},
+		},
+		{
+			Name:     "deactivate-admin-client",
+			Category: "Authentication tools",
+			Usage:    "Disable a credential for the administrative API",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "client-id",
+					Usage:       "ID of the admin client",
+					Destination: &tokenID,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				err := auth.DeactivateAdminClient(tokenID)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "Admin client %s has been deactivated\n", tokenID)
+				return nil
+			},
+		},
+		{
+			Name:     "create-resource-server",
+			Category: "Authentication tools",
+			Usage:    "Create a credential for a service that introspects tokens at /auth/introspect",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "name",
+					Usage:       "Name of the service that will use the credential",
+					Destination: &userName,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				creds, err := auth.CreateResourceServer(userName)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "%s\n%s\n%s\n", creds.ClientName, creds.ClientID, creds.ClientSecret)
+				return nil
+			},
+		},
+		{
+			Name:     "deactivate-resource-server",
+			Category: "Authentication tools",
+			Usage:    "Disable a credential for token introspection",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "client-id",
+					Usage:       "ID of the resource server",
+					Destination: &tokenID,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				err := auth.DeactivateResourceServer(tokenID)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "Resource server %s has been deactivated\n", tokenID)
+				return nil
+			},
+		},
 		{
 			Name:     "create-user",
 			Category: "Authentication tools",
@@ -227,9 +537,14 @@ func setUpApp() *cli.App {
 					Usage:       "Access token",
 					Destination: &accessToken,
 				},
+				cli.StringFlag{
+					Name:        "reason",
+					Usage:       "Why the token is being revoked",
+					Destination: &reason,
+				},
 			},
 			Action: func(c *cli.Context) error {
-				err := revokeAccessToken(accessToken)
+				err := revokeAccessToken(accessToken, reason)
 				if err != nil {
 					return err
 				}
@@ -237,6 +552,46 @@ func setUpApp() *cli.App {
 				return nil
 			},
 		},
+		{
+			Name:     "inspect-token",
+			Category: "Authentication tools",
+			Usage:    "Decode an access token and explain whether, and why not, it is accepted",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "token",
+					Usage:       "Access token",
+					Destination: &accessToken,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return inspectToken(app.Writer, accessToken)
+			},
+		},
+		{
+			Name:     "revoke-aco-tokens",
+			Category: "Authentication tools",
+			Usage:    "Revoke every access token issued to an ACO",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "aco-id",
+					Usage:       "UUID of ACO",
+					Destination: &acoID,
+				},
+				cli.StringFlag{
+					Name:        "reason",
+					Usage:       "Why the tokens are being revoked",
+					Destination: &reason,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				n, err := revokeACOTokens(acoID, reason)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "%d access tokens for ACO %s have been deactivated\n", n, acoID)
+				return nil
+			},
+		},
 		{
 			Name:     "create-alpha-token",
 			Category: "Alpha tools",
@@ -252,6 +607,11 @@ func setUpApp() *cli.App {
 					Usage:       "Set the size of the ACO.  Must be one of 'Dev', 'Small', 'Medium', 'Large', or 'Extra_Large'",
 					Destination: &acoSize,
 				},
+				cli.StringFlag{
+					Name:        "scope",
+					Usage:       "Space delimited scopes the client's tokens are limited to, such as 'system/Coverage.read'",
+					Destination: &scope,
+				},
 			},
 			Action: func(c *cli.Context) error {
 				if ttl == "" {
@@ -264,7 +624,7 @@ func setUpApp() *cli.App {
 				if err != nil {
 					return err
 				}
-				accessToken, err := createAlphaToken(ttlInt, acoSize)
+				accessToken, err := createAlphaToken(ttlInt, acoSize, scope)
 				if err != nil {
 					return err
 				}
@@ -272,6 +632,73 @@ func setUpApp() *cli.App {
 				return nil
 			},
 		},
+		{
+			Name:     "generate-client-credentials",
+			Category: "Authentication tools",
+			Usage:    "Replace a client's secret",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "client-id",
+					Usage:       "ID of the client",
+					Destination: &tokenID,
+				},
+				cli.StringFlag{
+					Name:        "ttl",
+					Usage:       "Hours until the new secret expires; 0, the default, for never",
+					Value:       "0",
+					Destination: &ttl,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				ttlInt, err := strconv.Atoi(ttl)
+				if err != nil || ttlInt < 0 {
+					return fmt.Errorf("invalid argument '%s' for --ttl; should be an integer >= 0", ttl)
+				}
+				creds, err := auth.GetProvider().GenerateClientCredentials(tokenID, ttlInt)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "%s\n%s\n", creds.ClientID, creds.ClientSecret)
+				return nil
+			},
+		},
+		{
+			Name:     "deactivate-expired-credentials",
+			Category: "Alpha tools",
+			Usage:    "Remove expired alpha client secrets and revoke the tokens made with them",
+			Action: func(c *cli.Context) error {
+				n, err := auth.DeactivateExpiredAlphaCredentials()
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "%d expired client credentials deactivated\n", n)
+				return nil
+			},
+		},
+		{
+			Name:     "unlock-client",
+			Category: "Authentication tools",
+			Usage:    "End the lockout of a client or source address after repeated failed token requests",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "client-id",
+					Usage:       "ID of the client",
+					Destination: &tokenID,
+				},
+				cli.StringFlag{
+					Name:        "address",
+					Usage:       "IP address the requests came from",
+					Destination: &address,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if err := auth.Unlock(tokenID, address); err != nil {
+					return err
+				}
+				fmt.Fprintf(app.Writer, "%s\n", "Unlocked")
+				return nil
+			},
+		},
 		{
 			Name:     "sql-migrate",
 			Category: "Database tools",
@@ -312,31 +739,76 @@ func setUpApp() *cli.App {
 		{
 			Name:     "import-cclf8",
 			Category: "Data import",
-			Usage:    "Import data from a CCLF8 file",
+			Usage:    "Replace an ACO's roster with the beneficiaries in a CCLF8 file",
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:        "file",
 					Usage:       "Path to CCLF8 file",
 					Destination: &filePath,
 				},
+				cli.BoolFlag{
+					Name:        "force",
+					Usage:       "Import the file even if it has been imported before, or is older than the last CCLF8 file imported",
+					Destination: &force,
+				},
 			},
 			Action: func(c *cli.Context) error {
-				return importCCLF8(filePath)
+				return importCCLF8(app.Writer, filePath, force)
 			},
 		},
 		{
 			Name:     "import-cclf9",
 			Category: "Data import",
-			Usage:    "Import data from a CCLF9 file",
+			Usage:    "Apply the beneficiary identifier changes in a CCLF9 file",
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:        "file",
 					Usage:       "Path to CCLF9 file",
 					Destination: &filePath,
 				},
+				cli.BoolFlag{
+					Name:        "force",
+					Usage:       "Import the file even if it has been imported before, or is older than the last CCLF9 file imported",
+					Destination: &force,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return importCCLF9(app.Writer, filePath, force)
+			},
+		},
+		{
+			Name:     "import-cclf-directory",
+			Category: "Data import",
+			Usage:    "Import the CCLF8 and CCLF9 files in a directory or ZIP archive",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "directory",
+					Usage:       "Path to directory or ZIP archive of CCLF files",
+					Destination: &filePath,
+				},
+				cli.BoolFlag{
+					Name:        "force",
+					Usage:       "Import files even if they have been imported before, or are older than the last file of their kind imported",
+					Destination: &force,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return importCCLFDirectory(app.Writer, filePath, force)
+			},
+		},
+		{
+			Name:     "list-cclf-imports",
+			Category: "Data import",
+			Usage:    "List the CCLF files imported for an ACO",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "cms-id",
+					Usage:       "CMS ID of ACO",
+					Destination: &acoCMSID,
+				},
 			},
 			Action: func(c *cli.Context) error {
-				return importCCLF9(filePath)
+				return listCCLFImports(app.Writer, acoCMSID)
 			},
 		},
 	}
@@ -395,12 +867,32 @@ func createAccessToken(ID string, secret string) (string, error) {
 	return token.TokenString, nil
 }
 
-func revokeAccessToken(accessToken string) error {
+func revokeAccessToken(accessToken, reason string) error {
 	if accessToken == "" {
 		return errors.New("Access token (--access-token) must be provided")
 	}
 
-	return auth.GetProvider().RevokeAccessToken(accessToken)
+	provider := auth.GetProvider()
+	if reason != "" {
+		if p, ok := provider.(interface {
+			RevokeAccessTokenWithReason(tokenString, reason string) error
+		}); ok {
+			return p.RevokeAccessTokenWithReason(accessToken, reason)
+		}
+		return fmt.Errorf("--reason is not supported by the %s auth provider", auth.GetProviderName())
+	}
+	return provider.RevokeAccessToken(accessToken)
+}
+
+func revokeACOTokens(acoID, reason string) (int64, error) {
+	if acoID == "" {
+		return 0, errors.New("ACO ID (--aco-id) must be provided")
+	}
+	if auth.GetProviderName() != auth.Alpha {
+		return 0, fmt.Errorf("revoking all tokens for an ACO is not supported by the %s auth provider", auth.GetProviderName())
+	}
+
+	return auth.RevokeACOTokens(acoID, reason)
 }
 
 func validateAlphaTokenInputs(ttl, acoSize string) (int, error) {
@@ -421,25 +913,43 @@ func validateAlphaTokenInputs(ttl, acoSize string) (int, error) {
 	}
 }
 
-func createAlphaToken(ttl int, acoSize string) (string, error) {
+func createAlphaToken(ttl int, acoSize, scope string) (string, error) {
+	if _, err := auth.NormalizeScopes(scope); err != nil {
+		return "", err
+	}
+
 	aco, err := createAlphaEntities(acoSize)
 	if err != nil {
 		return "", err
 	}
 
-	creds, err := auth.GetProvider().RegisterClient(aco.UUID.String())
+	// the secret expires when the alpha participant's access should
+	var creds auth.Credentials
+	if alpha, ok := auth.GetProvider().(auth.AlphaAuthPlugin); ok {
+		creds, err = alpha.RegisterClientWithTTL(aco.UUID.String(), ttl)
+	} else {
+		creds, err = auth.GetProvider().RegisterClient(aco.UUID.String())
+	}
 	if err != nil {
 		return "", fmt.Errorf("could not register client for %s (%s) because %s", aco.UUID.String(), aco.Name, err.Error())
 	}
 
 	db := database.GetGORMDbConnection()
 	defer database.Close(db)
-	// Only update aco.ClientID.  Other attributes of this ACO (AlphaSecret) may have been altered in the database by the
-	// RegisterClient() call above, so we should not save these potentially stale values.
-	err = db.Model(&aco).Update("client_id", creds.ClientID).Error
+	// Only update aco.ClientID and the provider it is enrolled in.  Other attributes of this ACO (AlphaSecret) may have
+	// been altered in the database by the RegisterClient() call above, so we should not save these potentially stale
+	// values.
+	err = db.Model(&aco).Updates(map[string]interface{}{"client_id": creds.ClientID, "auth_provider": auth.GetProviderName()}).Error
 	if err != nil {
 		return "", fmt.Errorf("could not save ClientID %s to ACO %s (%s) because %s", aco.ClientID, aco.UUID.String(), aco.Name, err.Error())
 	}
+	auth.NotifyACOChanged(aco.UUID.String())
+
+	if scope != "" {
+		if err = auth.SetClientScope(creds.ClientID, scope); err != nil {
+			return "", err
+		}
+	}
 
 	msg := fmt.Sprintf("%s\n%s\n%s", creds.ClientName, creds.ClientID, creds.ClientSecret)
 
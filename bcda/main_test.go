@@ -265,6 +265,8 @@ func (s *MainTestSuite) TestCreateAlphaTokenCLI() {
 	aco, err := auth.GetACOByClientID(clientID)
 	assert.Nil(err)
 	assert.NotEmpty(aco.AlphaSecret)
+	// the secret expires with the alpha participant's access
+	assert.NotNil(aco.AlphaSecretExpiresAt)
 	buf.Reset()
 
 	args = []string{"bcda", "create-alpha-token", "--size", "DEV"}
@@ -568,12 +570,135 @@ func (s *MainTestSuite) TestRevokeToken() {
 	assert.Equal(0, buf.Len())
 	buf.Reset()
 
-	// Expect (for the moment) that alpha auth does not implement
-	args = []string{"bcda", "revoke-token", "--access-token", "this-token-value-is-immaterial"}
+	// Negative case - not a token
+	args = []string{"bcda", "revoke-token", "--access-token", "this-token-value-is-not-a-token"}
 	err = s.testApp.Run(args)
-	assert.Contains(err.Error(), "not implemented")
+	assert.NotNil(err)
 	assert.Equal(0, buf.Len())
 	buf.Reset()
+
+	// Positive case
+	msg, err := createAlphaToken(1, "Dev", "")
+	assert.Nil(err)
+	creds := strings.Split(msg, "\n")
+	accessToken, err := auth.GetProvider().MakeAccessToken(auth.Credentials{ClientID: creds[1], ClientSecret: creds[2]})
+	assert.Nil(err)
+	args = []string{"bcda", "revoke-token", "--access-token", accessToken, "--reason", "test"}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Contains(buf.String(), "Access token has been deactivated")
+	assert.NotNil(auth.GetProvider().ValidateJWT(accessToken))
+	buf.Reset()
+}
+
+func (s *MainTestSuite) TestRevokeACOTokens() {
+	originalAuthProvider := auth.GetProviderName()
+	defer auth.SetProvider(originalAuthProvider)
+	auth.SetProvider("alpha")
+	s.SetupAuthBackend()
+
+	assert := assert.New(s.T())
+
+	buf := new(bytes.Buffer)
+	s.testApp.Writer = buf
+
+	args := []string{"bcda", "revoke-aco-tokens", "--aco-id", ""}
+	err := s.testApp.Run(args)
+	assert.Equal("ACO ID (--aco-id) must be provided", err.Error())
+	assert.Equal(0, buf.Len())
+
+	msg, err := createAlphaToken(1, "Dev", "")
+	assert.Nil(err)
+	creds := strings.Split(msg, "\n")
+	acoID := creds[1]
+	accessToken, err := auth.GetProvider().MakeAccessToken(auth.Credentials{ClientID: creds[1], ClientSecret: creds[2]})
+	assert.Nil(err)
+
+	args = []string{"bcda", "revoke-aco-tokens", "--aco-id", acoID, "--reason", "incident response"}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Contains(buf.String(), fmt.Sprintf("1 access tokens for ACO %s have been deactivated", acoID))
+	assert.NotNil(auth.GetProvider().ValidateJWT(accessToken))
+	buf.Reset()
+
+	auth.SetProvider("okta")
+	args = []string{"bcda", "revoke-aco-tokens", "--aco-id", acoID}
+	err = s.testApp.Run(args)
+	assert.Contains(err.Error(), "not supported")
+}
+
+func (s *MainTestSuite) TestGenerateClientCredentials() {
+	originalAuthProvider := auth.GetProviderName()
+	defer auth.SetProvider(originalAuthProvider)
+	auth.SetProvider("alpha")
+	s.SetupAuthBackend()
+
+	assert := assert.New(s.T())
+
+	buf := new(bytes.Buffer)
+	s.testApp.Writer = buf
+
+	msg, err := createAlphaToken(1, "Dev", "")
+	assert.Nil(err)
+	creds := strings.Split(msg, "\n")
+
+	args := []string{"bcda", "generate-client-credentials", "--client-id", creds[1], "--ttl", "-1"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "invalid argument '-1' for --ttl; should be an integer >= 0")
+
+	args = []string{"bcda", "generate-client-credentials", "--client-id", creds[1], "--ttl", "24"}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	rotated := strings.Split(buf.String(), "\n")
+	assert.Equal(creds[1], rotated[0])
+	_, err = auth.GetProvider().MakeAccessToken(auth.Credentials{ClientID: creds[1], ClientSecret: creds[2]})
+	assert.NotNil(err)
+	_, err = auth.GetProvider().MakeAccessToken(auth.Credentials{ClientID: rotated[0], ClientSecret: rotated[1]})
+	assert.Nil(err)
+	buf.Reset()
+
+	// expire the secret, then clean it up
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	err = db.Model(&models.ACO{}).Where("client_id = ?", creds[1]).Update("alpha_secret_expires_at", time.Now().Add(-time.Hour)).Error
+	assert.Nil(err)
+
+	args = []string{"bcda", "deactivate-expired-credentials"}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Regexp(`[1-9]\d* expired client credentials deactivated`, buf.String())
+	aco, err := auth.GetACOByClientID(creds[1])
+	assert.Nil(err)
+	assert.Empty(aco.AlphaSecret)
+}
+
+func (s *MainTestSuite) TestUnlockClient() {
+	assert := assert.New(s.T())
+
+	buf := new(bytes.Buffer)
+	s.testApp.Writer = buf
+
+	clientID := uuid.NewRandom().String()
+	lockedUntil := time.Now().Add(time.Hour)
+	db := database.GetGORMDbConnection()
+	defer database.Close(db)
+	err := db.Create(&auth.Lockout{Principal: "client:" + clientID, Failures: 5, Lockouts: 1, LockedUntil: &lockedUntil}).Error
+	assert.Nil(err)
+
+	args := []string{"bcda", "unlock-client"}
+	err = s.testApp.Run(args)
+	assert.EqualError(err, "client ID or address required")
+
+	args = []string{"bcda", "unlock-client", "--client-id", clientID}
+	err = s.testApp.Run(args)
+	assert.Nil(err)
+	assert.Contains(buf.String(), "Unlocked")
+	var count int
+	db.Model(&auth.Lockout{}).Where("principal = ?", "client:"+clientID).Count(&count)
+	assert.Equal(0, count)
+
+	err = s.testApp.Run(args)
+	assert.EqualError(err, fmt.Sprintf("no failed token requests recorded for client:%s", clientID))
 }
 
 func (s *MainTestSuite) TestStartApi() {
@@ -590,7 +715,7 @@ func (s *MainTestSuite) TestStartApi() {
 }
 
 func (s *MainTestSuite) TestCreateAlphaToken() {
-	msg, err := createAlphaToken(1000, "dev")
+	msg, err := createAlphaToken(1000, "dev", "")
 	assert.NotEmpty(s.T(), msg)
 	assert.Nil(s.T(), err)
 }
@@ -59,6 +59,13 @@ type GoneResponse struct {
 	Body OperationOutcomeResponse
 }
 
+// The request is not allowed, such as from an address outside the ACO's allowlist. The body will contain a FHIR OperationOutcome resource in JSON format. https://www.hl7.org/fhir/operationoutcome.html
+// swagger:response forbiddenResponse
+type ForbiddenResponse struct {
+	// in: body
+	Body OperationOutcomeResponse
+}
+
 // An error occurred. The body will contain a FHIR OperationOutcome resource in JSON format. https://www.hl7.org/fhir/operationoutcome.html Please refer to the body of the response for details.
 // swagger:response errorResponse
 type ErrorResponse struct {
@@ -144,6 +151,73 @@ type TokenResponse struct {
 		// Required: true
 		AccessToken string `json:"access_token"`
 		TokenType   string `json:"token_type"`
+		// Seconds until the token expires
+		ExpiresIn int64 `json:"expires_in"`
+		// Space delimited scopes the token grants
+		Scope string `json:"scope"`
+	}
+}
+
+// SMART Backend Services token request. Clients authenticating with Basic credentials send none of these.
+// swagger:parameters GetAuthToken
+type TokenRequestParams struct {
+	// in: formData
+	// enum: client_credentials
+	GrantType string `json:"grant_type"`
+	// Space delimited scopes requested, such as system/*.read
+	// in: formData
+	Scope string `json:"scope"`
+	// in: formData
+	// enum: urn:ietf:params:oauth:client-assertion-type:jwt-bearer
+	ClientAssertionType string `json:"client_assertion_type"`
+	// JWT signed with a key registered for the client
+	// in: formData
+	ClientAssertion string `json:"client_assertion"`
+}
+
+// Token to introspect
+// swagger:parameters IntrospectToken
+type IntrospectionRequestParams struct {
+	// Access token an ACO presented to the resource server
+	// in: formData
+	// required: true
+	Token string `json:"token"`
+}
+
+// Whether a token is active and, if it is, what it grants
+// swagger:response introspectionResponse
+type IntrospectionResponse struct {
+	// in: body
+	Body struct {
+		// Required: true
+		Active   bool   `json:"active"`
+		ClientID string `json:"client_id"`
+		// UUID of the ACO the token was issued to
+		ACOID string `json:"aco"`
+		// Space delimited scopes the token grants
+		Scope string `json:"scope"`
+		// When the token expires, in seconds since the epoch
+		Exp int64 `json:"exp"`
+		// The client certificate the token is bound to, if any, by its x5t#S256 thumbprint
+		Cnf struct {
+			X5TS256 string `json:"x5t#S256"`
+		} `json:"cnf"`
+	}
+}
+
+// JSON Web Key Set of the public keys that verify access tokens
+// swagger:response jwksResponse
+type JWKSResponse struct {
+	// in: body
+	Body struct {
+		Keys []struct {
+			KeyType   string `json:"kty"`
+			Algorithm string `json:"alg"`
+			KeyID     string `json:"kid"`
+			Use       string `json:"use"`
+			E         string `json:"e"`
+			N         string `json:"n"`
+		} `json:"keys"`
 	}
 }
 
@@ -151,6 +225,10 @@ type TokenResponse struct {
 // swagger:response missingCredentials
 type MissingCredentials struct{}
 
+// Invalid request
+// swagger:response invalidRequest
+type InvalidRequest struct{}
+
 // Invalid credentials
 // swagger:response invalidCredentials
 type InvalidCredentials struct{}
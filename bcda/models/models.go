@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/CMSgov/bcda-app/bcda/database"
 	"github.com/CMSgov/bcda-app/bcda/utils"
@@ -34,10 +35,17 @@ func InitializeGormModels() *gorm.DB {
 		&JobKey{},
 		&Beneficiary{},
 		&ACOBeneficiary{},
+		&BeneficiaryIdentifier{},
+		&CCLFFile{},
 	)
 
 	db.Model(&ACOBeneficiary{}).AddForeignKey("aco_id", "acos(uuid)", "RESTRICT", "RESTRICT")
 	db.Model(&ACOBeneficiary{}).AddForeignKey("beneficiary_id", "beneficiaries(id)", "RESTRICT", "RESTRICT")
+	db.Model(&BeneficiaryIdentifier{}).AddForeignKey("beneficiary_id", "beneficiaries(id)", "RESTRICT", "RESTRICT")
+	// beneficiaries loaded before CCLF8 files were imported have no MBI
+	if err := db.Exec("create unique index if not exists idx_beneficiaries_mbi_unique on beneficiaries (mbi) where mbi <> ''").Error; err != nil {
+		log.Errorf("Unable to create unique index on beneficiary MBIs: %s", err)
+	}
 
 	return db
 }
@@ -113,7 +121,8 @@ func (job *Job) GetEnqueJobs(encrypt bool, t string) (enqueJobs []*que.Job, err
 				BeneficiaryIDs: jobIDs,
 				ResourceType:   t,
 				// TODO: remove `Encrypt` when file encryption disable functionality is ready to be deprecated
-				Encrypt: encrypt,
+				Encrypt:          encrypt,
+				EncryptionFormat: aco.EncryptionFormat,
 			})
 			if err != nil {
 				return nil, err
@@ -134,10 +143,23 @@ func (job *Job) GetEnqueJobs(encrypt bool, t string) (enqueJobs []*que.Job, err
 
 type JobKey struct {
 	gorm.Model
-	Job          Job  `gorm:"foreignkey:jobID"`
-	JobID        uint `gorm:"primary_key" json:"job_id"`
-	EncryptedKey []byte
-	FileName     string `gorm:"type:char(127)"`
+	Job              Job  `gorm:"foreignkey:jobID"`
+	JobID            uint `gorm:"primary_key" json:"job_id"`
+	EncryptedKey     []byte
+	FileName         string `gorm:"type:char(127)"`
+	EncryptionFormat string `json:"encryption_format"`
+}
+
+// Values for ACO.EncryptionFormat and JobKey.EncryptionFormat
+const (
+	// AES-GCM payload with an RSA-OAEP encrypted key, as read by the decryption_utils
+	EncryptionFormatBCDA = ""
+	// JSON Web Encryption payload with its key delivered as a compact JWE
+	EncryptionFormatJWE = "jwe"
+)
+
+func IsValidEncryptionFormat(format string) bool {
+	return format == EncryptionFormatBCDA || format == EncryptionFormatJWE
 }
 
 // ACO-Beneficiary relationship models based on https://github.com/jinzhu/gorm/issues/719#issuecomment-168485989
@@ -148,18 +170,36 @@ type ACO struct {
 	Name             string    `json:"name"`
 	ClientID         string    `json:"client_id"`
 	AlphaSecret      string    `json:"alpha_secret"`
-	ACOBeneficiaries []*ACOBeneficiary
+	EncryptionFormat string    `json:"encryption_format"`
+	// The auth provider whose tokens this ACO uses; any accepted provider when empty
+	AuthProvider string `json:"auth_provider"`
+	// When the alpha secret stops working; never when empty
+	AlphaSecretExpiresAt *time.Time `json:"alpha_secret_expires_at"`
+	ACOBeneficiaries     []*ACOBeneficiary
 }
 
 func (aco *ACO) GetBeneficiaryIDs() (beneficiaryIDs []string, err error) {
 	db := database.GetGORMDbConnection()
 	defer database.Close(db)
 
+	var blueButtonIDs []string
 	beneficiaryJoin := "inner join beneficiaries on acos_beneficiaries.beneficiary_id = beneficiaries.id"
-	if err = db.Table("acos_beneficiaries").Joins(beneficiaryJoin).Where("acos_beneficiaries.aco_id = ?", aco.UUID).Pluck("beneficiaries.blue_button_id", &beneficiaryIDs).Error; err != nil {
+	if err = db.Table("acos_beneficiaries").Joins(beneficiaryJoin).Where("acos_beneficiaries.aco_id = ?", aco.UUID).Pluck("coalesce(beneficiaries.blue_button_id, '')", &blueButtonIDs).Error; err != nil {
 		log.Errorf("Error retrieving ACO-beneficiaries for ACO ID %s: %s", aco.UUID.String(), err.Error())
 		return nil, err
-	} else if len(beneficiaryIDs) == 0 {
+	}
+
+	// beneficiaries imported from CCLF files have no Blue Button ID until one is found for them
+	for _, id := range blueButtonIDs {
+		if id != "" {
+			beneficiaryIDs = append(beneficiaryIDs, id)
+		}
+	}
+	if skipped := len(blueButtonIDs) - len(beneficiaryIDs); skipped > 0 {
+		log.Warnf("Skipped %d ACO-beneficiaries with no Blue Button ID for ACO ID %s", skipped, aco.UUID.String())
+	}
+
+	if len(beneficiaryIDs) == 0 {
 		log.Errorf("Retrieved 0 ACO-beneficiaries for ACO ID %s", aco.UUID.String())
 		return nil, fmt.Errorf("retrieved 0 ACO-beneficiaries for ACO ID %s", aco.UUID.String())
 	}
@@ -170,6 +210,10 @@ func (aco *ACO) GetBeneficiaryIDs() (beneficiaryIDs []string, err error) {
 type Beneficiary struct {
 	gorm.Model
 	BlueButtonID string `gorm:"type: text"`
+	// Medicare Beneficiary Identifier, as given in CCLF8 files; unique when not empty
+	MBI string `gorm:"type:char(11);index"`
+	// Health Insurance Claim Number, the identifier the MBI replaced
+	HICN string `gorm:"type:varchar(11);index"`
 }
 
 type ACOBeneficiary struct {
@@ -184,6 +228,239 @@ func (*ACOBeneficiary) TableName() string {
 	return "acos_beneficiaries"
 }
 
+// RosterCounts counts the changes made to an ACO's roster by ReplaceACOBeneficiaries
+type RosterCounts struct {
+	Added     int
+	Removed   int
+	Unchanged int
+}
+
+// ReplaceACOBeneficiaries makes benes, identified by their MBIs, the roster of the ACO with acoID. Beneficiaries not
+// seen before are created, and the HICNs of those that have changed are updated. Either the whole roster is
+// replaced or, if there is an error, none of it is.
+func ReplaceACOBeneficiaries(db *gorm.DB, acoID uuid.UUID, benes []Beneficiary) (counts RosterCounts, err error) {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return counts, tx.Error
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var existing []uint
+	if err = tx.Model(&ACOBeneficiary{}).Where("aco_id = ?", acoID).Pluck("beneficiary_id", &existing).Error; err != nil {
+		return counts, err
+	}
+	onRoster := make(map[uint]bool, len(existing))
+	for _, id := range existing {
+		onRoster[id] = true
+	}
+
+	kept := make(map[uint]bool, len(benes))
+	for _, b := range benes {
+		var id uint
+		if id, err = upsertBeneficiary(tx, b); err != nil {
+			return counts, err
+		}
+		if kept[id] {
+			continue
+		}
+		kept[id] = true
+
+		if onRoster[id] {
+			counts.Unchanged++
+			continue
+		}
+		if err = tx.Create(&ACOBeneficiary{ACOID: acoID, BeneficiaryID: id}).Error; err != nil {
+			return counts, err
+		}
+		counts.Added++
+	}
+
+	var removed []uint
+	for id := range onRoster {
+		if !kept[id] {
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) > 0 {
+		if err = tx.Where("aco_id = ? and beneficiary_id in (?)", acoID, removed).Delete(ACOBeneficiary{}).Error; err != nil {
+			return counts, err
+		}
+	}
+	counts.Removed = len(removed)
+
+	return counts, tx.Commit().Error
+}
+
+// upsertBeneficiary returns the ID of the beneficiary with the MBI of b, creating it if there is none
+func upsertBeneficiary(db *gorm.DB, b Beneficiary) (uint, error) {
+	var found Beneficiary
+	err := db.First(&found, "mbi = ?", b.MBI).Error
+	if gorm.IsRecordNotFoundError(err) {
+		err = db.Create(&b).Error
+		return b.ID, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if found.HICN != b.HICN {
+		if err = db.Model(&found).Update("hicn", b.HICN).Error; err != nil {
+			return 0, err
+		}
+	}
+	return found.ID, nil
+}
+
+// Beneficiary identifier types
+const (
+	IdentifierMBI  = "MBI"
+	IdentifierHICN = "HICN"
+)
+
+// BeneficiaryIdentifier is an identifier a beneficiary had before the one in its record, as given in CCLF9 files
+type BeneficiaryIdentifier struct {
+	gorm.Model
+	BeneficiaryID uint         `gorm:"index"`
+	Beneficiary   *Beneficiary `gorm:"foreignkey:BeneficiaryID;association_foreignkey:ID"`
+	// IdentifierMBI or IdentifierHICN
+	Type       string `gorm:"type:varchar(4)"`
+	Identifier string `gorm:"type:varchar(11);index"`
+	// When the identifier came into use and when it stopped being used, if known
+	EffectiveDate *time.Time
+	ObsoleteDate  *time.Time
+}
+
+// IdentifierXref is a CCLF9 record, which says that a beneficiary's identifier of type Type has changed from
+// Previous to Current
+type IdentifierXref struct {
+	Type          string
+	Current       string
+	Previous      string
+	EffectiveDate *time.Time
+	ObsoleteDate  *time.Time
+}
+
+// XrefCounts counts the changes made to beneficiary records by ApplyIdentifierXrefs
+type XrefCounts struct {
+	// identifiers replaced with current ones
+	Updated int
+	// previous identifiers added to beneficiaries' histories
+	Recorded int
+	// records naming no beneficiary we know
+	Unmatched int
+}
+
+// FindBeneficiaryByIdentifier returns the beneficiary identified by id, which may be its MBI, its HICN, or an
+// identifier it had before either
+func FindBeneficiaryByIdentifier(db *gorm.DB, id string) (Beneficiary, error) {
+	var bene Beneficiary
+	err := db.Where("mbi = ? or hicn = ?", id, id).Order("id").First(&bene).Error
+	if !gorm.IsRecordNotFoundError(err) {
+		return bene, err
+	}
+
+	var previous BeneficiaryIdentifier
+	if err = db.Where("identifier = ?", id).Order("id desc").First(&previous).Error; err != nil {
+		return bene, err
+	}
+	err = db.First(&bene, previous.BeneficiaryID).Error
+	return bene, err
+}
+
+// ApplyIdentifierXrefs brings the beneficiaries named by xrefs up to date: each is given its current identifier,
+// and its previous identifier is added to its history, where FindBeneficiaryByIdentifier can find it. Records for
+// beneficiaries we don't know are skipped. Either every record is applied or, if there is an error, none are.
+func ApplyIdentifierXrefs(db *gorm.DB, xrefs []IdentifierXref) (counts XrefCounts, err error) {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return counts, tx.Error
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, x := range xrefs {
+		column := "mbi"
+		if x.Type == IdentifierHICN {
+			column = "hicn"
+		} else if x.Type != IdentifierMBI {
+			return counts, fmt.Errorf("invalid identifier type %q", x.Type)
+		}
+
+		// the beneficiary may already have its current identifier, or have moved on from it, in which case it is
+		// left alone; or it may still have its previous one
+		var bene Beneficiary
+		bene, err = FindBeneficiaryByIdentifier(tx, x.Current)
+		if gorm.IsRecordNotFoundError(err) {
+			if bene, err = FindBeneficiaryByIdentifier(tx, x.Previous); err == nil {
+				if err = tx.Model(&bene).Update(column, x.Current).Error; err != nil {
+					return counts, err
+				}
+				counts.Updated++
+			}
+		}
+		if gorm.IsRecordNotFoundError(err) {
+			counts.Unmatched++
+			continue
+		}
+		if err != nil {
+			return counts, err
+		}
+
+		if x.Previous == "" || x.Previous == x.Current {
+			continue
+		}
+		previous := BeneficiaryIdentifier{BeneficiaryID: bene.ID, Type: x.Type, Identifier: x.Previous}
+		if !tx.Where(previous).First(&BeneficiaryIdentifier{}).RecordNotFound() {
+			continue
+		}
+		previous.EffectiveDate, previous.ObsoleteDate = x.EffectiveDate, x.ObsoleteDate
+		if err = tx.Create(&previous).Error; err != nil {
+			return counts, err
+		}
+		counts.Recorded++
+	}
+
+	return counts, tx.Commit().Error
+}
+
+// CCLF file import statuses
+const (
+	CCLFStatusImporting = "Importing"
+	CCLFStatusCompleted = "Completed"
+	CCLFStatusFailed    = "Failed"
+)
+
+// CCLFFile records the import of a CCLF file, and what its name says about it
+type CCLFFile struct {
+	gorm.Model
+	Name string `gorm:"type:text"`
+	// CMS ID of the ACO the file is for
+	ACOCMSID        string `gorm:"column:aco_cms_id;type:char(5);index"`
+	CCLFNum         int
+	PerformanceYear int
+	// When the file was made
+	Timestamp time.Time
+	// test or production
+	Env string `gorm:"type:varchar(10)"`
+	// SHA-256 of the file's contents, in hex
+	Checksum string `gorm:"type:char(64);index"`
+	Status   string `gorm:"type:varchar(10)"`
+	// Records read from the file, and how many of them named a beneficiary that was imported or updated
+	Records  int
+	Imported int
+}
+
+func (*CCLFFile) TableName() string {
+	return "cclf_files"
+}
+
 func (aco *ACO) GetPublicKey() *rsa.PublicKey {
 	// todo implement a real thing.  But for now we can use this.
 	return GetATOPublicKey()
@@ -274,5 +551,6 @@ type jobEnqueueArgs struct {
 	BeneficiaryIDs []string
 	ResourceType   string
 	// TODO: remove `Encrypt` when file encryption disable functionality is ready to be deprecated
-	Encrypt bool
+	Encrypt          bool
+	EncryptionFormat string
 }
@@ -237,6 +237,50 @@ func (s *ModelsTestSuite) TestGetEnqueJobs() {
 
 }
 
+func (s *ModelsTestSuite) TestGetBeneficiaryIDsWithoutBlueButtonIDs() {
+	assert := s.Assert()
+
+	cmsID := "A0003"
+	acoUUID, err := CreateACO("Beneficiaries Without Blue Button IDs ACO", &cmsID)
+	assert.Nil(err)
+	defer s.db.Unscoped().Delete(&ACO{}, "uuid = ?", acoUUID)
+
+	withID := Beneficiary{BlueButtonID: "12345", MBI: "1A69B98CD97"}
+	withoutID := Beneficiary{MBI: "1A69B98CD98"}
+	assert.Nil(s.db.Create(&withID).Error)
+	assert.Nil(s.db.Create(&withoutID).Error)
+	defer s.db.Unscoped().Delete(&Beneficiary{}, "id in (?)", []uint{withID.ID, withoutID.ID})
+	defer s.db.Unscoped().Delete(&ACOBeneficiary{}, "aco_id = ?", acoUUID)
+	assert.Nil(s.db.Create(&ACOBeneficiary{ACOID: acoUUID, BeneficiaryID: withID.ID}).Error)
+	assert.Nil(s.db.Create(&ACOBeneficiary{ACOID: acoUUID, BeneficiaryID: withoutID.ID}).Error)
+
+	// beneficiaries with no Blue Button ID can't be exported
+	aco := ACO{UUID: acoUUID}
+	beneficiaryIDs, err := aco.GetBeneficiaryIDs()
+	assert.Nil(err)
+	assert.Equal([]string{"12345"}, beneficiaryIDs)
+
+	s.db.Unscoped().Delete(&ACOBeneficiary{}, "aco_id = ? and beneficiary_id = ?", acoUUID, withID.ID)
+	beneficiaryIDs, err = aco.GetBeneficiaryIDs()
+	assert.EqualError(err, "retrieved 0 ACO-beneficiaries for ACO ID "+acoUUID.String())
+	assert.Nil(beneficiaryIDs)
+}
+
+func (s *ModelsTestSuite) TestBeneficiaryMBIUnique() {
+	assert := s.Assert()
+
+	first := Beneficiary{BlueButtonID: "12345", MBI: "1A69B98CD96"}
+	assert.Nil(s.db.Create(&first).Error)
+	defer s.db.Unscoped().Delete(&Beneficiary{}, "id = ?", first.ID)
+	assert.NotNil(s.db.Create(&Beneficiary{BlueButtonID: "12346", MBI: "1A69B98CD96"}).Error)
+
+	// many beneficiaries have no MBI
+	a, b := Beneficiary{BlueButtonID: "12347"}, Beneficiary{BlueButtonID: "12348"}
+	assert.Nil(s.db.Create(&a).Error)
+	assert.Nil(s.db.Create(&b).Error)
+	s.db.Unscoped().Delete(&Beneficiary{}, "id in (?)", []uint{a.ID, b.ID})
+}
+
 func (s *ModelsTestSuite) TestGetBeneficiaryIDs() {
 	assert := s.Assert()
 	var aco, smallACO, mediumACO, largeACO ACO
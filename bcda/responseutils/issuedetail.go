@@ -8,4 +8,6 @@ const (
 	BbErr       = "Blue Button Error"
 	InternalErr = "Internal Error"
 	RequestErr  = "Request Error"
+	ScopeErr    = "Insufficient Scope"
+	AddressErr  = "Address Not Allowed"
 )
@@ -19,12 +19,12 @@ func NewAPIRouter() http.Handler {
 	FileServer(r, "/api/v1/swagger", http.Dir("./swaggerui"))
 	FileServer(r, "/", http.Dir("./_site"))
 	r.Route("/api/v1", func(r chi.Router) {
-		r.With(auth.RequireTokenAuth, ValidateBulkRequestHeaders).Get(m.WrapHandler("/ExplanationOfBenefit/$export", bulkEOBRequest))
+		r.With(auth.RequireTokenAuth, auth.RequireScope("ExplanationOfBenefit"), ValidateBulkRequestHeaders).Get(m.WrapHandler("/ExplanationOfBenefit/$export", bulkEOBRequest))
 		if os.Getenv("ENABLE_PATIENT_EXPORT") == "true" {
-			r.With(auth.RequireTokenAuth, ValidateBulkRequestHeaders).Get(m.WrapHandler("/Patient/$export", bulkPatientRequest))
+			r.With(auth.RequireTokenAuth, auth.RequireScope("Patient"), ValidateBulkRequestHeaders).Get(m.WrapHandler("/Patient/$export", bulkPatientRequest))
 		}
 		if os.Getenv("ENABLE_COVERAGE_EXPORT") == "true" {
-			r.With(auth.RequireTokenAuth, ValidateBulkRequestHeaders).Get(m.WrapHandler("/Coverage/$export", bulkCoverageRequest))
+			r.With(auth.RequireTokenAuth, auth.RequireScope("Coverage"), ValidateBulkRequestHeaders).Get(m.WrapHandler("/Coverage/$export", bulkCoverageRequest))
 		}
 		r.With(auth.RequireTokenAuth, auth.RequireTokenJobMatch).Get(m.WrapHandler("/jobs/{jobID}", jobStatus))
 		r.Get(m.WrapHandler("/metadata", metadata))
@@ -43,7 +43,7 @@ func NewDataRouter() http.Handler {
 	r := chi.NewRouter()
 	m := monitoring.GetMonitor()
 	r.Use(auth.ParseToken, logging.NewStructuredLogger(), HSTSHeader, ConnectionClose)
-	r.With(auth.RequireTokenAuth, auth.RequireTokenJobMatch).
+	r.With(auth.RequireTokenAuth, auth.RequireTokenJobMatch, auth.RequireJobScope).
 		Get(m.WrapHandler("/data/{jobID}/{fileName}", serveData))
 	return r
 }
@@ -2,14 +2,19 @@ package servicemux
 
 import (
 	"bufio"
+	"context"
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -119,6 +124,16 @@ func (sm *ServiceMux) serveHTTPS(tlsCertPath, tlsKeyPath string) {
 		},
 	}
 
+	// Client certificates are optional; a client that presents none authenticates with a bearer token instead
+	if clientCAPath := os.Getenv("BCDA_TLS_CLIENT_CA"); clientCAPath != "" {
+		pool, err := clientCAs(clientCAPath)
+		if err != nil {
+			log.Panic(err)
+		}
+		sm.TLSConfig.ClientCAs = pool
+		sm.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
 	sm.Listener = tls.NewListener(sm.Listener, &sm.TLSConfig)
 
 	sm.serveHTTP()
@@ -126,6 +141,7 @@ func (sm *ServiceMux) serveHTTPS(tlsCertPath, tlsKeyPath string) {
 
 func (sm *ServiceMux) serveHTTP() {
 	m := cmux.New(sm.Listener)
+	conns := &tlsConns{conns: make(map[string]*tls.Conn)}
 
 	for _, server := range sm.Servers {
 		for srv, path := range server {
@@ -138,9 +154,10 @@ func (sm *ServiceMux) serveHTTP() {
 			}
 
 			srv.TLSConfig = &sm.TLSConfig
+			srv.Handler = conns.withTLSConn(srv.Handler)
 
 			//nolint
-			go srv.Serve(match)
+			go srv.Serve(tlsTrackingListener{match, conns})
 		}
 	}
 
@@ -150,6 +167,115 @@ func (sm *ServiceMux) serveHTTP() {
 	}
 }
 
+// clientCAs reads the PEM bundle of the CAs whose client certificates are accepted
+func clientCAs(path string) (*x509.CertPool, error) {
+	/* #nosec -- path is from the environment, not the request */
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in client CA bundle " + path)
+	}
+	return pool, nil
+}
+
+type tlsConnKey struct{}
+
+// tlsConns holds the TLS connections being served, by the address of the client at the other end. The servers
+// can't find a request's TLS connection, and so don't set Request.TLS, because cmux hands them each connection
+// wrapped in a cmux.MuxConn.
+type tlsConns struct {
+	sync.RWMutex
+	conns map[string]*tls.Conn
+}
+
+func (cs *tlsConns) add(addr string, tc *tls.Conn) {
+	cs.Lock()
+	defer cs.Unlock()
+	cs.conns[addr] = tc
+}
+
+func (cs *tlsConns) remove(addr string, tc *tls.Conn) {
+	cs.Lock()
+	defer cs.Unlock()
+	// the address may have been reused by a newer connection
+	if cs.conns[addr] == tc {
+		delete(cs.conns, addr)
+	}
+}
+
+func (cs *tlsConns) get(addr string) *tls.Conn {
+	cs.RLock()
+	defer cs.RUnlock()
+	return cs.conns[addr]
+}
+
+// withTLSConn puts the TLS connection a request arrived on into its context, before next sees the request
+func (cs *tlsConns) withTLSConn(next http.Handler) http.Handler {
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tc := cs.get(r.RemoteAddr); tc != nil {
+			r = r.WithContext(context.WithValue(r.Context(), tlsConnKey{}, tc))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tlsTrackingListener records the TLS connections it accepts in conns until they are closed
+type tlsTrackingListener struct {
+	net.Listener
+	conns *tlsConns
+}
+
+func (l tlsTrackingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	inner := c
+	if mc, ok := c.(*cmux.MuxConn); ok {
+		inner = mc.Conn
+	}
+	tc, ok := inner.(*tls.Conn)
+	if !ok {
+		return c, nil
+	}
+
+	addr := c.RemoteAddr().String()
+	l.conns.add(addr, tc)
+	return &trackedConn{Conn: c, release: func() { l.conns.remove(addr, tc) }}, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// ClientCertificate returns the verified certificate the client presented, or nil if it presented none
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	state := r.TLS
+	if tc, ok := r.Context().Value(tlsConnKey{}).(*tls.Conn); ok && state == nil {
+		// the handshake is complete by the time a request has been read from the connection
+		cs := tc.ConnectionState()
+		state = &cs
+	}
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
 func (sm *ServiceMux) Close() {
 	err := sm.Listener.Close()
 	if err != nil {
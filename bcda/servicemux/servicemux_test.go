@@ -1,15 +1,22 @@
 package servicemux
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -129,6 +136,108 @@ func (s *ServiceMuxTestSuite) TestServeHTTPS() {
 	assert.Equal(s.T(), "Test", string(body))
 }
 
+func (s *ServiceMuxTestSuite) TestServeHTTPSClientCertificate() {
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cert := ClientCertificate(r)
+			if cert == nil {
+				_, _ = w.Write([]byte("none"))
+				return
+			}
+			_, _ = w.Write([]byte(cert.Subject.CommonName))
+		}),
+	}
+
+	sm := New(getConfig().testAddress)
+	addr := sm.Listener.Addr().String()
+
+	sm.AddServer(srv, "")
+
+	// a client CA, and a client certificate it issued
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(s.T(), err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.Nil(s.T(), err)
+	caCert, err := x509.ParseCertificate(caDER)
+	assert.Nil(s.T(), err)
+	caFile, err := ioutil.TempFile("", "client-ca")
+	assert.Nil(s.T(), err)
+	defer os.Remove(caFile.Name())
+	assert.Nil(s.T(), pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: caDER}))
+	caFile.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(s.T(), err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "A9999 client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	assert.Nil(s.T(), err)
+
+	origTLSCert, origTLSKey, origHTTPOnly := getOrigVars()
+	defer resetOrigVars(origTLSCert, origTLSKey, origHTTPOnly)
+	defer os.Unsetenv("BCDA_TLS_CLIENT_CA")
+
+	os.Setenv("BCDA_TLS_CERT", "../../shared_files/localhost.crt")
+	os.Setenv("BCDA_TLS_KEY", "../../shared_files/localhost.key")
+	os.Setenv("BCDA_TLS_CLIENT_CA", caFile.Name())
+	os.Setenv("HTTP_ONLY", "false")
+
+	go func() {
+		defer sm.Close()
+		sm.Serve()
+	}()
+
+	get := func(certs []tls.Certificate) string {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: certs},
+		}}
+		resp, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			s.T().Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			s.T().Fatal(err)
+		}
+		return string(body)
+	}
+
+	assert.Equal(s.T(), "A9999 client", get([]tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}))
+	// certificates are optional
+	assert.Equal(s.T(), "none", get(nil))
+}
+
+func (s *ServiceMuxTestSuite) TestServeHTTPSBadClientCA() {
+	sm := New(getConfig().testAddress)
+	defer sm.Close()
+
+	origTLSCert, origTLSKey, origHTTPOnly := getOrigVars()
+	defer resetOrigVars(origTLSCert, origTLSKey, origHTTPOnly)
+	defer os.Unsetenv("BCDA_TLS_CLIENT_CA")
+
+	os.Setenv("BCDA_TLS_CERT", "../../shared_files/localhost.crt")
+	os.Setenv("BCDA_TLS_KEY", "../../shared_files/localhost.key")
+	os.Setenv("BCDA_TLS_CLIENT_CA", "config_test.json")
+	os.Setenv("HTTP_ONLY", "false")
+
+	assert.Panics(s.T(), sm.Serve)
+}
+
 func (s *ServiceMuxTestSuite) TestServeHTTPSBadKeypair() {
 	srv := &http.Server{
 		Handler: testHandler,
@@ -231,6 +340,20 @@ func (s *ServiceMuxTestSuite) TestIsHTTPSFalse() {
 	assert.False(s.T(), IsHTTPS(req))
 }
 
+func (s *ServiceMuxTestSuite) TestClientCertificate() {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "client"}}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	assert.Nil(s.T(), ClientCertificate(req))
+
+	// unverified certificates don't count
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	assert.Nil(s.T(), ClientCertificate(req))
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	assert.Equal(s.T(), cert, ClientCertificate(req))
+}
+
 func TestServiceMuxTestSuite(t *testing.T) {
 	suite.Run(t, new(ServiceMuxTestSuite))
 }
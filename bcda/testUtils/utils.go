@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/pem"
 	"fmt"
@@ -11,7 +12,9 @@ import (
 	"github.com/stretchr/testify/suite"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"os"
+	"time"
 )
 
 type AuthTestSuite struct {
@@ -139,3 +142,28 @@ func RandomBase64(n int) string {
 	}
 	return base64.StdEncoding.EncodeToString(b)
 }
+
+// ClientCertificate makes a self-signed TLS client certificate for commonName that expires at notAfter, and returns
+// it both parsed and PEM encoded
+func ClientCertificate(commonName string, notAfter time.Time) (*x509.Certificate, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		log.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
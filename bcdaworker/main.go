@@ -39,7 +39,8 @@ type jobEnqueueArgs struct {
 	BeneficiaryIDs []string
 	ResourceType   string
 	// TODO(rnagle): remove `Encrypt` when file encryption functionality is ready for release
-	Encrypt bool
+	Encrypt          bool
+	EncryptionFormat string
 }
 
 func init() {
@@ -158,7 +159,7 @@ func processJob(j *que.Job) error {
 			if publicKey == nil {
 				fmt.Println("NO KEY EXISTS  THIS IS BAD")
 			} else {
-				err := encryption.EncryptAndMove(staging, data, fileName, exportJob.ACO.GetPublicKey(), exportJob.ID)
+				err := encryption.EncryptAndMove(staging, data, fileName, exportJob.ACO.GetPublicKey(), exportJob.ID, jobArgs.EncryptionFormat)
 				if err != nil {
 					log.Error(err)
 					return err